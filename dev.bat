@@ -8,5 +8,29 @@ set DATABASE_NAME=toystore
 set REDIS_HOST=127.0.0.1
 set REDIS_PORT=6379
 
+set BASE_CURRENCY=RUB
+set DEFAULT_TAX_REGION=RU
+set PAYMENT_WEBHOOK_SECRET=dev_webhook_secret
+set STORE_NAME=toyStore
+set GIFT_WRAP_PRICE=150.00
+set ORDER_EXPIRY_AGE=24h
+set ORDER_EXPIRY_INTERVAL=10m
+set APP_URL=http://localhost:8080
+set MAIL_FROM=noreply@toystore.local
+set MAIL_DIR=mail
+set PASSWORD_RESET_TTL=30m
+set EMAIL_VERIFY_SECRET=dev_email_verify_secret
+set EMAIL_VERIFY_TTL=24h
+set LOGIN_MAX_ATTEMPTS=10
+set LOGIN_IP_MAX_ATTEMPTS=50
+set LOGIN_LOCKOUT=15m
+set TOTP_REQUIRED_ROLES=
+set JWT_SECRET=dev_jwt_secret
+set ACCESS_TOKEN_TTL=15m
+set REFRESH_TOKEN_TTL=720h
+set CSRF_SECRET=dev_csrf_secret
+:: cookie без Secure, чтобы работать по http
+set COOKIE_SECURE=false
+
 :: Запуск Go-приложения
 go run main.go
@@ -10,7 +10,11 @@ type Product struct {
 	Name         string
 	Manufacturer string
 	Quantity     int
-	Price        float64
+	Price        models.Money
+	RegularPrice models.Money
+	SaleEndDate  *time.Time `json:",omitempty"`
+	Weight       int
+	Currency     string
 	Description  string
 	Available    bool
 	Category     Category
@@ -21,26 +25,41 @@ type ProductPreview struct {
 	Id           int
 	Name         string
 	Manufacturer string
-	Price        float64
+	Price        models.Money
+	RegularPrice models.Money
 	Available    bool
 }
 
 type ProductOrderFormat struct {
+	ItemId       int
 	Id           int
 	Name         string
 	Manufacturer string
 	Quantity     int
-	Price        float64
-	TotalPrice   float64
+	Price        models.Money
+	Discount     models.Money
+	Tax          models.Money
+	TaxIncluded  bool
+	TotalPrice   models.Money
 }
 
 type CartItem struct {
-	Id        int
-	Name      string
-	Quantity  int
-	Price     float64
-	SumPrice  float64
-	Available bool
+	Id          int
+	Name        string
+	Quantity    int
+	Price       models.Money
+	SumPrice    models.Money
+	Discount    models.Money
+	Promotions  []AppliedPromotion
+	Tax         models.Money
+	TaxIncluded bool
+	Available   bool
+}
+
+type AppliedPromotion struct {
+	PromotionId int          `json:"promotion_id"`
+	Name        string       `json:"name"`
+	Discount    models.Money `json:"discount"`
 }
 
 type Cart struct {
@@ -54,7 +73,11 @@ type CartRequest struct {
 
 type CartResponse struct {
 	Products   []CartItem
-	TotalPrice float64
+	Discount   models.Money
+	Tax        models.Money
+	TaxRegion  string
+	TotalPrice models.Money
+	Currency   string
 }
 
 type Category struct {
@@ -76,10 +99,64 @@ type ProductAttribute struct {
 }
 
 type Order struct {
-	OrderId    int
-	Date       time.Time
-	Status     string
-	TotalPrice float64
-	UserData   models.UserData
-	Products   []ProductOrderFormat
+	OrderId            int
+	Date               time.Time
+	Status             string
+	TotalPrice         models.Money
+	Discount           models.Money
+	Tax                models.Money
+	TaxRegion          string
+	Currency           string
+	ExchangeRate       models.Rate
+	CurrencyTotalPrice models.Money
+	DeliveryMethodId   int `json:"-"`
+	DeliveryMethod     string
+	ShippingPrice      models.Money
+	ShippingAddress    *models.Address_db `json:",omitempty"`
+	RefundedAmount     models.Money
+	Note               string
+	GiftMessage        string
+	GiftWrap           bool
+	GiftWrapPrice      models.Money
+	UserData           models.UserData
+	Products           []ProductOrderFormat
+	Shipments          []models.Shipment_db
+}
+
+type UserList struct {
+	Users   []models.UserInfo `json:"users"`
+	Total   int               `json:"total"`
+	Page    int               `json:"page"`
+	PerPage int               `json:"per_page"`
+}
+
+type TaxClass struct {
+	Id    int                 `json:"id"`
+	Name  string              `json:"name"`
+	Rates []models.TaxRate_db `json:"rates"`
+}
+
+// Invoice is an order prepared for an invoice or a packing slip, amounts are in the base currency
+type Invoice struct {
+	Number       string
+	Date         time.Time
+	BaseCurrency string
+	Subtotal     models.Money
+	TaxAdded     models.Money
+	Order        Order
+}
+
+const (
+	ReorderAdded   = "added"
+	ReorderReduced = "reduced"
+	ReorderSkipped = "skipped"
+)
+
+type ReorderItem struct {
+	ProductId int    `json:"product_id"`
+	Name      string `json:"name"`
+	Ordered   int    `json:"ordered"`
+	Added     int    `json:"added"`
+	Status    string `json:"status"`
+	Reason    string `json:"reason,omitempty"`
 }
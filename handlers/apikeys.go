@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"toyStore/models"
+
+	"github.com/gorilla/mux"
+)
+
+// api keys
+
+func (h *Handler) GetApiKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.aks.GetApiKeys()
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+func (h *Handler) CreateApiKey(w http.ResponseWriter, r *http.Request) {
+	var req models.ApiKeyRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	newKey, err := h.aks.CreateApiKey(h.sessionId(r), req)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(newKey, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(jsonData)
+}
+
+func (h *Handler) RevokeApiKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = h.aks.RevokeApiKey(id)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
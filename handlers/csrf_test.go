@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"toyStore/services"
+)
+
+func TestCsrfMiddleware(t *testing.T) {
+	csf := services.NewCsrfService("secret")
+	h := &Handler{csf: csf}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mw := h.CsrfMiddleware(next)
+	token := csf.Token("session")
+	otherSecret := services.NewCsrfService("other")
+
+	tests := []struct {
+		name    string
+		method  string
+		cookie  string
+		header  map[string]string
+		wantErr bool
+	}{
+		{"GET with a session", http.MethodGet, "session", nil, false},
+		{"HEAD with a session", http.MethodHead, "session", nil, false},
+		{"POST without a session", http.MethodPost, "", nil, false},
+		{"POST with a session and the token", http.MethodPost, "session", map[string]string{"X-CSRF-Token": token}, false},
+		{"POST with a session without the token", http.MethodPost, "session", nil, true},
+		{"DELETE with a session without the token", http.MethodDelete, "session", nil, true},
+		{"PATCH with the token of another session", http.MethodPatch, "session", map[string]string{"X-CSRF-Token": csf.Token("other")}, true},
+		{"POST with the token of another secret", http.MethodPost, "session", map[string]string{"X-CSRF-Token": otherSecret.Token("session")}, true},
+		{"POST with a bearer token", http.MethodPost, "session", map[string]string{"Authorization": "Bearer x"}, false},
+		{"POST with an api key", http.MethodPost, "session", map[string]string{"X-Api-Key": "tsk_x"}, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/cart/buy", nil)
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "sessionId", Value: tt.cookie})
+		}
+		for k, v := range tt.header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, r)
+		if got := w.Code == http.StatusForbidden; got != tt.wantErr {
+			t.Errorf("%v: status = %v", tt.name, w.Code)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"toyStore/models"
+)
+
+// currencies
+
+func (h *Handler) GetCurrencies(w http.ResponseWriter, r *http.Request) {
+	rates, err := h.crs.GetRates()
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	resp := struct {
+		Base  string                   `json:"base"`
+		Rates []models.ExchangeRate_db `json:"rates"`
+	}{
+		Base:  h.crs.BaseCurrency(),
+		Rates: rates,
+	}
+	jsonData, err := json.MarshalIndent(resp, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+func (h *Handler) UpdateCurrencyRate(w http.ResponseWriter, r *http.Request) {
+	var rate models.ExchangeRate_db
+	err := json.NewDecoder(r.Body).Decode(&rate)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = h.crs.SetRate(rate)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) ImportCurrencyRates(w http.ResponseWriter, r *http.Request) {
+	imported, err := h.crs.ImportRates(r.Body)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.Write([]byte("Imported " + strconv.Itoa(imported) + " rate(s)"))
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"toyStore/models"
+
+	"github.com/gorilla/mux"
+)
+
+// addresses
+
+func (h *Handler) GetUserAddresses(w http.ResponseWriter, r *http.Request) {
+	sessionId := h.sessionId(r)
+	addrs, err := h.as.GetUserAddresses(sessionId)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(addrs, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+func (h *Handler) CreateAddress(w http.ResponseWriter, r *http.Request) {
+	sessionId := h.sessionId(r)
+	var addr models.Address_db
+	err := json.NewDecoder(r.Body).Decode(&addr)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	addr.Id, err = h.as.CreateAddress(sessionId, addr)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.Write([]byte(strconv.Itoa(addr.Id)))
+}
+
+func (h *Handler) UpdateAddress(w http.ResponseWriter, r *http.Request) {
+	sessionId := h.sessionId(r)
+	vars := mux.Vars(r)
+	var addr models.Address_db
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = json.NewDecoder(r.Body).Decode(&addr)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	addr.Id = id
+	err = h.as.UpdateAddress(sessionId, addr)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) DeleteAddress(w http.ResponseWriter, r *http.Request) {
+	sessionId := h.sessionId(r)
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = h.as.DeleteAddress(sessionId, id)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// delivery methods
+
+func (h *Handler) GetDeliveryMethods(w http.ResponseWriter, r *http.Request) {
+	methods, err := h.ds.GetDeliveryMethods(true)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(methods, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+func (h *Handler) CreateDeliveryMethod(w http.ResponseWriter, r *http.Request) {
+	method := models.DeliveryMethod_db{Active: true}
+	err := json.NewDecoder(r.Body).Decode(&method)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	method.Id, err = h.ds.CreateDeliveryMethod(method)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.Write([]byte(strconv.Itoa(method.Id)))
+}
+
+func (h *Handler) UpdateDeliveryMethod(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var method models.DeliveryMethod_db
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = json.NewDecoder(r.Body).Decode(&method)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	method.Id = id
+	err = h.ds.UpdateDeliveryMethod(method)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
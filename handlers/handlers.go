@@ -1,13 +1,17 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"runtime/debug"
 	"strconv"
+	"strings"
 	"time"
 
 	"toyStore/entities"
@@ -24,6 +28,24 @@ type Handler struct {
 	cas services.CategoryService
 	ats services.AttributeService
 	ors services.OrderService
+	prs services.PromotionService
+	crs services.CurrencyService
+	ts  services.TaxService
+	as  services.AddressService
+	ds  services.DeliveryService
+	pms services.PaymentService
+	rs  services.ReturnService
+	ids services.IdempotencyService
+	is  services.InvoiceService
+	shs services.ShipmentService
+	rls services.RoleService
+	pws services.PasswordResetService
+	tos services.TotpService
+	tks services.AccessTokenService
+	aks services.ApiKeyService
+	csf services.CsrfService
+
+	secureCookies bool
 }
 
 type HandlerParams struct {
@@ -33,6 +55,25 @@ type HandlerParams struct {
 	CatsService services.CategoryService
 	AtrService  services.AttributeService
 	OrdService  services.OrderService
+	PrmService  services.PromotionService
+	CurService  services.CurrencyService
+	TaxService  services.TaxService
+	AdrService  services.AddressService
+	DlvService  services.DeliveryService
+	PayService  services.PaymentService
+	RetService  services.ReturnService
+	IdmService  services.IdempotencyService
+	InvService  services.InvoiceService
+	ShpService  services.ShipmentService
+	RolService  services.RoleService
+	PwdService  services.PasswordResetService
+	TotpService services.TotpService
+	TknService  services.AccessTokenService
+	KeyService  services.ApiKeyService
+	CsrService  services.CsrfService
+
+	// SecureCookies sends the cookies only over https
+	SecureCookies bool
 }
 
 func NewHandler(params HandlerParams) *Handler {
@@ -43,6 +84,24 @@ func NewHandler(params HandlerParams) *Handler {
 		cas: params.CatsService,
 		ps:  params.PrdService,
 		ats: params.AtrService,
+		prs: params.PrmService,
+		crs: params.CurService,
+		ts:  params.TaxService,
+		as:  params.AdrService,
+		ds:  params.DlvService,
+		pms: params.PayService,
+		rs:  params.RetService,
+		ids: params.IdmService,
+		is:  params.InvService,
+		shs: params.ShpService,
+		rls: params.RolService,
+		pws: params.PwdService,
+		tos: params.TotpService,
+		tks: params.TknService,
+		aks: params.KeyService,
+		csf: params.CsrService,
+
+		secureCookies: params.SecureCookies,
 	}
 }
 
@@ -51,11 +110,10 @@ func (h *Handler) Welcome(w http.ResponseWriter, r *http.Request) {
 	var uModel models.User_db
 	var exists bool
 
-	c, err := r.Cookie("sessionId")
-	if err != nil {
+	sessionId := h.sessionId(r)
+	if sessionId == "" {
 		name = "guest"
 	} else {
-		sessionId := c.Value
 		uModel, exists = h.us.WelcomeRequest(sessionId)
 		if !exists {
 			name = "guest"
@@ -89,7 +147,7 @@ func (h *Handler) Signup(w http.ResponseWriter, r *http.Request) {
 
 func (h *Handler) Signin(w http.ResponseWriter, r *http.Request) {
 	creds := models.Credentials{}
-	var sessionId string
+	var sessionId, mfaToken string
 
 	err := json.NewDecoder(r.Body).Decode(&creds)
 	if err != nil {
@@ -97,61 +155,100 @@ func (h *Handler) Signin(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
-	_, sessionId, err = h.us.SigninRequest(creds.Username, creds.Password)
+	_, sessionId, mfaToken, err = h.us.SigninRequest(creds.Username, creds.Password, sessionMeta(r))
 	if err != nil {
 		WriteErrorResponse(w, err)
 		return
 	}
+	if mfaToken != "" {
+		// the password is right, the session is issued by /users/signin/totp
+		jsonData, err := json.MarshalIndent(models.SigninChallenge{TotpRequired: true, MfaToken: mfaToken}, "", "  ")
+		if err != nil {
+			log.Printf("Marshal err:%v", err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		w.Write(jsonData)
+		return
+	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:    "sessionId",
-		Value:   sessionId,
-		Path:    "/",
-		Expires: time.Now().Add(24 * time.Hour),
-		// redis 30 min
-	})
+	h.setSessionCookie(w, sessionId)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) SigninTotp(w http.ResponseWriter, r *http.Request) {
+	var req models.TotpSigninRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	sessionId, err := h.us.SigninTotp(req, sessionMeta(r))
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	h.setSessionCookie(w, sessionId)
 	w.WriteHeader(http.StatusOK)
 }
 
+// setSessionCookie sets the session cookie and the csrf token cookie, they expire with the session in redis.
+// The csrf token is readable by scripts of the site, they send it back in the X-CSRF-Token header.
+func (h *Handler) setSessionCookie(w http.ResponseWriter, sessionId string) {
+	http.SetCookie(w, h.cookie("sessionId", sessionId, models.SessionTtl, true))
+	http.SetCookie(w, h.cookie("csrfToken", h.csf.Token(sessionId), models.SessionTtl, false))
+}
+
+func (h *Handler) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, h.cookie("sessionId", "", 0, true))
+	http.SetCookie(w, h.cookie("csrfToken", "", 0, false))
+}
+
+// cookie makes a cookie of the whole site, a zero ttl deletes it
+func (h *Handler) cookie(name string, value string, ttl time.Duration, httpOnly bool) *http.Cookie {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(ttl.Seconds()),
+		Expires:  time.Now().Add(ttl),
+		HttpOnly: httpOnly,
+		Secure:   h.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if ttl == 0 {
+		c.MaxAge = -1
+		c.Expires = time.Unix(0, 0)
+	}
+	return c
+}
+
 func (h *Handler) Refresh(w http.ResponseWriter, r *http.Request) {
-	c, _ := r.Cookie("sessionId")
-	sessionId := c.Value
+	sessionId := h.sessionId(r)
 	err := h.us.RefreshRequest(sessionId)
 	if err != nil {
-		http.Error(w, "server error", http.StatusInternalServerError)
+		WriteErrorResponse(w, err)
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:    "sessionId",
-		Value:   sessionId,
-		Path:    "/",
-		Expires: time.Now().Add(24 * time.Hour),
-		// redis 30 min
-	})
+	h.setSessionCookie(w, sessionId)
 	w.WriteHeader(http.StatusOK)
 }
 
 func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
-	c, _ := r.Cookie("sessionId")
-	sessionId := c.Value
+	sessionId := h.sessionId(r)
 
 	err := h.us.DeleteSessionRequest(sessionId)
 	if err != nil {
 		http.Error(w, "server error", http.StatusInternalServerError)
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:    "sessionId",
-		Value:   "",
-		Path:    "/",
-		Expires: time.Now(),
-	})
+	h.clearSessionCookie(w)
 	w.WriteHeader(http.StatusOK)
 }
 
 func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
-	c, _ := r.Cookie("sessionId")
-	sessionId := c.Value
+	sessionId := h.sessionId(r)
 
 	data := models.PasswordData{}
 	err := json.NewDecoder(r.Body).Decode(&data)
@@ -166,13 +263,7 @@ func (h *Handler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 		WriteErrorResponse(w, err)
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:    "sessionId",
-		Value:   "",
-		Path:    "/",
-		Expires: time.Now(),
-		// redis 30 min
-	})
+	h.clearSessionCookie(w)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -185,7 +276,12 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.us.CreateUserRequest(creds)
+	actorId, err := h.staffId(r)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	err = h.us.CreateUserRequest(actorId, creds)
 	if err != nil {
 		WriteErrorResponse(w, err)
 		return
@@ -208,6 +304,11 @@ func (h *Handler) GetProduct(w http.ResponseWriter, r *http.Request) {
 		WriteErrorResponse(w, err)
 		return
 	}
+	err = h.crs.ConvertProduct(&prod, requestCurrency(r))
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
 	jsonData, err2 := json.MarshalIndent(prod, "", "  ")
 	if err2 != nil {
 		log.Printf("Marshal err:%v", err2)
@@ -367,6 +468,79 @@ func (h *Handler) RemoveProductCategory(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusOK)
 }
 
+func (h *Handler) GetPriceHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	prices, err := h.ps.GetPriceHistory(id)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err2 := json.MarshalIndent(prices, "", "  ")
+	if err2 != nil {
+		log.Printf("Marshal err:%v", err2)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+func (h *Handler) ScheduleSalePrice(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req models.SalePriceRequest
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	priceId, err := h.ps.ScheduleSalePrice(id, req)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.Write([]byte(strconv.Itoa(priceId)))
+}
+
+func (h *Handler) RemoveSalePrice(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var req struct {
+		Id int `json:"id"`
+	}
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = h.ps.RemoveSalePrice(id, req.Id)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 // categories
 
 func (h *Handler) GetAllCategories(w http.ResponseWriter, r *http.Request) {
@@ -520,7 +694,7 @@ func (h *Handler) GetCart(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		switch {
 		case errors.Is(err, http.ErrNoCookie):
-			b, _ := json.MarshalIndent(entities.CartResponse{Products: []entities.CartItem{}}, "", " ")
+			b, _ := json.MarshalIndent(entities.CartResponse{Products: []entities.CartItem{}, Currency: h.crs.BaseCurrency()}, "", " ")
 			w.Write(b)
 			return
 		default:
@@ -531,11 +705,16 @@ func (h *Handler) GetCart(w http.ResponseWriter, r *http.Request) {
 	} else {
 		cartSessionId = c.Value
 	}
-	cart, err := h.cs.GetCartItems(cartSessionId)
+	cart, err := h.cs.GetCartItems(cartSessionId, requestRegion(r))
 	if err != nil {
 		http.Error(w, "server error", http.StatusInternalServerError)
 		return
 	}
+	err = h.crs.ConvertCart(&cart, requestCurrency(r))
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
 	jsonData, err2 := json.MarshalIndent(cart, "", "  ")
 	if err2 != nil {
 		log.Printf("Marshal err:%v", err2)
@@ -566,11 +745,7 @@ func (h *Handler) AddToCart(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "server error", http.StatusInternalServerError)
 				return
 			}
-			http.SetCookie(w, &http.Cookie{
-				Name:    "cartSessionId",
-				Value:   cartSessionId,
-				Expires: time.Now().Add(24 * time.Hour),
-			})
+			http.SetCookie(w, h.cookie("cartSessionId", cartSessionId, models.CartTtl, true))
 		default:
 			log.Printf("Cookie err:%v", err)
 			http.Error(w, "server error", http.StatusInternalServerError)
@@ -618,8 +793,7 @@ func (h *Handler) DeleteFromCart(w http.ResponseWriter, r *http.Request) {
 
 // orders
 func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
-	c, _ := r.Cookie("sessionId")
-	sessionId := c.Value
+	sessionId := h.sessionId(r)
 	var ordId int
 	c, err := r.Cookie("cartSessionId")
 	if err != nil {
@@ -631,18 +805,19 @@ func (h *Handler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	cartSessionId := c.Value
-	ordId, err = h.ors.CreateOrder(sessionId, cartSessionId)
+	var checkout models.CheckoutRequest
+	err = json.NewDecoder(r.Body).Decode(&checkout)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	ordId, err = h.ors.CreateOrder(sessionId, cartSessionId, requestCurrency(r), checkout)
 	if err != nil {
 		WriteErrorResponse(w, err)
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:    "cartSessionId",
-		Value:   "",
-		Path:    "/",
-		Expires: time.Now(),
-		// redis 30 min
-	})
+	http.SetCookie(w, h.cookie("cartSessionId", "", 0, true))
 
 	w.Write([]byte(strconv.Itoa(ordId)))
 }
@@ -764,7 +939,178 @@ func (h *Handler) SetOrderStatus(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *Handler) CancelOrder(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) GetOrderStatusHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	history, err := h.ors.GetStatusHistory(id)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+// EditOrderItem changes the product, quantity or price of a line of a created order
+func (h *Handler) EditOrderItem(w http.ResponseWriter, r *http.Request) {
+	managerId, err := h.staffId(r)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	itemId, err := strconv.Atoi(vars["itemId"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var req models.OrderItemEditRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = h.ors.EditOrderItem(managerId, id, itemId, req)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// RemoveOrderItem removes a line of a created order, the reason is optional
+func (h *Handler) RemoveOrderItem(w http.ResponseWriter, r *http.Request) {
+	managerId, err := h.staffId(r)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	itemId, err := strconv.Atoi(vars["itemId"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = h.ors.RemoveOrderItem(managerId, id, itemId, req.Reason)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetOrderChanges(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	changes, err := h.ors.GetOrderChanges(id)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+// AddOrderNote adds an internal manager note to the order and returns its id
+func (h *Handler) AddOrderNote(w http.ResponseWriter, r *http.Request) {
+	managerId, err := h.staffId(r)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var req struct {
+		Text string `json:"text"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	noteId, err := h.ors.AddOrderNote(managerId, id, req.Text)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.Write([]byte(strconv.Itoa(noteId)))
+}
+
+func (h *Handler) GetOrderNotes(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	notes, err := h.ors.GetOrderNotes(id)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(notes, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+// Reorder copies the lines of a past order of the user into the cart and reports what was added
+func (h *Handler) Reorder(w http.ResponseWriter, r *http.Request) {
+	sessionId := h.sessionId(r)
 	vars := mux.Vars(r)
 	orderId, err := strconv.Atoi(vars["id"])
 	if err != nil {
@@ -772,15 +1118,57 @@ func (h *Handler) CancelOrder(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
+	order, err := h.ors.GetUserOrder(sessionId, orderId)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
 
-	sessionId, err := r.Cookie("sessionId")
+	var cartSessionId string
+	c, err := r.Cookie("cartSessionId")
 	if err != nil {
-		log.Printf("Cookie err:%v", err)
+		switch {
+		case errors.Is(err, http.ErrNoCookie):
+			cartSessionId, err = h.cs.CreateCartSession()
+			if err != nil {
+				fmt.Println(err)
+				http.Error(w, "server error", http.StatusInternalServerError)
+				return
+			}
+			http.SetCookie(w, h.cookie("cartSessionId", cartSessionId, models.CartTtl, true))
+		default:
+			log.Printf("Cookie err:%v", err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		cartSessionId = c.Value
+	}
+
+	report, err := h.cs.AddOrderItems(cartSessionId, order.Products)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
 		http.Error(w, "server error", http.StatusInternalServerError)
 		return
 	}
+	w.Write(jsonData)
+}
 
-	err = h.ors.CancelOrder(orderId, sessionId.Value)
+func (h *Handler) CancelOrder(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	orderId, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	err = h.ors.CancelOrder(orderId, h.sessionId(r))
 	if err != nil {
 		WriteErrorResponse(w, err)
 		return
@@ -789,9 +1177,7 @@ func (h *Handler) CancelOrder(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) GetCurrentUserOrders(w http.ResponseWriter, r *http.Request) {
-	sessionId, _ := r.Cookie("sessionId")
-
-	orders, err := h.ors.GetCurrentUserOrders(sessionId.Value)
+	orders, err := h.ors.GetCurrentUserOrders(h.sessionId(r))
 	if err != nil {
 		WriteErrorResponse(w, err)
 		return
@@ -807,15 +1193,50 @@ func (h *Handler) GetCurrentUserOrders(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("\n\n end of report"))
 }
 
+// sessionId returns the session of the bearer access token if the Authorization header is sent,
+// otherwise of the cookie. An empty string means there is no valid credential.
+func (h *Handler) sessionId(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		token, found := strings.CutPrefix(auth, "Bearer ")
+		if !found {
+			return ""
+		}
+		sessionId, ok := h.tks.ParseAccessToken(strings.TrimSpace(token))
+		if !ok {
+			return ""
+		}
+		return sessionId
+	}
+	c, err := r.Cookie("sessionId")
+	// the sessions of tokens are used only with a valid access token, which expires sooner
+	if err != nil || strings.HasPrefix(c.Value, models.TokenSessionPrefix) {
+		return ""
+	}
+	return c.Value
+}
+
+// apiKeyContextKey keeps the api key of the request checked by RequirePermission
+type apiKeyContextKey struct{}
+
+// staffId is the user who makes the staff request: the creator of the api key or the user of the session
+func (h *Handler) staffId(r *http.Request) (userId int, err error) {
+	if apiKey, ok := r.Context().Value(apiKeyContextKey{}).(models.ApiKey_db); ok {
+		userId = apiKey.CreatedBy
+		return
+	}
+	userId, err = h.us.SessionUserId(h.sessionId(r))
+	return
+}
+
 // middleware
 func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		sessionId, err := r.Cookie("sessionId")
-		if err != nil {
+		sessionId := h.sessionId(r)
+		if sessionId == "" {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
-		ok, e := h.us.CheckAuth(sessionId.Value)
+		ok, e := h.us.CheckAuth(sessionId)
 		if !ok {
 			if e != nil {
 				http.Error(w, "server error", http.StatusInternalServerError)
@@ -828,22 +1249,55 @@ func (h *Handler) AuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-func (h *Handler) ManagerAuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		sessionId, err := r.Cookie("sessionId")
-		if err != nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
-		ok, err := h.us.CheckAccess(sessionId.Value)
-		if !ok {
+// RequirePermission lets the request through only if the role of the session has the permission.
+// An api key from the X-Api-Key header is accepted instead of a session, limited to the scopes of the key.
+func (h *Handler) RequirePermission(permission string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var exists, ok bool
+			var err error
+			if key := r.Header.Get("X-Api-Key"); key != "" {
+				var apiKey models.ApiKey_db
+				apiKey, exists, ok, err = h.aks.CheckApiKey(key, permission)
+				r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, apiKey))
+			} else if sessionId := h.sessionId(r); sessionId != "" {
+				exists, ok, err = h.us.CheckPermission(sessionId, permission)
+			}
 			if err != nil {
-				log.Printf("CheckSession: %v", err)
+				log.Printf("CheckPermission: %v", err)
 				http.Error(w, "server error", http.StatusInternalServerError)
-
-			} else {
+				return
+			}
+			if !exists {
 				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !ok {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
 			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CsrfMiddleware checks the X-CSRF-Token header of the changing requests authenticated by the session cookie.
+// Bearer tokens and api keys are sent by the client itself, another site can not add them to a request.
+func (h *Handler) CsrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		c, err := r.Cookie("sessionId")
+		if err != nil || r.Header.Get("Authorization") != "" || r.Header.Get("X-Api-Key") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !h.csf.Check(c.Value, r.Header.Get("X-CSRF-Token")) {
+			log.Printf("CsrfMiddleware: invalid csrf token for %v %v", r.Method, r.URL.Path)
+			http.Error(w, "invalid csrf token", http.StatusForbidden)
 			return
 		}
 		next.ServeHTTP(w, r)
@@ -862,6 +1316,35 @@ func (h *Handler) ErrorHandleMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// requestCurrency returns the currency requested by the "currency" query parameter or the X-Currency header
+func requestCurrency(r *http.Request) string {
+	if currency := r.URL.Query().Get("currency"); currency != "" {
+		return currency
+	}
+	return r.Header.Get("X-Currency")
+}
+
+// clientIp is the address of the connection, X-Forwarded-For is not trusted as anyone can send it
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func sessionMeta(r *http.Request) models.SessionMeta {
+	return models.SessionMeta{Ip: clientIp(r), UserAgent: r.UserAgent()}
+}
+
+// requestRegion returns the tax region requested by the "region" query parameter or the X-Region header
+func requestRegion(r *http.Request) string {
+	if region := r.URL.Query().Get("region"); region != "" {
+		return region
+	}
+	return r.Header.Get("X-Region")
+}
+
 func WriteErrorResponse(w http.ResponseWriter, err error) {
 	switch {
 	case errors.Is(err, models.ErrServerError):
@@ -874,5 +1357,9 @@ func WriteErrorResponse(w http.ResponseWriter, err error) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 	case errors.Is(err, models.ErrNotAllowed):
 		http.Error(w, err.Error(), http.StatusNotAcceptable)
+	case errors.Is(err, models.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, models.ErrTooManyRequests):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
 	}
 }
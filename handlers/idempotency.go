@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+
+	"toyStore/models"
+)
+
+// idempotency
+
+// maxIdempotentBody limits the body that is read to compare repeated requests
+const maxIdempotentBody = 1 << 20
+
+// responseRecorder passes the response through and keeps a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if rr.status == 0 {
+		rr.status = status
+	}
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(data []byte) (int, error) {
+	if rr.status == 0 {
+		rr.status = http.StatusOK
+	}
+	rr.body.Write(data)
+	return rr.ResponseWriter.Write(data)
+}
+
+// IdempotencyMiddleware makes mutating requests with the Idempotency-Key header run once, a repeated
+// request of the same client gets the original response with the Idempotent-Replayed header.
+func (h *Handler) IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentBody))
+		if err != nil {
+			log.Printf("Read body err:%v", err)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.RequestURI()+"\n"), body...))
+		fingerprint := hex.EncodeToString(sum[:])
+		scope := h.idempotencyScope(r)
+
+		saved, err := h.ids.Begin(scope, key, fingerprint)
+		if err != nil {
+			WriteErrorResponse(w, err)
+			return
+		}
+		if saved != nil {
+			for k, v := range saved.Header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(saved.Status)
+			w.Write(saved.Body)
+			return
+		}
+
+		// a handler that panics leaves no response to save, the key is freed for a retry
+		defer func() {
+			if p := recover(); p != nil {
+				if err := h.ids.Abort(scope, key); err != nil {
+					log.Printf("IdempotencyMiddleware: %v", err)
+				}
+				panic(p)
+			}
+		}()
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		// cookies belong to the client that made the first request, a replay must not set them again
+		header := w.Header().Clone()
+		header.Del("Set-Cookie")
+		err = h.ids.Finish(scope, key, models.IdempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      rec.status,
+			Header:      header,
+			Body:        rec.body.Bytes(),
+		})
+		if err != nil {
+			log.Printf("IdempotencyMiddleware: %v", err)
+		}
+	})
+}
+
+// idempotencyScope separates the keys of different clients: by the api key, by the session, by the cart or by the address
+func (h *Handler) idempotencyScope(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		return "apikey:" + hex.EncodeToString(sum[:])
+	}
+	if sessionId := h.sessionId(r); sessionId != "" {
+		return "session:" + sessionId
+	}
+	if c, err := r.Cookie("cartSessionId"); err == nil {
+		return "cart:" + c.Value
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
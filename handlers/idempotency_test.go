@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"toyStore/models"
+	"toyStore/repository"
+	"toyStore/services"
+)
+
+// fakeIdempotencyRepo keeps the keys in memory with the ttl they were stored with
+type fakeIdempotencyRepo struct {
+	repository.IdempotencyRepository
+	saved map[string]models.IdempotentResponse
+	ttls  map[string]time.Duration
+}
+
+func (f *fakeIdempotencyRepo) Reserve(key string, fingerprint string, ttl time.Duration) (saved models.IdempotentResponse, reserved bool, err error) {
+	if saved, ok := f.saved[key]; ok {
+		return saved, false, nil
+	}
+	f.saved[key] = models.IdempotentResponse{Fingerprint: fingerprint}
+	f.ttls[key] = ttl
+	return models.IdempotentResponse{}, true, nil
+}
+
+func (f *fakeIdempotencyRepo) Save(key string, resp models.IdempotentResponse, ttl time.Duration) (err error) {
+	f.saved[key] = resp
+	f.ttls[key] = ttl
+	return
+}
+
+func (f *fakeIdempotencyRepo) Release(key string) (err error) {
+	delete(f.saved, key)
+	return
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		panics     bool
+		wantStatus int
+		wantKeys   int
+	}{
+		{"saved response", `{"id":1}`, false, http.StatusCreated, 1},
+		{"body too large", strings.Repeat("x", maxIdempotentBody+1), false, http.StatusRequestEntityTooLarge, 0},
+		{"handler panics", `{"id":1}`, true, 0, 0},
+	}
+	for _, tt := range tests {
+		ir := &fakeIdempotencyRepo{saved: map[string]models.IdempotentResponse{}, ttls: map[string]time.Duration{}}
+		h := &Handler{ids: services.NewIdempotencyService(ir, 24*time.Hour)}
+		calls := 0
+		mw := h.IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if tt.panics {
+				panic("handler failed")
+			}
+			http.SetCookie(w, &http.Cookie{Name: "sessionId", Value: "secret"})
+			w.WriteHeader(http.StatusCreated)
+		}))
+		serve := func() (w *httptest.ResponseRecorder) {
+			w = httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/cart/buy", strings.NewReader(tt.body))
+			r.Header.Set("Idempotency-Key", "key")
+			defer func() {
+				if p := recover(); p != nil && !tt.panics {
+					t.Errorf("%v: panic %v", tt.name, p)
+				}
+			}()
+			mw.ServeHTTP(w, r)
+			return
+		}
+
+		w := serve()
+		if !tt.panics && w.Code != tt.wantStatus {
+			t.Errorf("%v: status = %v, want %v", tt.name, w.Code, tt.wantStatus)
+		}
+		if len(ir.saved) != tt.wantKeys {
+			t.Errorf("%v: %v keys kept, want %v", tt.name, len(ir.saved), tt.wantKeys)
+		}
+		if tt.wantKeys == 0 {
+			continue
+		}
+		for key, resp := range ir.saved {
+			if _, ok := resp.Header["Set-Cookie"]; ok {
+				t.Errorf("%v: Set-Cookie is saved", tt.name)
+			}
+			if ir.ttls[key] != 24*time.Hour {
+				t.Errorf("%v: saved for %v", tt.name, ir.ttls[key])
+			}
+		}
+		replay := serve()
+		if calls != 1 || replay.Code != tt.wantStatus || replay.Header().Get("Idempotent-Replayed") != "true" {
+			t.Errorf("%v: replay status = %v, handler calls = %v", tt.name, replay.Code, calls)
+		}
+		if replay.Header().Get("Set-Cookie") != "" {
+			t.Errorf("%v: replay sets a cookie", tt.name)
+		}
+	}
+}
+
+// the key in progress expires soon if the server stops before the response is saved
+func TestIdempotencyLockTtl(t *testing.T) {
+	ir := &fakeIdempotencyRepo{saved: map[string]models.IdempotentResponse{}, ttls: map[string]time.Duration{}}
+	ids := services.NewIdempotencyService(ir, 24*time.Hour)
+	if _, err := ids.Begin("ip:127.0.0.1", "key", "fingerprint"); err != nil {
+		t.Fatalf("error = %v", err)
+	}
+	for _, ttl := range ir.ttls {
+		if ttl > 5*time.Minute {
+			t.Errorf("key in progress is kept for %v", ttl)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// invoices
+
+// GetInvoice returns the invoice as PDF, or as HTML with format=html
+func (h *Handler) GetInvoice(w http.ResponseWriter, r *http.Request) {
+	sessionId := h.sessionId(r)
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	inv, err := h.is.GetInvoice(sessionId, id)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	if r.URL.Query().Get("format") == "html" {
+		data, err := h.is.InvoiceHTML(inv)
+		if err != nil {
+			WriteErrorResponse(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(data)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "inline; filename=\""+inv.Number+".pdf\"")
+	w.Write(h.is.InvoicePDF(inv))
+}
+
+func (h *Handler) GetPackingSlip(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	inv, err := h.is.GetPackingSlip(id)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	if r.URL.Query().Get("format") == "html" {
+		data, err := h.is.PackingSlipHTML(inv)
+		if err != nil {
+			WriteErrorResponse(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(data)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "inline; filename=\"packing-slip-"+strconv.Itoa(id)+".pdf\"")
+	w.Write(h.is.PackingSlipPDF(inv))
+}
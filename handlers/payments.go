@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"toyStore/models"
+
+	"github.com/gorilla/mux"
+)
+
+// payments
+
+func (h *Handler) CreatePayment(w http.ResponseWriter, r *http.Request) {
+	sessionId := h.sessionId(r)
+	vars := mux.Vars(r)
+	var req models.PaymentRequest
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	// the body is optional, the default provider is used without it
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	payment, err := h.pms.CreatePayment(sessionId, id, req.Provider)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(payment, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+func (h *Handler) GetOrderPayments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	payments, err := h.pms.GetOrderPayments(id)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(payments, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+// PaymentWebhook answers 200 for duplicates as well, so the provider stops resending them
+func (h *Handler) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	payload, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		log.Printf("Read body err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = h.pms.HandleWebhook(vars["provider"], payload, r.Header.Get("X-Signature"))
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
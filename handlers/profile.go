@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"toyStore/models"
+)
+
+// user profile
+
+func (h *Handler) GetProfile(w http.ResponseWriter, r *http.Request) {
+	sessionId := h.sessionId(r)
+	user, err := h.us.GetProfile(sessionId)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(user, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+func (h *Handler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	sessionId := h.sessionId(r)
+	var req models.ProfileUpdateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	user, err := h.us.UpdateProfile(sessionId, req)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(user, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+func (h *Handler) SendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	sessionId := h.sessionId(r)
+	err := h.us.SendEmailVerification(sessionId)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req models.EmailVerifyRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = h.us.VerifyEmail(req.Token)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
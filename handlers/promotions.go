@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"toyStore/models"
+
+	"github.com/gorilla/mux"
+)
+
+// promotions
+
+func (h *Handler) GetAllPromotions(w http.ResponseWriter, r *http.Request) {
+	promos, err := h.prs.GetAllPromotions()
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(promos, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+func (h *Handler) CreatePromotion(w http.ResponseWriter, r *http.Request) {
+	promo := models.Promotion_db{Active: true}
+	err := json.NewDecoder(r.Body).Decode(&promo)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	promo.Id, err = h.prs.CreatePromotion(promo)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.Write([]byte(strconv.Itoa(promo.Id)))
+}
+
+func (h *Handler) UpdatePromotion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var data struct {
+		Active *bool `json:"active"`
+	}
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = json.NewDecoder(r.Body).Decode(&data)
+	if err != nil || data.Active == nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = h.prs.SetPromotionActive(id, *data.Active)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// user sessions
+
+func (h *Handler) GetSessions(w http.ResponseWriter, r *http.Request) {
+	sessionId := h.sessionId(r)
+	sessions, err := h.us.GetSessions(sessionId)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(sessions, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+func (h *Handler) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	sessionId := h.sessionId(r)
+	vars := mux.Vars(r)
+	err := h.us.DeleteSession(sessionId, vars["id"])
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
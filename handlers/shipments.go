@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"toyStore/models"
+
+	"github.com/gorilla/mux"
+)
+
+// shipments and carriers
+
+func (h *Handler) GetCarriers(w http.ResponseWriter, r *http.Request) {
+	carriers, err := h.shs.GetCarriers()
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(carriers, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+func (h *Handler) CreateCarrier(w http.ResponseWriter, r *http.Request) {
+	carrier := models.Carrier_db{Active: true}
+	err := json.NewDecoder(r.Body).Decode(&carrier)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	carrier.Id, err = h.shs.CreateCarrier(carrier)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.Write([]byte(strconv.Itoa(carrier.Id)))
+}
+
+func (h *Handler) UpdateCarrier(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var carrier models.Carrier_db
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = json.NewDecoder(r.Body).Decode(&carrier)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	carrier.Id = id
+	err = h.shs.UpdateCarrier(carrier)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) CreateShipment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var shipment models.Shipment_db
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = json.NewDecoder(r.Body).Decode(&shipment)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	shipment.Id, err = h.shs.CreateShipment(id, shipment)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.Write([]byte(strconv.Itoa(shipment.Id)))
+}
+
+func (h *Handler) GetOrderShipments(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	shipments, err := h.shs.GetOrderShipments(id)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(shipments, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
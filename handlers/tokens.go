@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"toyStore/models"
+)
+
+// bearer tokens
+
+// CreateToken is the sign-in of the mobile app and integrations, it returns tokens instead of the cookie
+func (h *Handler) CreateToken(w http.ResponseWriter, r *http.Request) {
+	creds := models.Credentials{}
+	err := json.NewDecoder(r.Body).Decode(&creds)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	meta := sessionMeta(r)
+	meta.Token = true
+	_, sessionId, mfaToken, err := h.us.SigninRequest(creds.Username, creds.Password, meta)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	if mfaToken != "" {
+		// the tokens are issued by /users/token/totp
+		jsonData, err := json.MarshalIndent(models.SigninChallenge{TotpRequired: true, MfaToken: mfaToken}, "", "  ")
+		if err != nil {
+			log.Printf("Marshal err:%v", err)
+			http.Error(w, "server error", http.StatusInternalServerError)
+			return
+		}
+		w.Write(jsonData)
+		return
+	}
+	h.writeTokens(w, sessionId)
+}
+
+func (h *Handler) CreateTokenTotp(w http.ResponseWriter, r *http.Request) {
+	var req models.TotpSigninRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	meta := sessionMeta(r)
+	meta.Token = true
+	sessionId, err := h.us.SigninTotp(req, meta)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	h.writeTokens(w, sessionId)
+}
+
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	pair, err := h.tks.Refresh(req.RefreshToken)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(pair, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
+
+func (h *Handler) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	var req models.RefreshTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		log.Printf("Unmarshal err:%v", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	err = h.tks.Revoke(req.RefreshToken)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) writeTokens(w http.ResponseWriter, sessionId string) {
+	pair, err := h.tks.IssueTokens(sessionId)
+	if err != nil {
+		WriteErrorResponse(w, err)
+		return
+	}
+	jsonData, err := json.MarshalIndent(pair, "", "  ")
+	if err != nil {
+		log.Printf("Marshal err:%v", err)
+		http.Error(w, "server error", http.StatusInternalServerError)
+		return
+	}
+	w.Write(jsonData)
+}
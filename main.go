@@ -7,8 +7,11 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 	"toyStore/handlers"
+	"toyStore/models"
 	"toyStore/repository"
 	"toyStore/services"
 
@@ -32,7 +35,22 @@ func main() {
 	aR, _ := repository.NewAttributeRepository(db)
 	cR, _ := repository.NewCategoryRepository(db)
 	cartR, _ := repository.NewCartRepository(rdb, context.Background())
+	idmR, _ := repository.NewIdempotencyRepository(rdb, context.Background())
 	oR, _ := repository.NewOrderRepository(db)
+	promoR, _ := repository.NewPromotionRepository(db)
+	curR, _ := repository.NewCurrencyRepository(db)
+	taxR, _ := repository.NewTaxRepository(db)
+	adrR, _ := repository.NewAddressRepository(db)
+	dlvR, _ := repository.NewDeliveryRepository(db)
+	payR, _ := repository.NewPaymentRepository(db)
+	retR, _ := repository.NewReturnRepository(db)
+	shpR, _ := repository.NewShipmentRepository(db)
+	roleR, _ := repository.NewRoleRepository(db)
+	lockR, _ := repository.NewLockRepository(rdb, context.Background())
+	tokR, _ := repository.NewTokenRepository(rdb, context.Background())
+	loginR, _ := repository.NewLoginAttemptRepository(rdb, context.Background())
+	totpR, _ := repository.NewTotpRepository(db)
+	keyR, _ := repository.NewApiKeyRepository(db)
 	if err != nil {
 		panic(err)
 	}
@@ -41,54 +59,279 @@ func main() {
 		panic(err2)
 	}
 	log.Printf("redis connected")
+	expiryAge := durationEnv("ORDER_EXPIRY_AGE", 24*time.Hour)
+	if expiryAge == 0 {
+		log.Fatalf("ORDER_EXPIRY_AGE must be positive")
+	}
+	expiryS := services.NewOrderExpiryService(oR, lockR, expiryAge)
+	// standalone mode for cron: go run main.go cancel-stale-orders
+	if len(os.Args) > 1 && os.Args[1] == "cancel-stale-orders" {
+		orderIds, err := expiryS.CancelStaleOrders()
+		if err != nil {
+			log.Fatalf("cancel-stale-orders: %v", err)
+		}
+		log.Printf("cancelled %d order(s)", len(orderIds))
+		return
+	}
+	if interval := durationEnv("ORDER_EXPIRY_INTERVAL", 10*time.Minute); interval > 0 {
+		go expiryS.Run(context.Background(), interval)
+	}
+
+	promoS := services.NewPromotionService(promoR, pR)
+	baseCurrency := os.Getenv("BASE_CURRENCY")
+	if baseCurrency == "" {
+		baseCurrency = "RUB"
+	}
+	curS := services.NewCurrencyService(curR, baseCurrency)
+	taxRegion := os.Getenv("DEFAULT_TAX_REGION")
+	if taxRegion == "" {
+		taxRegion = "RU"
+	}
+	taxS := services.NewTaxService(taxR, taxRegion)
+	storeName := os.Getenv("STORE_NAME")
+	if storeName == "" {
+		storeName = "toyStore"
+	}
+	dlvS := services.NewDeliveryService(dlvR)
+	giftWrapPrice := models.Money(15000)
+	if value := os.Getenv("GIFT_WRAP_PRICE"); value != "" {
+		giftWrapPrice, err = models.ParseMoney(value)
+		if err != nil || giftWrapPrice < 0 {
+			log.Fatalf("GIFT_WRAP_PRICE must be an amount like 150.00")
+		}
+	}
+	var mailS services.MailSender
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "noreply@toystore.local"
+	}
+	if smtpHost := os.Getenv("MAIL_SMTP_HOST"); smtpHost != "" {
+		smtpPort := os.Getenv("MAIL_SMTP_PORT")
+		if smtpPort == "" {
+			smtpPort = "25"
+		}
+		mailS = services.NewSMTPMailSender(smtpHost, smtpPort, os.Getenv("MAIL_SMTP_USER"), os.Getenv("MAIL_SMTP_PASSWORD"), mailFrom)
+	} else {
+		// without smtp the letters are saved to files, handy for development
+		mailDir := os.Getenv("MAIL_DIR")
+		if mailDir == "" {
+			mailDir = "mail"
+		}
+		mailS = services.NewFileMailSender(mailDir, mailFrom)
+	}
+	appUrl := os.Getenv("APP_URL")
+	if appUrl == "" {
+		appUrl = "http://localhost:8080"
+	}
+	resetTtl := durationEnv("PASSWORD_RESET_TTL", 30*time.Minute)
+	if resetTtl == 0 {
+		log.Fatalf("PASSWORD_RESET_TTL must be positive")
+	}
+	verifySecret := os.Getenv("EMAIL_VERIFY_SECRET")
+	if verifySecret == "" {
+		log.Fatalf("EMAIL_VERIFY_SECRET must be set")
+	}
+	evS := services.NewEmailVerificationService(uR, mailS, verifySecret, durationEnv("EMAIL_VERIFY_TTL", 24*time.Hour), appUrl)
+	loginLockout := durationEnv("LOGIN_LOCKOUT", 15*time.Minute)
+	if loginLockout == 0 {
+		log.Fatalf("LOGIN_LOCKOUT must be positive")
+	}
+	loginS := services.NewLoginAttemptService(loginR, intEnv("LOGIN_MAX_ATTEMPTS", 10), intEnv("LOGIN_IP_MAX_ATTEMPTS", 50), loginLockout)
+	var totpRoles []string
+	for _, v := range strings.Split(os.Getenv("TOTP_REQUIRED_ROLES"), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			totpRoles = append(totpRoles, v)
+		}
+	}
+	totpS := services.NewTotpService(totpR, uR, sR, tokR, storeName, totpRoles)
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		log.Fatalf("JWT_SECRET must be set")
+	}
+	accessTtl := durationEnv("ACCESS_TOKEN_TTL", 15*time.Minute)
+	refreshTtl := durationEnv("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	if accessTtl == 0 || refreshTtl == 0 {
+		log.Fatalf("ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL must be positive")
+	}
+	csrfSecret := os.Getenv("CSRF_SECRET")
+	if csrfSecret == "" {
+		log.Fatalf("CSRF_SECRET must be set")
+	}
+	webhookSecret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	if webhookSecret == "" {
+		log.Fatalf("PAYMENT_WEBHOOK_SECRET must be set")
+	}
+	payS := services.NewPaymentService(sR, oR, payR, services.NewFakePaymentProvider(webhookSecret))
 	hp := handlers.HandlerParams{
-		UsrService:  services.NewUserService(uR, sR),
+		UsrService:  services.NewUserService(uR, sR, roleR, evS, loginS, totpS),
 		PrdService:  services.NewProductService(pR, aR, cR),
-		CrtService:  services.NewCartService(pR, cartR),
+		CrtService:  services.NewCartService(pR, cartR, promoS, taxS),
 		CatsService: services.NewCategoryService(cR, pR),
 		AtrService:  services.NewAttributeService(aR),
-		OrdService:  services.NewOrderService(sR, pR, cartR, oR),
+		OrdService:  services.NewOrderService(sR, uR, pR, cartR, oR, promoS, curS, taxS, adrR, dlvS, giftWrapPrice),
+		PrmService:  promoS,
+		CurService:  curS,
+		TaxService:  taxS,
+		AdrService:  services.NewAddressService(sR, adrR),
+		DlvService:  dlvS,
+		PayService:  payS,
+		RetService:  services.NewReturnService(sR, oR, retR, payS),
+		IdmService:  services.NewIdempotencyService(idmR, 24*time.Hour),
+		InvService:  services.NewInvoiceService(sR, oR, roleR, baseCurrency, storeName),
+		RolService:  services.NewRoleService(roleR),
+		ShpService:  services.NewShipmentService(oR, shpR),
+		PwdService:  services.NewPasswordResetService(uR, sR, tokR, mailS, resetTtl, appUrl),
+		TotpService: totpS,
+		TknService:  services.NewAccessTokenService(sR, jwtSecret, accessTtl, refreshTtl),
+		KeyService:  services.NewApiKeyService(sR, uR, roleR, keyR),
+		CsrService:  services.NewCsrfService(csrfSecret),
+
+		SecureCookies: boolEnv("COOKIE_SECURE", true),
 	}
 	ha := handlers.NewHandler(hp)
 	router := mux.NewRouter()
 	router.Use(ha.ErrorHandleMiddleware)
+	router.Use(ha.CsrfMiddleware)
+	router.Use(ha.IdempotencyMiddleware)
 	subAuth := router.NewRoute().Subrouter()
 	subAuth.Use(ha.AuthMiddleware)
-	subManAuth := router.NewRoute().Subrouter()
-	subManAuth.Use(ha.ManagerAuthMiddleware)
+	// every staff route is registered on the subrouter of the permission it needs
+	subCatalog := router.NewRoute().Subrouter()
+	subCatalog.Use(ha.RequirePermission(models.PermCatalogWrite))
+	subPromotions := router.NewRoute().Subrouter()
+	subPromotions.Use(ha.RequirePermission(models.PermPromotionsManage))
+	subOrdersRead := router.NewRoute().Subrouter()
+	subOrdersRead.Use(ha.RequirePermission(models.PermOrdersRead))
+	subOrdersManage := router.NewRoute().Subrouter()
+	subOrdersManage.Use(ha.RequirePermission(models.PermOrdersManage))
+	subRefunds := router.NewRoute().Subrouter()
+	subRefunds.Use(ha.RequirePermission(models.PermPaymentsRefund))
+	subSettings := router.NewRoute().Subrouter()
+	subSettings.Use(ha.RequirePermission(models.PermSettingsManage))
+	subUsers := router.NewRoute().Subrouter()
+	subUsers.Use(ha.RequirePermission(models.PermUsersManage))
+	subRoles := router.NewRoute().Subrouter()
+	subRoles.Use(ha.RequirePermission(models.PermRolesManage))
+	subApiKeys := router.NewRoute().Subrouter()
+	subApiKeys.Use(ha.RequirePermission(models.PermApiKeysManage))
 
-	router.HandleFunc("/", ha.Welcome)
-	router.HandleFunc("/users/signin", ha.Signin)
-	router.HandleFunc("/users/signup", ha.Signup)
-	subAuth.HandleFunc("/users/refresh", ha.Refresh)
-	subAuth.HandleFunc("/users/logout", ha.Logout)
-	subAuth.HandleFunc("/users/change_password", ha.ChangePassword)
-	subManAuth.HandleFunc("/users/create", ha.CreateUser)
+	router.HandleFunc("/", ha.Welcome).Methods("GET")
+	router.HandleFunc("/users/signin", ha.Signin).Methods("POST")
+	router.HandleFunc("/users/signin/totp", ha.SigninTotp).Methods("POST")
+	router.HandleFunc("/users/token", ha.CreateToken).Methods("POST")
+	router.HandleFunc("/users/token/totp", ha.CreateTokenTotp).Methods("POST")
+	router.HandleFunc("/users/token/refresh", ha.RefreshToken).Methods("POST")
+	router.HandleFunc("/users/token/revoke", ha.RevokeToken).Methods("POST")
+	router.HandleFunc("/users/signup", ha.Signup).Methods("POST")
+	router.HandleFunc("/users/password/forgot", ha.ForgotPassword).Methods("POST")
+	router.HandleFunc("/users/password/reset", ha.ResetPassword).Methods("POST")
+	subAuth.HandleFunc("/users/refresh", ha.Refresh).Methods("POST")
+	subAuth.HandleFunc("/users/logout", ha.Logout).Methods("POST")
+	subAuth.HandleFunc("/users/change_password", ha.ChangePassword).Methods("POST")
+	subAuth.HandleFunc("/users/me", ha.GetProfile).Methods("GET")
+	subAuth.HandleFunc("/users/me", ha.UpdateProfile).Methods("PATCH")
+	subAuth.HandleFunc("/users/me/email/verify", ha.SendEmailVerification).Methods("POST")
+	router.HandleFunc("/users/email/verify", ha.VerifyEmail).Methods("POST")
+	subAuth.HandleFunc("/users/me/sessions", ha.GetSessions).Methods("GET")
+	subAuth.HandleFunc("/users/me/sessions/{id:[0-9a-f]+}", ha.DeleteSession).Methods("DELETE")
+	subAuth.HandleFunc("/users/me/totp/setup", ha.SetupTotp).Methods("POST")
+	subAuth.HandleFunc("/users/me/totp/enable", ha.EnableTotp).Methods("POST")
+	subAuth.HandleFunc("/users/me/totp/disable", ha.DisableTotp).Methods("POST")
+	subAuth.HandleFunc("/users/me/totp/recovery-codes", ha.RegenerateRecoveryCodes).Methods("POST")
+	subUsers.HandleFunc("/users/create", ha.CreateUser).Methods("POST")
+	subUsers.HandleFunc("/users", ha.SearchUsers).Methods("GET")
+	subUsers.HandleFunc("/users/{id:[0-9]+}", ha.GetUser).Methods("GET")
+	subUsers.HandleFunc("/users/{id:[0-9]+}/update/role", ha.SetUserRole).Methods("POST")
+	subUsers.HandleFunc("/users/{id:[0-9]+}/block", ha.BlockUser).Methods("POST")
+	subUsers.HandleFunc("/users/{id:[0-9]+}/unblock", ha.UnblockUser).Methods("POST")
+	subUsers.HandleFunc("/users/{id:[0-9]+}/delete", ha.DeleteUser).Methods("DELETE")
+	subUsers.HandleFunc("/users/locked", ha.GetLockedAccounts).Methods("GET")
+	subUsers.HandleFunc("/users/{id:[0-9]+}/unlock", ha.UnlockUser).Methods("POST")
+	subUsers.HandleFunc("/users/{id:[0-9]+}/totp/reset", ha.ResetUserTotp).Methods("POST")
+	subRoles.HandleFunc("/roles", ha.GetRoles).Methods("GET")
+	subRoles.HandleFunc("/permissions", ha.GetPermissions).Methods("GET")
+	subRoles.HandleFunc("/roles/create", ha.CreateRole).Methods("POST")
+	subRoles.HandleFunc("/roles/{name:[a-z][a-z0-9_]+}/update", ha.UpdateRole).Methods("POST")
+	subRoles.HandleFunc("/roles/{name:[a-z][a-z0-9_]+}/delete", ha.DeleteRole).Methods("DELETE")
+	subApiKeys.HandleFunc("/api-keys", ha.GetApiKeys).Methods("GET")
+	subApiKeys.HandleFunc("/api-keys/create", ha.CreateApiKey).Methods("POST")
+	subApiKeys.HandleFunc("/api-keys/{id:[0-9]+}/revoke", ha.RevokeApiKey).Methods("POST")
+	subAuth.HandleFunc("/users/addresses", ha.GetUserAddresses).Methods("GET")
+	subAuth.HandleFunc("/users/addresses/create", ha.CreateAddress).Methods("POST")
+	subAuth.HandleFunc("/users/addresses/{id:[0-9]+}/update", ha.UpdateAddress).Methods("POST")
+	subAuth.HandleFunc("/users/addresses/{id:[0-9]+}/delete", ha.DeleteAddress).Methods("DELETE")
 
 	router.HandleFunc("/cart", ha.GetCart).Methods("GET")
 	router.HandleFunc("/cart", ha.DeleteFromCart).Methods("DELETE")
 	router.HandleFunc("/cart", ha.AddToCart).Methods("POST")
-	subAuth.HandleFunc("/cart/buy", ha.CreateOrder)
-
-	router.HandleFunc("/products/{id:[0-9]+}", ha.GetProduct)
-	subManAuth.HandleFunc("/products/{id:[0-9]+}/update", ha.UpdateProduct)
-	subManAuth.HandleFunc("/products/{id:[0-9]+}/update/attribute", ha.UpdateProductAttributes).Methods("POST")
-	subManAuth.HandleFunc("/products/{id:[0-9]+}/delete/attribute", ha.RemoveProductAttributes).Methods("DELETE")
-	subManAuth.HandleFunc("/products/{id:[0-9]+}/update/category", ha.UpdateProductCategory).Methods("POST")
-	subManAuth.HandleFunc("/products/{id:[0-9]+}/delete/category", ha.RemoveProductCategory).Methods("DELETE")
-
-	subManAuth.HandleFunc("/attributes/create", ha.CreateAttribute).Methods("POST")
-	subManAuth.HandleFunc("/attributes/{id:[0-9]+}/update", ha.UpdateAttribute).Methods("POST")
-	router.HandleFunc("/categories", ha.GetAllCategories)
-	router.HandleFunc("/categories/{id:[0-9]+}", ha.GetCategoryWithProducts)
-	subManAuth.HandleFunc("/categories/create", ha.CreateCategory).Methods("POST")
-	subManAuth.HandleFunc("/categories/{id:[0-9]+}/update", ha.UpdateCategory).Methods("POST")
-
-	subManAuth.HandleFunc("/orders/{id:[0-9]+}", ha.GetOrderById)
-	subManAuth.HandleFunc("/orders/search", ha.SearchOrders)
-	subAuth.HandleFunc("/orders/", ha.GetCurrentUserOrders)
-	subAuth.HandleFunc("/orders/{id:[0-9]+}/cancel", ha.CancelOrder)
-	subManAuth.HandleFunc("/orders/{id:[0-9]+}/update", ha.SetOrderStatus).Methods("POST")
+	subAuth.HandleFunc("/cart/buy", ha.CreateOrder).Methods("POST")
+
+	router.HandleFunc("/delivery-methods", ha.GetDeliveryMethods).Methods("GET")
+	subSettings.HandleFunc("/delivery-methods/create", ha.CreateDeliveryMethod).Methods("POST")
+	subSettings.HandleFunc("/delivery-methods/{id:[0-9]+}/update", ha.UpdateDeliveryMethod).Methods("POST")
+
+	router.HandleFunc("/products/{id:[0-9]+}", ha.GetProduct).Methods("GET")
+	router.HandleFunc("/products/{id:[0-9]+}/price-history", ha.GetPriceHistory).Methods("GET")
+	subCatalog.HandleFunc("/products/{id:[0-9]+}/update", ha.UpdateProduct).Methods("POST")
+	subCatalog.HandleFunc("/products/{id:[0-9]+}/update/attribute", ha.UpdateProductAttributes).Methods("POST")
+	subCatalog.HandleFunc("/products/{id:[0-9]+}/delete/attribute", ha.RemoveProductAttributes).Methods("DELETE")
+	subCatalog.HandleFunc("/products/{id:[0-9]+}/update/category", ha.UpdateProductCategory).Methods("POST")
+	subCatalog.HandleFunc("/products/{id:[0-9]+}/delete/category", ha.RemoveProductCategory).Methods("DELETE")
+	subCatalog.HandleFunc("/products/{id:[0-9]+}/update/tax", ha.UpdateProductTaxClass).Methods("POST")
+	subCatalog.HandleFunc("/products/{id:[0-9]+}/update/sale", ha.ScheduleSalePrice).Methods("POST")
+	subCatalog.HandleFunc("/products/{id:[0-9]+}/delete/sale", ha.RemoveSalePrice).Methods("DELETE")
+
+	subCatalog.HandleFunc("/attributes/create", ha.CreateAttribute).Methods("POST")
+	subCatalog.HandleFunc("/attributes/{id:[0-9]+}/update", ha.UpdateAttribute).Methods("POST")
+	router.HandleFunc("/categories", ha.GetAllCategories).Methods("GET")
+	router.HandleFunc("/categories/{id:[0-9]+}", ha.GetCategoryWithProducts).Methods("GET")
+	subCatalog.HandleFunc("/categories/create", ha.CreateCategory).Methods("POST")
+	subCatalog.HandleFunc("/categories/{id:[0-9]+}/update", ha.UpdateCategory).Methods("POST")
+	subCatalog.HandleFunc("/categories/{id:[0-9]+}/update/tax", ha.UpdateCategoryTaxClass).Methods("POST")
+
+	subOrdersRead.HandleFunc("/orders/{id:[0-9]+}", ha.GetOrderById).Methods("GET")
+	subOrdersRead.HandleFunc("/orders/search", ha.SearchOrders).Methods("GET")
+	subAuth.HandleFunc("/orders/", ha.GetCurrentUserOrders).Methods("GET")
+	subAuth.HandleFunc("/orders/{id:[0-9]+}/cancel", ha.CancelOrder).Methods("POST")
+	subAuth.HandleFunc("/orders/{id:[0-9]+}/reorder", ha.Reorder).Methods("POST")
+	subOrdersRead.HandleFunc("/orders/{id:[0-9]+}/history", ha.GetOrderStatusHistory).Methods("GET")
+	subOrdersManage.HandleFunc("/orders/{id:[0-9]+}/update", ha.SetOrderStatus).Methods("POST")
+	subOrdersManage.HandleFunc("/orders/{id:[0-9]+}/items/{itemId:[0-9]+}/update", ha.EditOrderItem).Methods("POST")
+	subOrdersManage.HandleFunc("/orders/{id:[0-9]+}/items/{itemId:[0-9]+}/delete", ha.RemoveOrderItem).Methods("DELETE")
+	subOrdersRead.HandleFunc("/orders/{id:[0-9]+}/changes", ha.GetOrderChanges).Methods("GET")
+	subOrdersRead.HandleFunc("/orders/{id:[0-9]+}/notes", ha.GetOrderNotes).Methods("GET")
+	subOrdersManage.HandleFunc("/orders/{id:[0-9]+}/notes/create", ha.AddOrderNote).Methods("POST")
+	subAuth.HandleFunc("/orders/{id:[0-9]+}/pay", ha.CreatePayment).Methods("POST")
+	subOrdersRead.HandleFunc("/orders/{id:[0-9]+}/payments", ha.GetOrderPayments).Methods("GET")
+	router.HandleFunc("/payments/webhook/{provider}", ha.PaymentWebhook).Methods("POST")
+	subRefunds.HandleFunc("/orders/{id:[0-9]+}/refund", ha.RefundOrder).Methods("POST")
+	subOrdersRead.HandleFunc("/orders/{id:[0-9]+}/refunds", ha.GetOrderRefunds).Methods("GET")
+	subAuth.HandleFunc("/orders/{id:[0-9]+}/invoice", ha.GetInvoice).Methods("GET")
+	subOrdersRead.HandleFunc("/orders/{id:[0-9]+}/packing-slip", ha.GetPackingSlip).Methods("GET")
+	subOrdersRead.HandleFunc("/orders/{id:[0-9]+}/shipments", ha.GetOrderShipments).Methods("GET")
+	subOrdersManage.HandleFunc("/orders/{id:[0-9]+}/shipments/create", ha.CreateShipment).Methods("POST")
+	subSettings.HandleFunc("/carriers", ha.GetCarriers).Methods("GET")
+	subSettings.HandleFunc("/carriers/create", ha.CreateCarrier).Methods("POST")
+	subSettings.HandleFunc("/carriers/{id:[0-9]+}/update", ha.UpdateCarrier).Methods("POST")
+
+	subAuth.HandleFunc("/orders/{id:[0-9]+}/returns/create", ha.CreateReturn).Methods("POST")
+	subAuth.HandleFunc("/users/returns", ha.GetUserReturns).Methods("GET")
+	subOrdersRead.HandleFunc("/returns", ha.SearchReturns).Methods("GET")
+	subOrdersRead.HandleFunc("/returns/{id:[0-9]+}", ha.GetReturn).Methods("GET")
+	subOrdersManage.HandleFunc("/returns/{id:[0-9]+}/update", ha.SetReturnStatus).Methods("POST")
+	subOrdersManage.HandleFunc("/returns/{id:[0-9]+}/receive", ha.ReceiveReturn).Methods("POST")
+
+	subSettings.HandleFunc("/taxes", ha.GetTaxClasses).Methods("GET")
+	subSettings.HandleFunc("/taxes/create", ha.CreateTaxClass).Methods("POST")
+	subSettings.HandleFunc("/taxes/{id:[0-9]+}/update/rate", ha.SetTaxRate).Methods("POST")
+
+	router.HandleFunc("/currencies", ha.GetCurrencies).Methods("GET")
+	subSettings.HandleFunc("/currencies/update", ha.UpdateCurrencyRate).Methods("POST")
+	subSettings.HandleFunc("/currencies/import", ha.ImportCurrencyRates).Methods("POST")
+
+	subPromotions.HandleFunc("/promotions", ha.GetAllPromotions).Methods("GET")
+	subPromotions.HandleFunc("/promotions/create", ha.CreatePromotion).Methods("POST")
+	subPromotions.HandleFunc("/promotions/{id:[0-9]+}/update", ha.UpdatePromotion).Methods("POST")
 
 	log.Printf("starting server...")
 	http.ListenAndServe(":8080", router)
@@ -122,3 +365,42 @@ func initDB() {
 		panic("redis is not working: " + status.Err().Error())
 	}
 }
+
+// durationEnv reads a duration like "30m" or "24h", 0 is allowed to switch a job off
+func durationEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		log.Fatalf("%v must be a duration like 30m or 24h", name)
+	}
+	return d
+}
+
+// boolEnv reads true or false
+func boolEnv(name string, def bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%v must be true or false", name)
+	}
+	return b
+}
+
+// intEnv reads a positive number
+func intEnv(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		log.Fatalf("%v must be a positive number", name)
+	}
+	return n
+}
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"net/url"
+	"strings"
 	"time"
 )
 
@@ -12,26 +14,112 @@ var ErrUnautorized = errors.New("unautorized")
 var ErrServerError = errors.New("server error")
 var ErrNotFoundError = errors.New("not found")
 var ErrNotAllowed = errors.New("not acceptable")
+var ErrConflict = errors.New("conflict")
+var ErrTooManyRequests = errors.New("too many requests")
 
 type Credentials struct {
 	Password string `json:"password" db:"Password"`
 	Username string `json:"username" db:"Nickname"`
+	Email    string `json:"email" db:"Email"`
 	Role     string `json:"role" db:"Role"`
 }
 
+// SessionMeta is where a session is signed in from, Token is set for the sessions of bearer tokens
+type SessionMeta struct {
+	Ip        string
+	UserAgent string
+	Device    string
+	Token     bool
+}
+
+// SessionTtl is how long a cookie session lives after the sign in or the last refresh
+const SessionTtl = 30 * time.Minute
+
+// CartTtl is how long a cart lives after the last change
+const CartTtl = 24 * time.Hour
+
+// TokenSessionPrefix starts the ids of the sessions of bearer tokens, such an id is not accepted as a cookie
+const TokenSessionPrefix = "tok-"
+
+const (
+	SessionTypeCookie = "cookie"
+	SessionTypeToken  = "token"
+)
+
+// Session_db is a session in the list of the user sessions, Id is not the session cookie
+type Session_db struct {
+	Id         string    `json:"id"`
+	Ip         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	Device     string    `json:"device"`
+	Type       string    `json:"type"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+// TokenPair is issued by the token sign-in and by the refresh, the refresh token works once
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// SigninChallenge is the answer of the first sign-in step when the user has 2FA
+type SigninChallenge struct {
+	TotpRequired bool   `json:"totp_required"`
+	MfaToken     string `json:"mfa_token"`
+}
+
+// TotpSigninRequest is the second sign-in step, with a code of the app or a recovery code
+type TotpSigninRequest struct {
+	MfaToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type TotpSetup struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+type TotpCodeRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+type EmailVerifyRequest struct {
+	Token string `json:"token"`
+}
+
+type PasswordResetRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
 type PasswordData struct {
 	OldPassword string `json:"old_password"`
 	NewPassword string `json:"new_password"`
 }
 
 type Product struct {
-	Id           int     `json:"id" db:"Id"`
-	Name         string  `json:"name" db:"Name"`
-	Manufacturer string  `json:"manufacturer" db:"Manufacturer"`
-	Quantity     int     `json:"quantity" db:"Quantity"`
-	Price        float64 `json:"price" db:"Price"`
-	Description  string  `json:"description" db:"Description"`
-	Available    *bool   `json:"available,omitempty"`
+	Id           int    `json:"id" db:"Id"`
+	Name         string `json:"name" db:"Name"`
+	Manufacturer string `json:"manufacturer" db:"Manufacturer"`
+	Quantity     int    `json:"quantity" db:"Quantity"`
+	Price        Money  `json:"price" db:"Price"`
+	Weight       int    `json:"weight"`
+	Description  string `json:"description" db:"Description"`
+	Available    *bool  `json:"available,omitempty"`
 }
 
 type Category_db struct {
@@ -80,11 +168,35 @@ type Product_db struct {
 	Name         string         `json:"name" db:"Name"`
 	Manufacturer string         `json:"manufacturer" db:"Manufacturer"`
 	Quantity     int            `json:"quantity" db:"Quantity"`
-	Price        float64        `json:"price" db:"Price"`
+	Price        Money          `json:"price" db:"Price"`
+	RegularPrice Money          `json:"regular_price"`
+	SaleEndDate  *time.Time     `json:"sale_end_date,omitempty"`
+	Weight       int            `json:"weight"`
 	Description  sql.NullString `json:"description" db:"Description"`
 	Available    bool           `json:"available" db:"Available"`
 }
 
+const (
+	PriceRegular = "regular"
+	PriceSale    = "sale"
+)
+
+type ProductPrice_db struct {
+	Id        int        `json:"id"`
+	ProductId int        `json:"product_id"`
+	Price     Money      `json:"price"`
+	Kind      string     `json:"kind"`
+	StartDate time.Time  `json:"start_date"`
+	EndDate   *time.Time `json:"end_date,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type SalePriceRequest struct {
+	Price     Money  `json:"price"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
 type ProductsCategories_db struct {
 	ProductId  int
 	CategoryId int
@@ -97,19 +209,36 @@ type ProductsAttributes_db struct {
 }
 
 type Order_db struct {
-	Id         int
-	UserId     int
-	Date       time.Time
-	TotalPrice float64
-	Status     string
+	Id               int
+	UserId           int
+	Date             time.Time
+	TotalPrice       Money
+	Discount         Money
+	Tax              Money
+	TaxRegion        string
+	Currency         string
+	ExchangeRate     Rate
+	DeliveryMethodId sql.NullInt64
+	DeliveryMethod   string
+	ShippingPrice    Money
+	ShippingAddress  *Address_db
+	RefundedAmount   Money
+	Note             string
+	GiftMessage      string
+	GiftWrap         bool
+	GiftWrapPrice    Money
+	Status           string
 }
 
 type OrdersProducts_db struct {
-	Id        int
-	OrderId   int
-	ProductId int
-	Quantity  int
-	Price     float64
+	Id          int
+	OrderId     int
+	ProductId   int
+	Quantity    int
+	Price       Money
+	Discount    Money
+	Tax         Money
+	TaxIncluded bool
 }
 
 type Attribute_db struct {
@@ -118,8 +247,395 @@ type Attribute_db struct {
 }
 
 type User_db struct {
-	Id       int
-	Nickname string `json:"username" db:"Nickname"`
-	Password string `json:"password" db:"Password"`
-	Role     string `json:"role" db:"Role"`
+	Id              int
+	Nickname        string `json:"username" db:"Nickname"`
+	Password        string `json:"password" db:"Password"`
+	Role            string `json:"role" db:"Role"`
+	Email           string `json:"email" db:"Email"`
+	EmailVerifiedAt *time.Time
+	DisplayName     string
+	Phone           string
+	BirthDate       *time.Time
+	TotpSecret      string
+	TotpEnabled     bool
+	Blocked         bool `json:"blocked" db:"Blocked"`
+	CreatedAt       time.Time
+	DeletedAt       *time.Time
+}
+
+// UserInfo is a user for managers and the profile of the current user, without the password
+type UserInfo struct {
+	Id            int        `json:"id"`
+	Username      string     `json:"username"`
+	Role          string     `json:"role"`
+	Email         string     `json:"email"`
+	EmailVerified bool       `json:"email_verified"`
+	DisplayName   string     `json:"display_name"`
+	Phone         string     `json:"phone"`
+	BirthDate     string     `json:"birth_date,omitempty"`
+	TotpEnabled   bool       `json:"totp_enabled"`
+	Blocked       bool       `json:"blocked"`
+	CreatedAt     time.Time  `json:"created_at"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+}
+
+// BirthDateLayout is the format of the birth date in requests and responses
+const BirthDateLayout = "2006-01-02"
+
+func (u User_db) Info() UserInfo {
+	info := UserInfo{
+		Id:            u.Id,
+		Username:      u.Nickname,
+		Role:          u.Role,
+		Email:         u.Email,
+		EmailVerified: u.EmailVerifiedAt != nil,
+		DisplayName:   u.DisplayName,
+		Phone:         u.Phone,
+		TotpEnabled:   u.TotpEnabled,
+		Blocked:       u.Blocked,
+		CreatedAt:     u.CreatedAt,
+		DeletedAt:     u.DeletedAt,
+	}
+	if u.BirthDate != nil {
+		info.BirthDate = u.BirthDate.Format(BirthDateLayout)
+	}
+	return info
+}
+
+// ProfileUpdateRequest changes only the fields that are sent, an empty string clears
+// the display name, phone or birth date
+type ProfileUpdateRequest struct {
+	Email       *string `json:"email"`
+	DisplayName *string `json:"display_name"`
+	Phone       *string `json:"phone"`
+	BirthDate   *string `json:"birth_date"`
+}
+
+const (
+	LoginScopeAccount = "account"
+	LoginScopeIp      = "ip"
+)
+
+// LoginLock_db is an account locked after failed sign-ins, the username may be of a user that does not exist
+type LoginLock_db struct {
+	Username    string    `json:"username"`
+	UserId      int       `json:"user_id,omitempty"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+type UserSearchData struct {
+	Query   string
+	Role    *string
+	Blocked *bool
+	Page    int
+	PerPage int
+}
+
+const (
+	PromotionBuyXGetY  = "buy_x_get_y"
+	PromotionBundle    = "bundle"
+	PromotionCartTotal = "cart_total"
+)
+
+type Promotion_db struct {
+	Id              int    `json:"id"`
+	Name            string `json:"name"`
+	Type            string `json:"type"`
+	CategoryId      int    `json:"category_id,omitempty"`
+	ProductIds      []int  `json:"product_ids,omitempty"`
+	BuyQuantity     int    `json:"buy_quantity,omitempty"`
+	FreeQuantity    int    `json:"free_quantity,omitempty"`
+	MinTotal        Money  `json:"min_total,omitempty"`
+	DiscountPercent int    `json:"discount_percent,omitempty"`
+	Active          bool   `json:"active"`
+}
+
+type ExchangeRate_db struct {
+	Currency  string    `json:"currency"`
+	Rate      Rate      `json:"rate"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type TaxClass_db struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type TaxRate_db struct {
+	Id         int     `json:"id"`
+	TaxClassId int     `json:"tax_class_id"`
+	Region     string  `json:"region"`
+	Rate       Percent `json:"rate"`
+	Inclusive  bool    `json:"inclusive"`
+}
+
+type Address_db struct {
+	Id         int    `json:"id"`
+	UserId     int    `json:"-"`
+	Recipient  string `json:"recipient"`
+	Phone      string `json:"phone"`
+	Country    string `json:"country"`
+	Region     string `json:"region"`
+	City       string `json:"city"`
+	Street     string `json:"street"`
+	PostalCode string `json:"postal_code"`
+}
+
+const (
+	DeliveryFlat          = "flat"
+	DeliveryWeight        = "weight"
+	DeliveryFreeThreshold = "free_threshold"
+	DeliveryPickup        = "pickup"
+)
+
+type DeliveryMethod_db struct {
+	Id            int    `json:"id"`
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	Price         Money  `json:"price"`
+	PricePerKg    Money  `json:"price_per_kg,omitempty"`
+	FreeThreshold Money  `json:"free_threshold,omitempty"`
+	Active        bool   `json:"active"`
+}
+
+type CheckoutRequest struct {
+	AddressId        int    `json:"address_id"`
+	DeliveryMethodId int    `json:"delivery_method_id"`
+	Note             string `json:"note"`
+	GiftMessage      string `json:"gift_message"`
+	GiftWrap         bool   `json:"gift_wrap"`
+}
+
+// OrderNote_db is an internal note of a manager, customers never see it
+type OrderNote_db struct {
+	Id        int       `json:"id"`
+	OrderId   int       `json:"order_id"`
+	AuthorId  int       `json:"author_id"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+const (
+	PaymentPending   = "pending"
+	PaymentSucceeded = "succeeded"
+	PaymentFailed    = "failed"
+	// the order was changed while the payment was pending, it can not be paid by the old amount
+	PaymentCancelled = "cancelled"
+	// the provider took the money, but the payment does not pay the order any more: the order
+	// was changed or cancelled before the payment succeeded. Such money has to be refunded.
+	PaymentUnmatched = "unmatched"
+	// an unmatched payment returned to the customer
+	PaymentRefunded = "refunded"
+)
+
+type Payment_db struct {
+	Id                int       `json:"id"`
+	OrderId           int       `json:"order_id"`
+	Provider          string    `json:"provider"`
+	ProviderPaymentId string    `json:"provider_payment_id"`
+	Amount            Money     `json:"amount"`
+	Currency          string    `json:"currency"`
+	Status            string    `json:"status"`
+	ConfirmationUrl   string    `json:"confirmation_url,omitempty"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// PaymentEvent is a webhook notification already verified and parsed by the provider
+type PaymentEvent struct {
+	EventId           string `json:"event_id"`
+	ProviderPaymentId string `json:"payment_id"`
+	Status            string `json:"status"`
+	Amount            Money  `json:"amount"`
+}
+
+type PaymentRequest struct {
+	Provider string `json:"provider"`
+}
+
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+	ReturnReceived  = "received"
+	ReturnRefunding = "refunding"
+	ReturnRefunded  = "refunded"
+)
+
+type Return_db struct {
+	Id        int             `json:"id"`
+	OrderId   int             `json:"order_id"`
+	UserId    int             `json:"user_id"`
+	Status    string          `json:"status"`
+	Reason    string          `json:"reason"`
+	Comment   string          `json:"comment"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Items     []ReturnItem_db `json:"items"`
+}
+
+type ReturnItem_db struct {
+	Id               int `json:"id"`
+	ReturnId         int `json:"-"`
+	OrderItemId      int `json:"order_item_id"`
+	ProductId        int `json:"product_id"`
+	Quantity         int `json:"quantity"`
+	ReceivedQuantity int `json:"received_quantity"`
+}
+
+type ReturnStatusRequest struct {
+	Status  string `json:"status"`
+	Comment string `json:"comment"`
+}
+
+// a refund is pending while the provider is called, the amount is already counted in the order
+const (
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+	RefundFailed    = "failed"
+)
+
+type Refund_db struct {
+	Id               int       `json:"id"`
+	OrderId          int       `json:"order_id"`
+	PaymentId        int       `json:"payment_id"`
+	ReturnId         *int      `json:"return_id,omitempty"`
+	Amount           Money     `json:"amount"`
+	CurrencyAmount   Money     `json:"currency_amount"`
+	Currency         string    `json:"currency"`
+	ProviderRefundId string    `json:"provider_refund_id"`
+	Status           string    `json:"status"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// RefundRequest without amount refunds the whole return, or the rest of the order without return_id
+type RefundRequest struct {
+	Amount   Money `json:"amount"`
+	ReturnId int   `json:"return_id"`
+}
+
+// IdempotentResponse is the saved response of a request with an Idempotency-Key, zero status means it is still in progress
+type IdempotentResponse struct {
+	Fingerprint string              `json:"fingerprint"`
+	Status      int                 `json:"status"`
+	Header      map[string][]string `json:"header"`
+	Body        []byte              `json:"body"`
+}
+
+type OrderStatusHistory_db struct {
+	Id        int       `json:"id"`
+	OrderId   int       `json:"order_id"`
+	OldStatus string    `json:"old_status"`
+	NewStatus string    `json:"new_status"`
+	Reason    string    `json:"reason"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+const (
+	OrderChangeQuantity   = "quantity"
+	OrderChangeRemove     = "remove"
+	OrderChangeSubstitute = "substitute"
+	OrderChangePrice      = "price"
+)
+
+type OrderChange_db struct {
+	Id        int       `json:"id"`
+	OrderId   int       `json:"order_id"`
+	ItemId    int       `json:"item_id"`
+	Action    string    `json:"action"`
+	OldValue  string    `json:"old_value"`
+	NewValue  string    `json:"new_value"`
+	Reason    string    `json:"reason"`
+	ChangedBy int       `json:"changed_by"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// OrderItemEditRequest changes an order line, only the given fields are changed. Price change needs a reason.
+type OrderItemEditRequest struct {
+	ProductId int    `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+	Price     *Money `json:"price"`
+	Reason    string `json:"reason"`
+}
+
+// Carrier_db is a delivery company, {tracking} in the template is replaced by the tracking number
+type Carrier_db struct {
+	Id                  int    `json:"id"`
+	Name                string `json:"name"`
+	TrackingUrlTemplate string `json:"tracking_url_template"`
+	Active              bool   `json:"active"`
+}
+
+func (c Carrier_db) TrackingUrl(trackingNumber string) string {
+	if c.TrackingUrlTemplate == "" {
+		return ""
+	}
+	return strings.ReplaceAll(c.TrackingUrlTemplate, "{tracking}", url.QueryEscape(trackingNumber))
+}
+
+// Shipment_db is a parcel with some lines of the order, the tracking url is made from the carrier template
+type Shipment_db struct {
+	Id             int               `json:"id"`
+	OrderId        int               `json:"order_id"`
+	CarrierId      int               `json:"carrier_id"`
+	Carrier        string            `json:"carrier"`
+	TrackingNumber string            `json:"tracking_number"`
+	TrackingUrl    string            `json:"tracking_url"`
+	ShippedAt      time.Time         `json:"shipped_at"`
+	Items          []ShipmentItem_db `json:"items"`
+}
+
+type ShipmentItem_db struct {
+	Id          int `json:"id"`
+	ShipmentId  int `json:"-"`
+	OrderItemId int `json:"order_item_id"`
+	ProductId   int `json:"product_id"`
+	Quantity    int `json:"quantity"`
+}
+
+const (
+	PermCatalogWrite     = "catalog:write"
+	PermPromotionsManage = "promotions:manage"
+	PermOrdersRead       = "orders:read"
+	PermOrdersManage     = "orders:manage"
+	PermPaymentsRefund   = "payments:refund"
+	PermSettingsManage   = "settings:manage"
+	PermUsersManage      = "users:manage"
+	PermRolesManage      = "roles:manage"
+	PermApiKeysManage    = "api_keys:manage"
+)
+
+// Permissions are all permissions the routes check, a role can only get these
+var Permissions = []string{PermCatalogWrite, PermPromotionsManage, PermOrdersRead, PermOrdersManage, PermPaymentsRefund, PermSettingsManage, PermUsersManage, PermRolesManage, PermApiKeysManage}
+
+// ApiKey_db is a key of an integration, the key itself is not stored, only its hash and the first characters
+type ApiKey_db struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  int        `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
+
+type ApiKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// NewApiKey is the answer of the key creation, the only time the key is shown
+type NewApiKey struct {
+	Id  int    `json:"id"`
+	Key string `json:"key"`
+}
+
+type Role_db struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
 }
@@ -0,0 +1,252 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidMoney = errors.New("invalid money value")
+
+// Money is an amount in minor units (kopecks), so sums never drift by a cent.
+// Values with more than two decimal places are rounded half away from zero.
+type Money int64
+
+func ParseMoney(value string) (Money, error) {
+	v, err := parseDecimal(value, 2)
+	return Money(v), err
+}
+
+// parseDecimal parses a decimal string into an integer scaled by 10^places,
+// rounding the rest half away from zero
+func parseDecimal(value string, places int) (int64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, ErrInvalidMoney
+	}
+	negative := false
+	switch value[0] {
+	case '-':
+		negative = true
+		value = value[1:]
+	case '+':
+		value = value[1:]
+	}
+	intPart, fracPart, _ := strings.Cut(value, ".")
+	if intPart == "" && fracPart == "" {
+		return 0, ErrInvalidMoney
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	if intPart[0] < '0' || intPart[0] > '9' {
+		return 0, ErrInvalidMoney
+	}
+	units, err := strconv.ParseInt(intPart, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidMoney
+	}
+	var frac int64
+	for i, ch := range fracPart {
+		if ch < '0' || ch > '9' {
+			return 0, ErrInvalidMoney
+		}
+		switch {
+		case i < places:
+			frac = frac*10 + int64(ch-'0')
+		case i == places && ch >= '5':
+			frac++
+		}
+	}
+	scale := int64(1)
+	for i := 0; i < places; i++ {
+		scale = scale * 10
+		if i >= len(fracPart) {
+			frac = frac * 10
+		}
+	}
+	v := units*scale + frac
+	if negative {
+		v = -v
+	}
+	return v, nil
+}
+
+func MoneyFromFloat(value float64) Money {
+	return Money(math.Round(value * 100))
+}
+
+func (m Money) Mul(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// Percent returns pct percent of the amount rounded half away from zero
+func (m Money) Percent(pct int) Money {
+	return m.MulRatio(int64(pct), 100)
+}
+
+// MulRatio returns m*num/den rounded half away from zero
+func (m Money) MulRatio(num int64, den int64) Money {
+	v := int64(m) * num
+	q, r := v/den, v%den
+	if r < 0 {
+		r = -r
+	}
+	if 2*r >= den {
+		if v < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return Money(q)
+}
+
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	value := strings.Trim(string(data), `"`)
+	if value == "null" {
+		*m = 0
+		return nil
+	}
+	parsed, err := ParseMoney(value)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func (m *Money) Scan(src any) (err error) {
+	switch v := src.(type) {
+	case []byte:
+		*m, err = ParseMoney(string(v))
+	case string:
+		*m, err = ParseMoney(v)
+	case int64:
+		*m = Money(v * 100)
+	case float64:
+		*m = MoneyFromFloat(v)
+	case nil:
+		*m = 0
+	default:
+		err = fmt.Errorf("can not scan %T into Money", src)
+	}
+	return
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// RateScale is the number of Rate units in 1.0
+const RateScale = 1000000
+
+// Rate is an exchange rate with six decimal places: units of a currency per one unit of the base currency
+type Rate int64
+
+func ParseRate(value string) (Rate, error) {
+	v, err := parseDecimal(value, 6)
+	return Rate(v), err
+}
+
+// Convert converts an amount in the base currency using the rate, rounding to kopecks
+func (m Money) Convert(rate Rate) Money {
+	return m.MulRatio(int64(rate), RateScale)
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d.%06d", int64(r)/RateScale, int64(r)%RateScale)
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	parsed, err := ParseRate(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func (r *Rate) Scan(src any) (err error) {
+	switch v := src.(type) {
+	case []byte:
+		*r, err = ParseRate(string(v))
+	case string:
+		*r, err = ParseRate(v)
+	case int64:
+		*r = Rate(v * RateScale)
+	case float64:
+		*r = Rate(math.Round(v * RateScale))
+	default:
+		err = fmt.Errorf("can not scan %T into Rate", src)
+	}
+	return
+}
+
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+// Percent is a percentage with two decimal places, 2000 means 20%
+type Percent int64
+
+func ParsePercent(value string) (Percent, error) {
+	v, err := parseDecimal(value, 2)
+	return Percent(v), err
+}
+
+// TaxOf returns the tax charged on top of a net amount
+func (m Money) TaxOf(rate Percent) Money {
+	return m.MulRatio(int64(rate), 10000)
+}
+
+// IncludedTax returns the tax contained in a gross amount
+func (m Money) IncludedTax(rate Percent) Money {
+	return m.MulRatio(int64(rate), 10000+int64(rate))
+}
+
+func (p Percent) String() string {
+	return Money(p).String()
+}
+
+func (p Percent) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Percent) UnmarshalJSON(data []byte) error {
+	parsed, err := ParsePercent(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+func (p *Percent) Scan(src any) error {
+	return (*Money)(p).Scan(src)
+}
+
+func (p Percent) Value() (driver.Value, error) {
+	return p.String(), nil
+}
@@ -0,0 +1,143 @@
+package models
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Money
+		err   bool
+	}{
+		{"12", 1200, false},
+		{"12.3", 1230, false},
+		{"12.34", 1234, false},
+		{"12.345", 1235, false},
+		{"12.344", 1234, false},
+		{"-12.345", -1235, false},
+		{"+0.5", 50, false},
+		{".99", 99, false},
+		{"0.995", 100, false},
+		{" 7.10 ", 710, false},
+		{"", 0, true},
+		{"abc", 0, true},
+		{"1.2x", 0, true},
+		{"-", 0, true},
+		{".", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseMoney(tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseMoney(%q) error = %v, want error %v", tt.value, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseMoney(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	tests := []struct {
+		value Money
+		want  string
+	}{
+		{0, "0.00"},
+		{5, "0.05"},
+		{1234, "12.34"},
+		{-1234, "-12.34"},
+		{-5, "-0.05"},
+	}
+	for _, tt := range tests {
+		if got := tt.value.String(); got != tt.want {
+			t.Errorf("Money(%d).String() = %q, want %q", int64(tt.value), got, tt.want)
+		}
+	}
+}
+
+func TestMoneyMulRatio(t *testing.T) {
+	tests := []struct {
+		value    Money
+		num, den int64
+		want     Money
+	}{
+		{1000, 1, 3, 333},
+		{1000, 2, 3, 667},
+		{1, 1, 2, 1},
+		{-1, 1, 2, -1},
+		{-1000, 2, 3, -667},
+		{999, 0, 7, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.value.MulRatio(tt.num, tt.den); got != tt.want {
+			t.Errorf("%v.MulRatio(%d, %d) = %v, want %v", tt.value, tt.num, tt.den, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyPercent(t *testing.T) {
+	tests := []struct {
+		value Money
+		pct   int
+		want  Money
+	}{
+		{10000, 10, 1000},
+		{999, 15, 150},
+		{333, 50, 167},
+		{100, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.value.Percent(tt.pct); got != tt.want {
+			t.Errorf("%v.Percent(%d) = %v, want %v", tt.value, tt.pct, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyConvert(t *testing.T) {
+	tests := []struct {
+		value Money
+		rate  string
+		want  Money
+	}{
+		{10000, "1", 10000},
+		{10000, "0.011", 110},
+		{12345, "5.123456", 63249},
+		{1, "0.5", 1},
+		{2999, "0.012345", 37},
+	}
+	for _, tt := range tests {
+		rate, err := ParseRate(tt.rate)
+		if err != nil {
+			t.Fatalf("ParseRate(%q): %v", tt.rate, err)
+		}
+		if got := tt.value.Convert(rate); got != tt.want {
+			t.Errorf("%v.Convert(%v) = %v, want %v", tt.value, tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyTax(t *testing.T) {
+	tests := []struct {
+		value        Money
+		rate         string
+		wantOnTop    Money
+		wantIncluded Money
+	}{
+		{10000, "20", 2000, 1667},
+		{12000, "20", 2400, 2000},
+		{999, "10", 100, 91},
+		{10000, "0", 0, 0},
+		{10000, "7.5", 750, 698},
+	}
+	for _, tt := range tests {
+		rate, err := ParsePercent(tt.rate)
+		if err != nil {
+			t.Fatalf("ParsePercent(%q): %v", tt.rate, err)
+		}
+		if got := tt.value.TaxOf(rate); got != tt.wantOnTop {
+			t.Errorf("%v.TaxOf(%v) = %v, want %v", tt.value, tt.rate, got, tt.wantOnTop)
+		}
+		if got := tt.value.IncludedTax(rate); got != tt.wantIncluded {
+			t.Errorf("%v.IncludedTax(%v) = %v, want %v", tt.value, tt.rate, got, tt.wantIncluded)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"toyStore/models"
+)
+
+type AddressRepository interface {
+	GetUserAddresses(userId int) (addrs []models.Address_db, err error)
+	GetAddress(addrId int, userId int) (addr models.Address_db, exists bool, err error)
+	CreateAddress(addr models.Address_db) (newAddrId int, err error)
+	UpdateAddress(addr models.Address_db) (err error)
+	DeleteAddress(addrId int, userId int) (err error)
+}
+
+type AddressRepo struct {
+	db *sql.DB
+}
+
+func NewAddressRepository(conn *sql.DB) (AddressRepository, error) {
+	if conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := conn.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return &AddressRepo{
+		db: conn,
+	}, nil
+}
+
+func (a *AddressRepo) GetUserAddresses(userId int) (addrs []models.Address_db, err error) {
+	rows, e := a.db.Query("SELECT Id, UserId, Recipient, Phone, Country, Region, City, Street, PostalCode FROM Addresses WHERE UserId = $1 ORDER BY Id", userId)
+	if e != nil {
+		log.Printf("GetUserAddresses[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	addrs = []models.Address_db{}
+	for rows.Next() {
+		var addr models.Address_db
+		err = rows.Scan(&addr.Id, &addr.UserId, &addr.Recipient, &addr.Phone, &addr.Country, &addr.Region, &addr.City, &addr.Street, &addr.PostalCode)
+		if err != nil {
+			log.Printf("GetUserAddresses[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		addrs = append(addrs, addr)
+	}
+	return
+}
+
+func (a *AddressRepo) GetAddress(addrId int, userId int) (addr models.Address_db, exists bool, err error) {
+	row := a.db.QueryRow("SELECT Id, UserId, Recipient, Phone, Country, Region, City, Street, PostalCode FROM Addresses WHERE Id = $1 AND UserId = $2", addrId, userId)
+	err = row.Scan(&addr.Id, &addr.UserId, &addr.Recipient, &addr.Phone, &addr.Country, &addr.Region, &addr.City, &addr.Street, &addr.PostalCode)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		} else {
+			log.Printf("GetAddress: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	exists = true
+	return
+}
+
+func (a *AddressRepo) CreateAddress(addr models.Address_db) (newAddrId int, err error) {
+	err = a.db.QueryRow("INSERT INTO Addresses (UserId, Recipient, Phone, Country, Region, City, Street, PostalCode) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING Id",
+		addr.UserId, addr.Recipient, addr.Phone, addr.Country, addr.Region, addr.City, addr.Street, addr.PostalCode).Scan(&newAddrId)
+	if err != nil {
+		log.Printf("CreateAddress: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (a *AddressRepo) UpdateAddress(addr models.Address_db) (err error) {
+	res, e := a.db.Exec("UPDATE Addresses SET Recipient = $1, Phone = $2, Country = $3, Region = $4, City = $5, Street = $6, PostalCode = $7 WHERE Id = $8 AND UserId = $9",
+		addr.Recipient, addr.Phone, addr.Country, addr.Region, addr.City, addr.Street, addr.PostalCode, addr.Id, addr.UserId)
+	if e != nil {
+		log.Printf("UpdateAddress: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+func (a *AddressRepo) DeleteAddress(addrId int, userId int) (err error) {
+	res, e := a.db.Exec("DELETE FROM Addresses WHERE Id = $1 AND UserId = $2", addrId, userId)
+	if e != nil {
+		log.Printf("DeleteAddress: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+	"toyStore/models"
+)
+
+type ApiKeyRepository interface {
+	CreateApiKey(key models.ApiKey_db, keyHash string) (newKeyId int, err error)
+	GetApiKeys() (keys []models.ApiKey_db, err error)
+	GetApiKeyByHash(keyHash string) (key models.ApiKey_db, exists bool, err error)
+	TouchApiKey(keyId int) (err error)
+	RevokeApiKey(keyId int) (err error)
+}
+
+type ApiKeyRepo struct {
+	db *sql.DB
+}
+
+func NewApiKeyRepository(conn *sql.DB) (ApiKeyRepository, error) {
+	if conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := conn.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return &ApiKeyRepo{
+		db: conn,
+	}, nil
+}
+
+const apiKeyColumns = "Id, Name, Prefix, CreatedBy, CreatedAt, ExpiresAt, LastUsedAt, RevokedAt"
+
+func scanApiKey(row interface{ Scan(...any) error }, k *models.ApiKey_db) error {
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&k.Id, &k.Name, &k.Prefix, &k.CreatedBy, &k.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt)
+	if expiresAt.Valid {
+		k.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return err
+}
+
+func (a *ApiKeyRepo) CreateApiKey(key models.ApiKey_db, keyHash string) (newKeyId int, err error) {
+	tx, e := a.db.Begin()
+	if e != nil {
+		log.Printf("CreateApiKey[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("INSERT INTO ApiKeys (Name, Prefix, KeyHash, CreatedBy, CreatedAt, ExpiresAt) VALUES ($1, $2, $3, $4, $5, $6) RETURNING Id",
+		key.Name, key.Prefix, keyHash, key.CreatedBy, key.CreatedAt, key.ExpiresAt).Scan(&newKeyId)
+	if err != nil {
+		log.Printf("CreateApiKey[2]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	for _, v := range key.Scopes {
+		_, err = tx.Exec("INSERT INTO ApiKeyScopes (ApiKeyId, Permission) VALUES ($1, $2)", newKeyId, v)
+		if err != nil {
+			log.Printf("CreateApiKey[3]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("CreateApiKey[4]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (a *ApiKeyRepo) GetApiKeys() (keys []models.ApiKey_db, err error) {
+	rows, e := a.db.Query("SELECT " + apiKeyColumns + " FROM ApiKeys ORDER BY Id")
+	if e != nil {
+		log.Printf("GetApiKeys[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	keys = []models.ApiKey_db{}
+	for rows.Next() {
+		var key models.ApiKey_db
+		err = scanApiKey(rows, &key)
+		if err != nil {
+			log.Printf("GetApiKeys[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+
+	for i := range keys {
+		keys[i].Scopes, err = a.getApiKeyScopes(keys[i].Id)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (a *ApiKeyRepo) GetApiKeyByHash(keyHash string) (key models.ApiKey_db, exists bool, err error) {
+	row := a.db.QueryRow("SELECT "+apiKeyColumns+" FROM ApiKeys WHERE KeyHash = $1", keyHash)
+	err = scanApiKey(row, &key)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		} else {
+			log.Printf("GetApiKeyByHash: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	key.Scopes, err = a.getApiKeyScopes(key.Id)
+	if err != nil {
+		return
+	}
+	exists = true
+	return
+}
+
+// TouchApiKey saves the time of the use, at most once a minute so every request does not write
+func (a *ApiKeyRepo) TouchApiKey(keyId int) (err error) {
+	now := time.Now().UTC()
+	_, err = a.db.Exec("UPDATE ApiKeys SET LastUsedAt = $1 WHERE Id = $2 AND (LastUsedAt IS NULL OR LastUsedAt < $3)", now, keyId, now.Add(-time.Minute))
+	if err != nil {
+		log.Printf("TouchApiKey: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (a *ApiKeyRepo) RevokeApiKey(keyId int) (err error) {
+	res, e := a.db.Exec("UPDATE ApiKeys SET RevokedAt = $1 WHERE Id = $2 AND RevokedAt IS NULL", time.Now().UTC(), keyId)
+	if e != nil {
+		log.Printf("RevokeApiKey: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+func (a *ApiKeyRepo) getApiKeyScopes(keyId int) (scopes []string, err error) {
+	rows, e := a.db.Query("SELECT Permission FROM ApiKeyScopes WHERE ApiKeyId = $1 ORDER BY Permission", keyId)
+	if e != nil {
+		log.Printf("getApiKeyScopes[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	scopes = []string{}
+	for rows.Next() {
+		var scope string
+		err = rows.Scan(&scope)
+		if err != nil {
+			log.Printf("getApiKeyScopes[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		scopes = append(scopes, scope)
+	}
+	return
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"log"
-	"time"
 	"toyStore/entities"
 	"toyStore/models"
 
@@ -44,7 +43,7 @@ func (c *CartRepo) SetCart(cartSessionId string, cart entities.Cart) (err error)
 		err = models.ErrServerError
 		return
 	}
-	err = c.rdb.Set(c.ctx, cartSessionId, jsonData, 24*time.Hour).Err()
+	err = c.rdb.Set(c.ctx, cartSessionId, jsonData, models.CartTtl).Err()
 	if err != nil {
 		log.Printf("CreateCartSession: Ошибка сохранения в Redis: %v", err)
 		err = models.ErrServerError
@@ -77,6 +76,10 @@ func (c *CartRepo) AddCartItem(cartSessionId string, req entities.CartRequest) (
 		err = e
 		return
 	}
+	// the cart could expire while its cookie is still alive
+	if cart.Items == nil {
+		cart.Items = make(map[int]int)
+	}
 	cart.Items[req.ProductId] = cart.Items[req.ProductId] + req.Quantity
 	err = c.SetCart(cartSessionId, cart)
 	return
@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"toyStore/models"
+)
+
+type CurrencyRepository interface {
+	GetRates() (rates []models.ExchangeRate_db, err error)
+	GetRate(currency string) (rate models.ExchangeRate_db, exists bool, err error)
+	SetRates(rates []models.ExchangeRate_db) (err error)
+}
+
+type CurrencyRepo struct {
+	db *sql.DB
+}
+
+func NewCurrencyRepository(conn *sql.DB) (CurrencyRepository, error) {
+	if conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := conn.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return &CurrencyRepo{
+		db: conn,
+	}, nil
+}
+
+func (c *CurrencyRepo) GetRates() (rates []models.ExchangeRate_db, err error) {
+	rows, e := c.db.Query("SELECT Currency, Rate, UpdatedAt FROM ExchangeRates ORDER BY Currency")
+	if e != nil {
+		log.Printf("GetRates[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rate models.ExchangeRate_db
+		err = rows.Scan(&rate.Currency, &rate.Rate, &rate.UpdatedAt)
+		if err != nil {
+			log.Printf("GetRates[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		rates = append(rates, rate)
+	}
+	return
+}
+
+func (c *CurrencyRepo) GetRate(currency string) (rate models.ExchangeRate_db, exists bool, err error) {
+	row := c.db.QueryRow("SELECT Currency, Rate, UpdatedAt FROM ExchangeRates WHERE Currency = $1", currency)
+	err = row.Scan(&rate.Currency, &rate.Rate, &rate.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		} else {
+			log.Printf("GetRate: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	exists = true
+	return
+}
+
+// SetRates inserts or updates all the rates in one transaction, so an import is applied entirely or not at all
+func (c *CurrencyRepo) SetRates(rates []models.ExchangeRate_db) (err error) {
+	tx, e := c.db.Begin()
+	if e != nil {
+		log.Printf("SetRates[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+	for _, v := range rates {
+		_, err = tx.Exec("INSERT INTO ExchangeRates (Currency, Rate, UpdatedAt) VALUES ($1, $2, $3) ON CONFLICT (Currency) DO UPDATE SET Rate = EXCLUDED.Rate, UpdatedAt = EXCLUDED.UpdatedAt",
+			v.Currency, v.Rate, v.UpdatedAt)
+		if err != nil {
+			log.Printf("SetRates[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("SetRates[3]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
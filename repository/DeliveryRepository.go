@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"toyStore/models"
+)
+
+type DeliveryRepository interface {
+	GetDeliveryMethods(onlyActive bool) (methods []models.DeliveryMethod_db, err error)
+	GetDeliveryMethod(methodId int) (method models.DeliveryMethod_db, exists bool, err error)
+	CreateDeliveryMethod(method models.DeliveryMethod_db) (newMethodId int, err error)
+	UpdateDeliveryMethod(method models.DeliveryMethod_db) (err error)
+}
+
+type DeliveryRepo struct {
+	db *sql.DB
+}
+
+func NewDeliveryRepository(conn *sql.DB) (DeliveryRepository, error) {
+	if conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := conn.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return &DeliveryRepo{
+		db: conn,
+	}, nil
+}
+
+func (d *DeliveryRepo) GetDeliveryMethods(onlyActive bool) (methods []models.DeliveryMethod_db, err error) {
+	query := "SELECT Id, Name, Type, Price, PricePerKg, FreeThreshold, Active FROM DeliveryMethods "
+	if onlyActive {
+		query = query + "WHERE Active = true "
+	}
+	rows, e := d.db.Query(query + "ORDER BY Id")
+	if e != nil {
+		log.Printf("GetDeliveryMethods[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var method models.DeliveryMethod_db
+		err = rows.Scan(&method.Id, &method.Name, &method.Type, &method.Price, &method.PricePerKg, &method.FreeThreshold, &method.Active)
+		if err != nil {
+			log.Printf("GetDeliveryMethods[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		methods = append(methods, method)
+	}
+	return
+}
+
+func (d *DeliveryRepo) GetDeliveryMethod(methodId int) (method models.DeliveryMethod_db, exists bool, err error) {
+	row := d.db.QueryRow("SELECT Id, Name, Type, Price, PricePerKg, FreeThreshold, Active FROM DeliveryMethods WHERE Id = $1", methodId)
+	err = row.Scan(&method.Id, &method.Name, &method.Type, &method.Price, &method.PricePerKg, &method.FreeThreshold, &method.Active)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		} else {
+			log.Printf("GetDeliveryMethod: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	exists = true
+	return
+}
+
+func (d *DeliveryRepo) CreateDeliveryMethod(method models.DeliveryMethod_db) (newMethodId int, err error) {
+	err = d.db.QueryRow("INSERT INTO DeliveryMethods (Name, Type, Price, PricePerKg, FreeThreshold, Active) VALUES ($1, $2, $3, $4, $5, $6) RETURNING Id",
+		method.Name, method.Type, method.Price, method.PricePerKg, method.FreeThreshold, method.Active).Scan(&newMethodId)
+	if err != nil {
+		log.Printf("CreateDeliveryMethod: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (d *DeliveryRepo) UpdateDeliveryMethod(method models.DeliveryMethod_db) (err error) {
+	res, e := d.db.Exec("UPDATE DeliveryMethods SET Name = $1, Type = $2, Price = $3, PricePerKg = $4, FreeThreshold = $5, Active = $6 WHERE Id = $7",
+		method.Name, method.Type, method.Price, method.PricePerKg, method.FreeThreshold, method.Active, method.Id)
+	if e != nil {
+		log.Printf("UpdateDeliveryMethod: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+	"toyStore/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type IdempotencyRepository interface {
+	Reserve(key string, fingerprint string, ttl time.Duration) (saved models.IdempotentResponse, reserved bool, err error)
+	Save(key string, resp models.IdempotentResponse, ttl time.Duration) (err error)
+	Release(key string) (err error)
+}
+
+type IdempotencyRepo struct {
+	rdb *redis.Client
+	ctx context.Context
+}
+
+func NewIdempotencyRepository(redis_conn *redis.Client, _ctx context.Context) (IdempotencyRepository, error) {
+	if redis_conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := redis_conn.Ping(_ctx).Err()
+	if err != nil {
+		return nil, err
+	}
+	return &IdempotencyRepo{
+		rdb: redis_conn,
+		ctx: _ctx,
+	}, nil
+}
+
+// Reserve marks the key as in progress. If the key is already used, the saved response is returned instead.
+func (i *IdempotencyRepo) Reserve(key string, fingerprint string, ttl time.Duration) (saved models.IdempotentResponse, reserved bool, err error) {
+	jsonData, err := json.Marshal(models.IdempotentResponse{Fingerprint: fingerprint})
+	if err != nil {
+		log.Printf("Reserve[1]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	reserved, err = i.rdb.SetNX(i.ctx, key, jsonData, ttl).Result()
+	if err != nil {
+		log.Printf("Reserve[2]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	if reserved {
+		return
+	}
+	val, e := i.rdb.Get(i.ctx, key).Result()
+	if e != nil {
+		if e == redis.Nil {
+			// expired between the two commands, try once more
+			return i.Reserve(key, fingerprint, ttl)
+		}
+		log.Printf("Reserve[3]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	err = json.Unmarshal([]byte(val), &saved)
+	if err != nil {
+		log.Printf("Reserve[4]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (i *IdempotencyRepo) Save(key string, resp models.IdempotentResponse, ttl time.Duration) (err error) {
+	jsonData, err := json.Marshal(resp)
+	if err != nil {
+		log.Printf("Save[1]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	err = i.rdb.Set(i.ctx, key, jsonData, ttl).Err()
+	if err != nil {
+		log.Printf("Save[2]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (i *IdempotencyRepo) Release(key string) (err error) {
+	err = i.rdb.Del(i.ctx, key).Err()
+	if err != nil {
+		log.Printf("Release: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+	"toyStore/models"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// LockRepository is a distributed lock in Redis, so a job runs on one server instance at a time
+type LockRepository interface {
+	TryLock(name string, ttl time.Duration) (token string, locked bool, err error)
+	Unlock(name string, token string) (err error)
+}
+
+type LockRepo struct {
+	rdb *redis.Client
+	ctx context.Context
+}
+
+func NewLockRepository(redis_conn *redis.Client, _ctx context.Context) (LockRepository, error) {
+	if redis_conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := redis_conn.Ping(_ctx).Err()
+	if err != nil {
+		return nil, err
+	}
+	return &LockRepo{
+		rdb: redis_conn,
+		ctx: _ctx,
+	}, nil
+}
+
+func (l *LockRepo) TryLock(name string, ttl time.Duration) (token string, locked bool, err error) {
+	token = uuid.NewString()
+	locked, err = l.rdb.SetNX(l.ctx, "lock:"+name, token, ttl).Result()
+	if err != nil {
+		log.Printf("TryLock: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// unlockScript deletes the lock only if it is still held by the token, an expired lock may belong to another instance
+var unlockScript = redis.NewScript(`if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`)
+
+func (l *LockRepo) Unlock(name string, token string) (err error) {
+	err = unlockScript.Run(l.ctx, l.rdb, []string{"lock:" + name}, token).Err()
+	if err != nil {
+		log.Printf("Unlock: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
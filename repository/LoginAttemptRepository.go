@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+	"toyStore/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// LoginAttemptRepository counts failed sign-ins and keeps temporary locks in Redis.
+// The scope is models.LoginScopeAccount with a username or models.LoginScopeIp with an address.
+type LoginAttemptRepository interface {
+	AddFailure(scope string, id string, window time.Duration) (failures int, err error)
+	GetLock(scope string, id string) (ttl time.Duration, err error)
+	SetLock(scope string, id string, ttl time.Duration) (err error)
+	Reset(scope string, id string) (err error)
+	GetLockedAccounts() (locks []models.LoginLock_db, err error)
+}
+
+type LoginAttemptRepo struct {
+	rdb *redis.Client
+	ctx context.Context
+}
+
+func NewLoginAttemptRepository(redis_conn *redis.Client, _ctx context.Context) (LoginAttemptRepository, error) {
+	if redis_conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := redis_conn.Ping(_ctx).Err()
+	if err != nil {
+		return nil, err
+	}
+	return &LoginAttemptRepo{
+		rdb: redis_conn,
+		ctx: _ctx,
+	}, nil
+}
+
+// the locked accounts are also kept in a sorted set by the unlock time, so managers can list them
+const lockedAccountsKey = "loginLockedAccounts"
+
+func failuresKey(scope string, id string) string {
+	return "loginFailures:" + scope + ":" + id
+}
+
+func loginLockKey(scope string, id string) string {
+	return "loginLock:" + scope + ":" + id
+}
+
+// AddFailure counts a failed sign-in, the counter expires after the window without failures
+func (l *LoginAttemptRepo) AddFailure(scope string, id string, window time.Duration) (failures int, err error) {
+	pipe := l.rdb.TxPipeline()
+	incr := pipe.Incr(l.ctx, failuresKey(scope, id))
+	pipe.Expire(l.ctx, failuresKey(scope, id), window)
+	_, err = pipe.Exec(l.ctx)
+	if err != nil {
+		log.Printf("AddFailure: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	failures = int(incr.Val())
+	return
+}
+
+// GetLock returns how long the lock lasts, 0 if there is no lock
+func (l *LoginAttemptRepo) GetLock(scope string, id string) (ttl time.Duration, err error) {
+	ttl, err = l.rdb.PTTL(l.ctx, loginLockKey(scope, id)).Result()
+	if err != nil {
+		log.Printf("GetLock: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	// -2 without the key, -1 without the expiry which is never set
+	if ttl < 0 {
+		ttl = 0
+	}
+	return
+}
+
+func (l *LoginAttemptRepo) SetLock(scope string, id string, ttl time.Duration) (err error) {
+	pipe := l.rdb.TxPipeline()
+	pipe.Set(l.ctx, loginLockKey(scope, id), 1, ttl)
+	if scope == models.LoginScopeAccount {
+		pipe.ZAdd(l.ctx, lockedAccountsKey, redis.Z{Score: float64(time.Now().Add(ttl).Unix()), Member: id})
+	}
+	_, err = pipe.Exec(l.ctx)
+	if err != nil {
+		log.Printf("SetLock: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// Reset deletes the counter and the lock, after a successful sign-in or by a manager
+func (l *LoginAttemptRepo) Reset(scope string, id string) (err error) {
+	pipe := l.rdb.TxPipeline()
+	pipe.Del(l.ctx, failuresKey(scope, id), loginLockKey(scope, id))
+	if scope == models.LoginScopeAccount {
+		pipe.ZRem(l.ctx, lockedAccountsKey, id)
+	}
+	_, err = pipe.Exec(l.ctx)
+	if err != nil {
+		log.Printf("Reset: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// GetLockedAccounts returns the accounts locked now, the expired entries are removed from the set
+func (l *LoginAttemptRepo) GetLockedAccounts() (locks []models.LoginLock_db, err error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	err = l.rdb.ZRemRangeByScore(l.ctx, lockedAccountsKey, "-inf", "("+now).Err()
+	if err != nil {
+		log.Printf("GetLockedAccounts[1]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	entries, e := l.rdb.ZRangeWithScores(l.ctx, lockedAccountsKey, 0, -1).Result()
+	if e != nil {
+		log.Printf("GetLockedAccounts[2]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	locks = []models.LoginLock_db{}
+	for _, v := range entries {
+		username, _ := v.Member.(string)
+		lock := models.LoginLock_db{
+			Username:    username,
+			LockedUntil: time.Unix(int64(v.Score), 0).UTC(),
+		}
+		failures, e := l.rdb.Get(l.ctx, failuresKey(models.LoginScopeAccount, username)).Int()
+		if e != nil && e != redis.Nil {
+			log.Printf("GetLockedAccounts[3]: %v", e)
+			err = models.ErrServerError
+			return
+		}
+		lock.Failures = failures
+		locks = append(locks, lock)
+	}
+	return
+}
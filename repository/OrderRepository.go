@@ -2,6 +2,7 @@ package repository
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"log"
 	"strconv"
@@ -18,6 +19,15 @@ type OrderRepository interface {
 	SearchOrders(data models.OrderSearchData) (order []entities.Order, err error)
 	SetOrderStatus(orderId int, status string) (err error)
 	CancelOrder(orderId int, userId int) (err error)
+	AssignInvoiceNumber(orderId int) (number int, date time.Time, err error)
+	CancelStaleOrders(createdBefore time.Time, reason string) (orderIds []int, err error)
+	GetStatusHistory(orderId int) (history []models.OrderStatusHistory_db, err error)
+	GetOrderItem(orderId int, itemId int) (item models.OrdersProducts_db, exists bool, err error)
+	UpdateOrderItems(orderId int, lines []models.OrdersProducts_db, shippingPrice models.Money, changes []models.OrderChange_db) (err error)
+	RemoveOrderItem(orderId int, itemId int, lines []models.OrdersProducts_db, shippingPrice models.Money, change models.OrderChange_db) (err error)
+	GetOrderChanges(orderId int) (changes []models.OrderChange_db, err error)
+	AddOrderNote(note models.OrderNote_db) (newNoteId int, err error)
+	GetOrderNotes(orderId int) (notes []models.OrderNote_db, err error)
 }
 type OrderRepo struct {
 	db *sql.DB
@@ -38,19 +48,32 @@ func NewOrderRepository(conn *sql.DB) (OrderRepository, error) {
 
 func (o *OrderRepo) CreateOrder(order models.Order_db) (orderId int, err error) {
 	var oId int64
-	e := o.db.QueryRow("INSERT INTO Orders (UserId, Date, TotalPrice, Status) VALUES ($1,$2,$3,$4) RETURNING id", order.UserId, order.Date, order.TotalPrice, order.Status).Scan(&oId)
+	// lib/pq sends []byte as bytea, so the JSONB snapshot is passed as a string
+	var address sql.NullString
+	if order.ShippingAddress != nil {
+		data, e := json.Marshal(order.ShippingAddress)
+		if e != nil {
+			log.Printf("CreateOrder: %v", e)
+			err = models.ErrServerError
+			return
+		}
+		address = sql.NullString{String: string(data), Valid: true}
+	}
+	e := o.db.QueryRow("INSERT INTO Orders (UserId, Date, TotalPrice, Discount, Tax, TaxRegion, Currency, ExchangeRate, DeliveryMethodId, DeliveryMethod, ShippingPrice, ShippingAddress, Note, GiftMessage, GiftWrap, GiftWrapPrice, Status) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17) RETURNING id",
+		order.UserId, order.Date, order.TotalPrice, order.Discount, order.Tax, order.TaxRegion, order.Currency, order.ExchangeRate, order.DeliveryMethodId, order.DeliveryMethod, order.ShippingPrice, address, order.Note, order.GiftMessage, order.GiftWrap, order.GiftWrapPrice, order.Status).Scan(&oId)
 	if e != nil {
 		log.Printf("CreateOrder: %v", e)
 		err = models.ErrServerError
 		return
 	}
 	orderId = int(oId)
+	err = addStatusHistory(o.db, orderId, "", order.Status, "order is created")
 	return
 }
 
 func (o *OrderRepo) SetOrderItems(orderId int, prods []models.OrdersProducts_db) (err error) {
 	for _, v := range prods {
-		_, err = o.db.Exec("INSERT INTO OrdersProducts (OrderId, ProductId, Quantity, Price) VALUES ($1, $2, $3, $4)", orderId, v.ProductId, v.Quantity, v.Price)
+		_, err = o.db.Exec("INSERT INTO OrdersProducts (OrderId, ProductId, Quantity, Price, Discount, Tax, TaxIncluded) VALUES ($1, $2, $3, $4, $5, $6, $7)", orderId, v.ProductId, v.Quantity, v.Price, v.Discount, v.Tax, v.TaxIncluded)
 		if err != nil {
 			log.Printf("SetOrderItems: %v", err)
 			err = models.ErrServerError
@@ -61,7 +84,7 @@ func (o *OrderRepo) SetOrderItems(orderId int, prods []models.OrdersProducts_db)
 }
 
 func (o *OrderRepo) GetOrderItems(orderId int) (prods []entities.ProductOrderFormat, err error) {
-	rows, e := o.db.Query("SELECT ProductId, Quantity, Price FROM OrdersProducts WHERE OrderId=$1", orderId)
+	rows, e := o.db.Query("SELECT Id, ProductId, Quantity, Price, Discount, Tax, TaxIncluded FROM OrdersProducts WHERE OrderId=$1 ORDER BY Id", orderId)
 	if e != nil {
 		log.Printf("GetOrderItems[1]: %v", e)
 		err = models.ErrServerError
@@ -70,12 +93,13 @@ func (o *OrderRepo) GetOrderItems(orderId int) (prods []entities.ProductOrderFor
 
 	for rows.Next() {
 		prod := entities.ProductOrderFormat{}
-		err = rows.Scan(&prod.Id, &prod.Price, &prod.TotalPrice)
+		err = rows.Scan(&prod.ItemId, &prod.Id, &prod.Quantity, &prod.Price, &prod.Discount, &prod.Tax, &prod.TaxIncluded)
 		if err != nil {
 			log.Printf("GetOrderItems[2]: %v", err)
 			err = models.ErrServerError
 			return
 		}
+		prod.TotalPrice = lineTotal(prod)
 
 		row := o.db.QueryRow("SELECT Id, Name, Manufacturer FROM Products WHERE Id = $1", prod.Id)
 		err = row.Scan(&prod.Id, &prod.Name, &prod.Manufacturer)
@@ -90,9 +114,10 @@ func (o *OrderRepo) GetOrderItems(orderId int) (prods []entities.ProductOrderFor
 }
 
 func (o *OrderRepo) GetOrderById(orderId int) (order entities.Order, err error) {
-	row := o.db.QueryRow("SELECT Id, UserId, Date, TotalPrice, Status FROM Orders WHERE Id=", orderId)
+	row := o.db.QueryRow("SELECT Id, UserId, Date, TotalPrice, Discount, Tax, TaxRegion, Currency, ExchangeRate, DeliveryMethodId, DeliveryMethod, ShippingPrice, ShippingAddress, RefundedAmount, Note, GiftMessage, GiftWrap, GiftWrapPrice, Status FROM Orders WHERE Id=$1", orderId)
 	var or models.Order_db
-	err = row.Scan(&or)
+	var address []byte
+	err = row.Scan(&or.Id, &or.UserId, &or.Date, &or.TotalPrice, &or.Discount, &or.Tax, &or.TaxRegion, &or.Currency, &or.ExchangeRate, &or.DeliveryMethodId, &or.DeliveryMethod, &or.ShippingPrice, &address, &or.RefundedAmount, &or.Note, &or.GiftMessage, &or.GiftWrap, &or.GiftWrapPrice, &or.Status)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			err = models.ErrNotFoundError
@@ -102,10 +127,14 @@ func (o *OrderRepo) GetOrderById(orderId int) (order entities.Order, err error)
 		}
 		return
 	}
+	or.ShippingAddress, err = parseAddress(address)
+	if err != nil {
+		return
+	}
 
-	row = o.db.QueryRow("SELECT Id, Nickname, Role FROM Users WHERE Id=", or.UserId)
+	row = o.db.QueryRow("SELECT Id, Nickname, Role FROM Users WHERE Id=$1", or.UserId)
 	var usr models.UserData
-	err = row.Scan(&usr)
+	err = row.Scan(&usr.Id, &usr.Nickname, &usr.Role)
 	if err != nil {
 		log.Printf("GetOrderById: %v", err)
 		err = models.ErrServerError
@@ -117,14 +146,35 @@ func (o *OrderRepo) GetOrderById(orderId int) (order entities.Order, err error)
 		err = e
 		return
 	}
+	shipments, e := getOrderShipments(o.db, orderId)
+	if e != nil {
+		err = e
+		return
+	}
 
 	order = entities.Order{
-		OrderId:    orderId,
-		Date:       or.Date,
-		Status:     or.Status,
-		TotalPrice: or.TotalPrice,
-		UserData:   usr,
-		Products:   prods,
+		OrderId:            orderId,
+		Date:               or.Date,
+		Status:             or.Status,
+		TotalPrice:         or.TotalPrice,
+		Discount:           or.Discount,
+		Tax:                or.Tax,
+		TaxRegion:          or.TaxRegion,
+		Currency:           or.Currency,
+		ExchangeRate:       or.ExchangeRate,
+		CurrencyTotalPrice: or.TotalPrice.Convert(or.ExchangeRate),
+		DeliveryMethodId:   int(or.DeliveryMethodId.Int64),
+		DeliveryMethod:     or.DeliveryMethod,
+		ShippingPrice:      or.ShippingPrice,
+		ShippingAddress:    or.ShippingAddress,
+		RefundedAmount:     or.RefundedAmount,
+		Note:               or.Note,
+		GiftMessage:        or.GiftMessage,
+		GiftWrap:           or.GiftWrap,
+		GiftWrapPrice:      or.GiftWrapPrice,
+		UserData:           usr,
+		Products:           prods,
+		Shipments:          shipments,
 	}
 	return
 }
@@ -142,7 +192,7 @@ func (o *OrderRepo) SetOrderStatus(orderId int, status string) (err error) {
 		}
 		return
 	}
-	if or.Status != "created" {
+	if or.Status != "created" && or.Status != "paid" {
 		log.Printf("you can not set status to this order. Current status is %v", or.Status)
 		err = models.ErrNotAllowed
 		return
@@ -188,6 +238,7 @@ func (o *OrderRepo) SetOrderStatus(orderId int, status string) (err error) {
 		err = models.ErrServerError
 		return
 	}
+	err = addStatusHistory(o.db, orderId, or.Status, status, "set by a manager")
 	return
 }
 
@@ -196,7 +247,7 @@ func (o *OrderRepo) SearchOrders(data models.OrderSearchData) (orders []entities
 	var queryParams []any
 	var count int
 
-	query = "SELECT Orders.Id, Orders.UserId, Orders.Date, Orders.TotalPrice, Orders.Status FROM Orders WHERE "
+	query = "SELECT Orders.Id, Orders.UserId, Orders.Date, Orders.TotalPrice, Orders.Discount, Orders.Tax, Orders.TaxRegion, Orders.Currency, Orders.ExchangeRate, Orders.DeliveryMethod, Orders.ShippingPrice, Orders.ShippingAddress, Orders.RefundedAmount, Orders.Note, Orders.GiftMessage, Orders.GiftWrap, Orders.GiftWrapPrice, Orders.Status FROM Orders WHERE "
 
 	if data.ProdId != nil {
 		query = query[0 : len(query)-6]
@@ -242,12 +293,18 @@ func (o *OrderRepo) SearchOrders(data models.OrderSearchData) (orders []entities
 
 	for rows.Next() {
 		ord := entities.Order{}
-		err = rows.Scan(&ord.OrderId, &ord.UserData.Id, &ord.Date, &ord.TotalPrice, &ord.Status)
+		var address []byte
+		err = rows.Scan(&ord.OrderId, &ord.UserData.Id, &ord.Date, &ord.TotalPrice, &ord.Discount, &ord.Tax, &ord.TaxRegion, &ord.Currency, &ord.ExchangeRate, &ord.DeliveryMethod, &ord.ShippingPrice, &address, &ord.RefundedAmount, &ord.Note, &ord.GiftMessage, &ord.GiftWrap, &ord.GiftWrapPrice, &ord.Status)
 		if err != nil {
 			log.Printf("SearchOrders: %v", err)
 			err = models.ErrServerError
 			return
 		}
+		ord.ShippingAddress, err = parseAddress(address)
+		if err != nil {
+			return
+		}
+		ord.CurrencyTotalPrice = ord.TotalPrice.Convert(ord.ExchangeRate)
 
 		rowUser := o.db.QueryRow("SELECT Nickname, Role FROM Users where Id = $1", ord.UserData.Id)
 		e2 := rowUser.Scan(&ord.UserData.Nickname, &ord.UserData.Role)
@@ -257,7 +314,7 @@ func (o *OrderRepo) SearchOrders(data models.OrderSearchData) (orders []entities
 			return
 		}
 
-		rowsProds, e3 := o.db.Query("SELECT OrdersProducts.ProductId, OrdersProducts.Quantity, OrdersProducts.Price, Products.Name, Products.Manufacturer FROM OrdersProducts JOIN Products ON OrdersProducts.ProductId=Products.Id WHERE OrdersProducts.OrderId = $1", ord.OrderId)
+		rowsProds, e3 := o.db.Query("SELECT OrdersProducts.Id, OrdersProducts.ProductId, OrdersProducts.Quantity, OrdersProducts.Price, OrdersProducts.Discount, OrdersProducts.Tax, OrdersProducts.TaxIncluded, Products.Name, Products.Manufacturer FROM OrdersProducts JOIN Products ON OrdersProducts.ProductId=Products.Id WHERE OrdersProducts.OrderId = $1 ORDER BY OrdersProducts.Id", ord.OrderId)
 		if e3 != nil {
 			log.Printf("SearchOrders: %v", e3)
 			err = models.ErrServerError
@@ -265,15 +322,19 @@ func (o *OrderRepo) SearchOrders(data models.OrderSearchData) (orders []entities
 		}
 		for rowsProds.Next() {
 			var prod entities.ProductOrderFormat
-			e3 = rowsProds.Scan(&prod.Id, &prod.Quantity, &prod.Price, &prod.Name, &prod.Manufacturer)
+			e3 = rowsProds.Scan(&prod.ItemId, &prod.Id, &prod.Quantity, &prod.Price, &prod.Discount, &prod.Tax, &prod.TaxIncluded, &prod.Name, &prod.Manufacturer)
 			if e3 != nil {
 				log.Printf("SearchOrders: %v", e3)
 				err = models.ErrServerError
 				return
 			}
-			prod.TotalPrice = prod.Price * float64(prod.Quantity)
+			prod.TotalPrice = lineTotal(prod)
 			ord.Products = append(ord.Products, prod)
 		}
+		ord.Shipments, err = getOrderShipments(o.db, ord.OrderId)
+		if err != nil {
+			return
+		}
 		orders = append(orders, ord)
 	}
 
@@ -297,6 +358,11 @@ func (o *OrderRepo) CancelOrder(orderId int, userId int) (err error) {
 		}
 		return
 	}
+	if or.Status != "created" {
+		log.Printf("you can not cancel this order. Current status is %v", or.Status)
+		err = models.ErrNotAllowed
+		return
+	}
 	if time.Duration(time.Since(or.Date.UTC()).Minutes()) > time.Duration(10*time.Minute) {
 		log.Printf("you can not cancel this order")
 		log.Printf("\n %v - %v", time.Duration(time.Since(or.Date.UTC()).Minutes()), time.Since(or.Date.UTC()).Minutes())
@@ -310,5 +376,367 @@ func (o *OrderRepo) CancelOrder(orderId int, userId int) (err error) {
 		err = models.ErrServerError
 		return
 	}
+	err = addStatusHistory(o.db, orderId, or.Status, "cancelled", "cancelled by the customer")
+	return
+}
+
+// AssignInvoiceNumber gives the order the next number of the invoice sequence on the first call,
+// later calls return the same number and date
+func (o *OrderRepo) AssignInvoiceNumber(orderId int) (number int, date time.Time, err error) {
+	_, err = o.db.Exec("UPDATE Orders SET InvoiceNumber = nextval('invoice_numbers'), InvoiceDate = $1 WHERE Id = $2 AND InvoiceNumber IS NULL", time.Now().UTC(), orderId)
+	if err != nil {
+		log.Printf("AssignInvoiceNumber[1]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	err = o.db.QueryRow("SELECT InvoiceNumber, InvoiceDate FROM Orders WHERE Id = $1", orderId).Scan(&number, &date)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = models.ErrNotFoundError
+		} else {
+			log.Printf("AssignInvoiceNumber[2]: %v", err)
+			err = models.ErrServerError
+		}
+	}
+	return
+}
+
+// CancelStaleOrders cancels the orders left in created status since before the time. Created orders
+// hold no stock, it is taken only on confirmation, so there is nothing to put back. An order with a payment
+// started after that time is being paid and is kept; older pending payments are cancelled with the order,
+// a success that still comes for them is unmatched and refunded.
+func (o *OrderRepo) CancelStaleOrders(createdBefore time.Time, reason string) (orderIds []int, err error) {
+	tx, e := o.db.Begin()
+	if e != nil {
+		log.Printf("CancelStaleOrders[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	rows, e := tx.Query(`UPDATE Orders SET Status = 'cancelled' WHERE Status = 'created' AND Date < $1
+		AND NOT EXISTS (SELECT 1 FROM Payments WHERE Payments.OrderId = Orders.Id AND Payments.Status = $2 AND Payments.CreatedAt >= $1) RETURNING Id`,
+		createdBefore, models.PaymentPending)
+	if e != nil {
+		log.Printf("CancelStaleOrders[2]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			log.Printf("CancelStaleOrders[3]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		orderIds = append(orderIds, id)
+	}
+	rows.Close()
+	for _, id := range orderIds {
+		err = addStatusHistory(tx, id, "created", "cancelled", reason)
+		if err != nil {
+			return
+		}
+		_, err = tx.Exec("UPDATE Payments SET Status = $1, UpdatedAt = $2 WHERE OrderId = $3 AND Status = $4", models.PaymentCancelled, time.Now().UTC(), id, models.PaymentPending)
+		if err != nil {
+			log.Printf("CancelStaleOrders[4]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("CancelStaleOrders[5]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (o *OrderRepo) GetStatusHistory(orderId int) (history []models.OrderStatusHistory_db, err error) {
+	rows, e := o.db.Query("SELECT Id, OrderId, OldStatus, NewStatus, Reason, ChangedAt FROM OrderStatusHistory WHERE OrderId = $1 ORDER BY Id", orderId)
+	if e != nil {
+		log.Printf("GetStatusHistory[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	history = []models.OrderStatusHistory_db{}
+	for rows.Next() {
+		var h models.OrderStatusHistory_db
+		err = rows.Scan(&h.Id, &h.OrderId, &h.OldStatus, &h.NewStatus, &h.Reason, &h.ChangedAt)
+		if err != nil {
+			log.Printf("GetStatusHistory[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		history = append(history, h)
+	}
+	return
+}
+
+func (o *OrderRepo) GetOrderItem(orderId int, itemId int) (item models.OrdersProducts_db, exists bool, err error) {
+	row := o.db.QueryRow("SELECT Id, OrderId, ProductId, Quantity, Price, Discount, Tax, TaxIncluded FROM OrdersProducts WHERE Id = $1 AND OrderId = $2", itemId, orderId)
+	err = row.Scan(&item.Id, &item.OrderId, &item.ProductId, &item.Quantity, &item.Price, &item.Discount, &item.Tax, &item.TaxIncluded)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		} else {
+			log.Printf("GetOrderItem: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	exists = true
+	return
+}
+
+// UpdateOrderItems saves the repriced lines of a created order with the record of the changes,
+// the new shipping price and the totals. Pending payments of the old total are cancelled.
+func (o *OrderRepo) UpdateOrderItems(orderId int, lines []models.OrdersProducts_db, shippingPrice models.Money, changes []models.OrderChange_db) (err error) {
+	tx, e := o.db.Begin()
+	if e != nil {
+		log.Printf("UpdateOrderItems[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	err = lockCreatedOrder(tx, orderId)
+	if err != nil {
+		return
+	}
+	for _, v := range changes {
+		err = addOrderChange(tx, v)
+		if err != nil {
+			return
+		}
+	}
+	err = repriceOrder(tx, orderId, lines, shippingPrice)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("UpdateOrderItems[2]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// RemoveOrderItem removes a line of a created order and saves the repriced rest of it, the last line can not be removed
+func (o *OrderRepo) RemoveOrderItem(orderId int, itemId int, lines []models.OrdersProducts_db, shippingPrice models.Money, change models.OrderChange_db) (err error) {
+	tx, e := o.db.Begin()
+	if e != nil {
+		log.Printf("RemoveOrderItem[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	err = lockCreatedOrder(tx, orderId)
+	if err != nil {
+		return
+	}
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM OrdersProducts WHERE OrderId = $1", orderId).Scan(&count)
+	if err != nil {
+		log.Printf("RemoveOrderItem[2]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	if count <= 1 {
+		log.Printf("the last line of the order can not be removed, reject the order instead")
+		err = models.ErrNotAllowed
+		return
+	}
+	res, e := tx.Exec("DELETE FROM OrdersProducts WHERE Id = $1 AND OrderId = $2", itemId, orderId)
+	if e != nil {
+		log.Printf("RemoveOrderItem[3]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+		return
+	}
+	err = addOrderChange(tx, change)
+	if err != nil {
+		return
+	}
+	err = repriceOrder(tx, orderId, lines, shippingPrice)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("RemoveOrderItem[4]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (o *OrderRepo) GetOrderChanges(orderId int) (changes []models.OrderChange_db, err error) {
+	rows, e := o.db.Query("SELECT Id, OrderId, ItemId, Action, OldValue, NewValue, Reason, ChangedBy, ChangedAt FROM OrderChanges WHERE OrderId = $1 ORDER BY Id", orderId)
+	if e != nil {
+		log.Printf("GetOrderChanges[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	changes = []models.OrderChange_db{}
+	for rows.Next() {
+		var c models.OrderChange_db
+		err = rows.Scan(&c.Id, &c.OrderId, &c.ItemId, &c.Action, &c.OldValue, &c.NewValue, &c.Reason, &c.ChangedBy, &c.ChangedAt)
+		if err != nil {
+			log.Printf("GetOrderChanges[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		changes = append(changes, c)
+	}
+	return
+}
+
+func (o *OrderRepo) AddOrderNote(note models.OrderNote_db) (newNoteId int, err error) {
+	err = o.db.QueryRow("INSERT INTO OrderNotes (OrderId, AuthorId, Text, CreatedAt) VALUES ($1, $2, $3, $4) RETURNING Id",
+		note.OrderId, note.AuthorId, note.Text, note.CreatedAt).Scan(&newNoteId)
+	if err != nil {
+		log.Printf("AddOrderNote: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (o *OrderRepo) GetOrderNotes(orderId int) (notes []models.OrderNote_db, err error) {
+	rows, e := o.db.Query("SELECT Id, OrderId, AuthorId, Text, CreatedAt FROM OrderNotes WHERE OrderId = $1 ORDER BY Id", orderId)
+	if e != nil {
+		log.Printf("GetOrderNotes[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	notes = []models.OrderNote_db{}
+	for rows.Next() {
+		var n models.OrderNote_db
+		err = rows.Scan(&n.Id, &n.OrderId, &n.AuthorId, &n.Text, &n.CreatedAt)
+		if err != nil {
+			log.Printf("GetOrderNotes[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		notes = append(notes, n)
+	}
+	return
+}
+
+// lockCreatedOrder locks the order row for the transaction, only created orders can be edited
+func lockCreatedOrder(tx *sql.Tx, orderId int) (err error) {
+	var status string
+	err = tx.QueryRow("SELECT Status FROM Orders WHERE Id = $1 FOR UPDATE", orderId).Scan(&status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = models.ErrNotFoundError
+		} else {
+			log.Printf("lockCreatedOrder: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	if status != "created" {
+		log.Printf("order can not be edited. Current status is %v", status)
+		err = models.ErrNotAllowed
+	}
+	return
+}
+
+// repriceOrder saves the lines with their new discounts and taxes and the shipping price, then recalculates
+// the totals. A pending payment was started for the old total, it is cancelled, the customer pays again.
+func repriceOrder(tx *sql.Tx, orderId int, lines []models.OrdersProducts_db, shippingPrice models.Money) (err error) {
+	for _, v := range lines {
+		_, err = tx.Exec("UPDATE OrdersProducts SET ProductId = $1, Quantity = $2, Price = $3, Discount = $4, Tax = $5, TaxIncluded = $6 WHERE Id = $7 AND OrderId = $8",
+			v.ProductId, v.Quantity, v.Price, v.Discount, v.Tax, v.TaxIncluded, v.Id, orderId)
+		if err != nil {
+			log.Printf("repriceOrder[1]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+	}
+	_, err = tx.Exec("UPDATE Orders SET ShippingPrice = $1 WHERE Id = $2", shippingPrice, orderId)
+	if err != nil {
+		log.Printf("repriceOrder[2]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	err = recalculateOrder(tx, orderId)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec("UPDATE Payments SET Status = $1, UpdatedAt = $2 WHERE OrderId = $3 AND Status = $4", models.PaymentCancelled, time.Now().UTC(), orderId, models.PaymentPending)
+	if err != nil {
+		log.Printf("repriceOrder[3]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// recalculateOrder sums the discount, tax and total of the order from its lines, the shipping and gift-wrap prices are kept
+func recalculateOrder(db execer, orderId int) (err error) {
+	_, err = db.Exec(`UPDATE Orders SET Discount = s.Discount, Tax = s.Tax, TotalPrice = s.Total + Orders.ShippingPrice + Orders.GiftWrapPrice FROM (
+		SELECT COALESCE(SUM(Discount), 0) AS Discount, COALESCE(SUM(Tax), 0) AS Tax,
+			COALESCE(SUM(Price * Quantity - Discount + CASE WHEN TaxIncluded THEN 0 ELSE Tax END), 0) AS Total
+		FROM OrdersProducts WHERE OrderId = $1) s WHERE Orders.Id = $1`, orderId)
+	if err != nil {
+		log.Printf("recalculateOrder: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func addOrderChange(db execer, change models.OrderChange_db) (err error) {
+	_, err = db.Exec("INSERT INTO OrderChanges (OrderId, ItemId, Action, OldValue, NewValue, Reason, ChangedBy, ChangedAt) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		change.OrderId, change.ItemId, change.Action, change.OldValue, change.NewValue, change.Reason, change.ChangedBy, change.ChangedAt)
+	if err != nil {
+		log.Printf("addOrderChange: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// addStatusHistory records a status change of the order, db is the connection or the transaction of the change
+func addStatusHistory(db execer, orderId int, oldStatus string, newStatus string, reason string) (err error) {
+	_, err = db.Exec("INSERT INTO OrderStatusHistory (OrderId, OldStatus, NewStatus, Reason, ChangedAt) VALUES ($1, $2, $3, $4, $5)",
+		orderId, oldStatus, newStatus, reason, time.Now().UTC())
+	if err != nil {
+		log.Printf("addStatusHistory: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func lineTotal(prod entities.ProductOrderFormat) models.Money {
+	total := prod.Price.Mul(prod.Quantity) - prod.Discount
+	if !prod.TaxIncluded {
+		total = total + prod.Tax
+	}
+	return total
+}
+
+// parseAddress reads the address snapshot stored with the order, pickup orders have none
+func parseAddress(data []byte) (addr *models.Address_db, err error) {
+	if len(data) == 0 {
+		return
+	}
+	addr = &models.Address_db{}
+	err = json.Unmarshal(data, addr)
+	if err != nil {
+		log.Printf("parseAddress: %v", err)
+		err = models.ErrServerError
+	}
 	return
 }
@@ -0,0 +1,411 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+	"toyStore/models"
+)
+
+type PaymentRepository interface {
+	CreatePayment(payment models.Payment_db) (newPaymentId int, err error)
+	GetPendingPayment(orderId int, provider string) (payment models.Payment_db, exists bool, err error)
+	GetOrderPayments(orderId int) (payments []models.Payment_db, err error)
+	ApplyPaymentEvent(provider string, event models.PaymentEvent) (payment models.Payment_db, applied bool, err error)
+	SetPaymentStatus(paymentId int, from string, status string) (err error)
+	GetSucceededPayment(orderId int) (payment models.Payment_db, exists bool, err error)
+	CreateRefund(refund models.Refund_db) (newRefundId int, err error)
+	CompleteRefund(refundId int, providerRefundId string) (err error)
+	FailRefund(refundId int) (err error)
+	GetOrderRefunds(orderId int) (refunds []models.Refund_db, err error)
+}
+
+type PaymentRepo struct {
+	db *sql.DB
+}
+
+func NewPaymentRepository(conn *sql.DB) (PaymentRepository, error) {
+	if conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := conn.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return &PaymentRepo{
+		db: conn,
+	}, nil
+}
+
+const paymentColumns = "Id, OrderId, Provider, ProviderPaymentId, Amount, Currency, Status, ConfirmationUrl, CreatedAt, UpdatedAt"
+
+func scanPayment(row interface{ Scan(...any) error }, p *models.Payment_db) error {
+	return row.Scan(&p.Id, &p.OrderId, &p.Provider, &p.ProviderPaymentId, &p.Amount, &p.Currency, &p.Status, &p.ConfirmationUrl, &p.CreatedAt, &p.UpdatedAt)
+}
+
+func (p *PaymentRepo) CreatePayment(payment models.Payment_db) (newPaymentId int, err error) {
+	err = p.db.QueryRow("INSERT INTO Payments (OrderId, Provider, ProviderPaymentId, Amount, Currency, Status, ConfirmationUrl, CreatedAt, UpdatedAt) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING Id",
+		payment.OrderId, payment.Provider, payment.ProviderPaymentId, payment.Amount, payment.Currency, payment.Status, payment.ConfirmationUrl, payment.CreatedAt, payment.UpdatedAt).Scan(&newPaymentId)
+	if err != nil {
+		log.Printf("CreatePayment: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (p *PaymentRepo) GetPendingPayment(orderId int, provider string) (payment models.Payment_db, exists bool, err error) {
+	row := p.db.QueryRow("SELECT "+paymentColumns+" FROM Payments WHERE OrderId = $1 AND Provider = $2 AND Status = $3 ORDER BY Id DESC LIMIT 1", orderId, provider, models.PaymentPending)
+	err = scanPayment(row, &payment)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		} else {
+			log.Printf("GetPendingPayment: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	exists = true
+	return
+}
+
+func (p *PaymentRepo) GetOrderPayments(orderId int) (payments []models.Payment_db, err error) {
+	rows, e := p.db.Query("SELECT "+paymentColumns+" FROM Payments WHERE OrderId = $1 ORDER BY Id", orderId)
+	if e != nil {
+		log.Printf("GetOrderPayments[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	payments = []models.Payment_db{}
+	for rows.Next() {
+		var payment models.Payment_db
+		err = scanPayment(rows, &payment)
+		if err != nil {
+			log.Printf("GetOrderPayments[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		payments = append(payments, payment)
+	}
+	return
+}
+
+// ApplyPaymentEvent records the webhook event and updates the payment and its order in one transaction.
+// An event that was already recorded is not applied again, applied is false for such duplicates.
+// The payment is returned with the status after the event.
+func (p *PaymentRepo) ApplyPaymentEvent(provider string, event models.PaymentEvent) (payment models.Payment_db, applied bool, err error) {
+	tx, e := p.db.Begin()
+	if e != nil {
+		log.Printf("ApplyPaymentEvent[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	res, e := tx.Exec("INSERT INTO PaymentEvents (Provider, EventId, ProviderPaymentId, Status, ReceivedAt) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (Provider, EventId) DO NOTHING",
+		provider, event.EventId, event.ProviderPaymentId, event.Status, now)
+	if e != nil {
+		log.Printf("ApplyPaymentEvent[2]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		log.Printf("ApplyPaymentEvent: duplicate event %v", event.EventId)
+		return
+	}
+
+	err = scanPayment(tx.QueryRow("SELECT "+paymentColumns+" FROM Payments WHERE Provider = $1 AND ProviderPaymentId = $2 FOR UPDATE", provider, event.ProviderPaymentId), &payment)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Printf("ApplyPaymentEvent: unknown payment %v", event.ProviderPaymentId)
+			err = models.ErrNotFoundError
+		} else {
+			log.Printf("ApplyPaymentEvent[3]: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	// a success has to confirm the whole amount, other events are checked when they carry it
+	if event.Status == models.PaymentSucceeded && event.Amount == 0 {
+		log.Printf("ApplyPaymentEvent: success event %v has no amount", event.EventId)
+		err = models.ErrBadRequest
+		return
+	}
+	if event.Amount != 0 && event.Amount != payment.Amount {
+		log.Printf("ApplyPaymentEvent: amount %v does not match payment %v", event.Amount, payment.Amount)
+		err = models.ErrBadRequest
+		return
+	}
+
+	// a final status is never changed by a later event, the event is only recorded. A cancelled payment
+	// still records a success: the provider took the money, it is marked unmatched to be refunded.
+	if payment.Status == models.PaymentPending || (payment.Status == models.PaymentCancelled && event.Status == models.PaymentSucceeded) {
+		status := event.Status
+		if event.Status == models.PaymentSucceeded {
+			status, err = payOrder(tx, payment)
+			if err != nil {
+				return
+			}
+		}
+		_, err = tx.Exec("UPDATE Payments SET Status = $1, UpdatedAt = $2 WHERE Id = $3", status, now, payment.Id)
+		if err != nil {
+			log.Printf("ApplyPaymentEvent[4]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		payment.Status = status
+		payment.UpdatedAt = now
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ApplyPaymentEvent[6]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	applied = true
+	return
+}
+
+// SetPaymentStatus changes the status only from the given one, a payment changed concurrently is not allowed
+func (p *PaymentRepo) SetPaymentStatus(paymentId int, from string, status string) (err error) {
+	res, e := p.db.Exec("UPDATE Payments SET Status = $1, UpdatedAt = $2 WHERE Id = $3 AND Status = $4", status, time.Now().UTC(), paymentId, from)
+	if e != nil {
+		log.Printf("SetPaymentStatus: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		log.Printf("payment %v is not %v", paymentId, from)
+		err = models.ErrNotAllowed
+	}
+	return
+}
+
+// payOrder marks the created order paid when the succeeded payment covers its current total in the order currency.
+// Otherwise the order was edited or cancelled after the payment was started and the payment is unmatched.
+func payOrder(tx *sql.Tx, payment models.Payment_db) (status string, err error) {
+	var orderStatus string
+	var total models.Money
+	var rate models.Rate
+	err = tx.QueryRow("SELECT Status, TotalPrice, ExchangeRate FROM Orders WHERE Id = $1 FOR UPDATE", payment.OrderId).Scan(&orderStatus, &total, &rate)
+	if err != nil {
+		log.Printf("payOrder[1]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	if payment.Status != models.PaymentPending || orderStatus != "created" || payment.Amount != total.Convert(rate) {
+		log.Printf("payment %v of %v does not pay the order %v: order is %v, total %v", payment.Id, payment.Amount, payment.OrderId, orderStatus, total.Convert(rate))
+		status = models.PaymentUnmatched
+		return
+	}
+	_, err = tx.Exec("UPDATE Orders SET Status = 'paid' WHERE Id = $1", payment.OrderId)
+	if err != nil {
+		log.Printf("payOrder[2]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	err = addStatusHistory(tx, payment.OrderId, "created", "paid", "payment "+payment.ProviderPaymentId+" succeeded")
+	if err != nil {
+		return
+	}
+	status = models.PaymentSucceeded
+	return
+}
+
+func (p *PaymentRepo) GetSucceededPayment(orderId int) (payment models.Payment_db, exists bool, err error) {
+	row := p.db.QueryRow("SELECT "+paymentColumns+" FROM Payments WHERE OrderId = $1 AND Status = $2 ORDER BY Id DESC LIMIT 1", orderId, models.PaymentSucceeded)
+	err = scanPayment(row, &payment)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		} else {
+			log.Printf("GetSucceededPayment: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	exists = true
+	return
+}
+
+// CreateRefund claims a pending refund before the provider is called. The refunded amount of the order
+// grows by the refund, the status of the order is left to the fulfilment. A received return becomes refunding,
+// so a concurrent refund of the same return or of the same rest of the order is rejected here.
+func (p *PaymentRepo) CreateRefund(refund models.Refund_db) (newRefundId int, err error) {
+	tx, e := p.db.Begin()
+	if e != nil {
+		log.Printf("CreateRefund[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	var total, refunded models.Money
+	err = tx.QueryRow("SELECT TotalPrice, RefundedAmount FROM Orders WHERE Id = $1 FOR UPDATE", refund.OrderId).Scan(&total, &refunded)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = models.ErrNotFoundError
+		} else {
+			log.Printf("CreateRefund[2]: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	refunded = refunded + refund.Amount
+	if refunded > total {
+		log.Printf("CreateRefund: refunds exceed the order total")
+		err = models.ErrNotAllowed
+		return
+	}
+
+	if refund.ReturnId != nil {
+		res, e := tx.Exec("UPDATE Returns SET Status = $1, UpdatedAt = $2 WHERE Id = $3 AND Status = $4", models.ReturnRefunding, refund.CreatedAt, *refund.ReturnId, models.ReturnReceived)
+		if e != nil {
+			log.Printf("CreateRefund[3]: %v", e)
+			err = models.ErrServerError
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			log.Printf("return %v is not received", *refund.ReturnId)
+			err = models.ErrNotAllowed
+			return
+		}
+	}
+	err = tx.QueryRow("INSERT INTO Refunds (OrderId, PaymentId, ReturnId, Amount, CurrencyAmount, Currency, Status, CreatedAt) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING Id",
+		refund.OrderId, refund.PaymentId, refund.ReturnId, refund.Amount, refund.CurrencyAmount, refund.Currency, models.RefundPending, refund.CreatedAt).Scan(&newRefundId)
+	if err != nil {
+		log.Printf("CreateRefund[4]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	_, err = tx.Exec("UPDATE Orders SET RefundedAmount = $1 WHERE Id = $2", refunded, refund.OrderId)
+	if err != nil {
+		log.Printf("CreateRefund[5]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("CreateRefund[6]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// CompleteRefund records the refund made by the provider, the return of it becomes refunded
+func (p *PaymentRepo) CompleteRefund(refundId int, providerRefundId string) (err error) {
+	tx, e := p.db.Begin()
+	if e != nil {
+		log.Printf("CompleteRefund[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	var returnId sql.NullInt64
+	err = tx.QueryRow("UPDATE Refunds SET Status = $1, ProviderRefundId = $2 WHERE Id = $3 AND Status = $4 RETURNING ReturnId",
+		models.RefundSucceeded, providerRefundId, refundId, models.RefundPending).Scan(&returnId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Printf("refund %v is not pending", refundId)
+			err = models.ErrNotAllowed
+		} else {
+			log.Printf("CompleteRefund[2]: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	if returnId.Valid {
+		_, err = tx.Exec("UPDATE Returns SET Status = $1, UpdatedAt = $2 WHERE Id = $3 AND Status = $4", models.ReturnRefunded, time.Now().UTC(), returnId.Int64, models.ReturnRefunding)
+		if err != nil {
+			log.Printf("CompleteRefund[3]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("CompleteRefund[4]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// FailRefund releases a refund the provider did not make: the amount is taken off the order
+// and the return is received again, so the refund can be repeated
+func (p *PaymentRepo) FailRefund(refundId int) (err error) {
+	tx, e := p.db.Begin()
+	if e != nil {
+		log.Printf("FailRefund[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	var orderId int
+	var returnId sql.NullInt64
+	var amount models.Money
+	err = tx.QueryRow("UPDATE Refunds SET Status = $1 WHERE Id = $2 AND Status = $3 RETURNING OrderId, ReturnId, Amount",
+		models.RefundFailed, refundId, models.RefundPending).Scan(&orderId, &returnId, &amount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Printf("refund %v is not pending", refundId)
+			err = models.ErrNotAllowed
+		} else {
+			log.Printf("FailRefund[2]: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	_, err = tx.Exec("UPDATE Orders SET RefundedAmount = RefundedAmount - $1 WHERE Id = $2", amount, orderId)
+	if err != nil {
+		log.Printf("FailRefund[3]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	if returnId.Valid {
+		_, err = tx.Exec("UPDATE Returns SET Status = $1, UpdatedAt = $2 WHERE Id = $3 AND Status = $4", models.ReturnReceived, time.Now().UTC(), returnId.Int64, models.ReturnRefunding)
+		if err != nil {
+			log.Printf("FailRefund[4]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("FailRefund[5]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (p *PaymentRepo) GetOrderRefunds(orderId int) (refunds []models.Refund_db, err error) {
+	rows, e := p.db.Query("SELECT Id, OrderId, PaymentId, ReturnId, Amount, CurrencyAmount, Currency, ProviderRefundId, Status, CreatedAt FROM Refunds WHERE OrderId = $1 ORDER BY Id", orderId)
+	if e != nil {
+		log.Printf("GetOrderRefunds[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	refunds = []models.Refund_db{}
+	for rows.Next() {
+		var refund models.Refund_db
+		var returnId sql.NullInt64
+		err = rows.Scan(&refund.Id, &refund.OrderId, &refund.PaymentId, &returnId, &refund.Amount, &refund.CurrencyAmount, &refund.Currency, &refund.ProviderRefundId, &refund.Status, &refund.CreatedAt)
+		if err != nil {
+			log.Printf("GetOrderRefunds[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		if returnId.Valid {
+			id := int(returnId.Int64)
+			refund.ReturnId = &id
+		}
+		refunds = append(refunds, refund)
+	}
+	return
+}
@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"errors"
 	"log"
+	"strconv"
+	"time"
 	"toyStore/entities"
 	"toyStore/models"
 	"unicode"
@@ -17,8 +19,14 @@ type ProductRepository interface {
 	GetProductCategory(prodId int) (cat entities.Category, err error)
 	SetProductCategory(prodId int, cat entities.Category) (err error)
 	RemoveProductCategory(prodId int) (err error)
+	AddProductPrice(price models.ProductPrice_db) (newPriceId int, err error)
+	RemoveSalePrice(prodId int, priceId int) (err error)
+	GetPriceHistory(prodId int) (prices []models.ProductPrice_db, err error)
 }
 
+// active sale price of a product, the lowest one if sales overlap
+const salePriceJoin = "LEFT JOIN LATERAL (SELECT ProductPrices.Price, ProductPrices.EndDate FROM ProductPrices WHERE ProductPrices.ProductId = Products.Id AND ProductPrices.Kind = 'sale' AND ProductPrices.StartDate <= $2 AND ProductPrices.EndDate > $2 ORDER BY ProductPrices.Price LIMIT 1) Sale ON true"
+
 type ProductRepo struct {
 	db *sql.DB
 }
@@ -37,9 +45,11 @@ func NewProductRepository(conn *sql.DB) (ProductRepository, error) {
 }
 
 func (p *ProductRepo) GetProductById(id int) (pModel models.Product_db, exists bool, err error) {
-	row := p.db.QueryRow("SELECT Id, Name, Manufacturer, Quantity, Price, Description, Available FROM Products where Id = $1", id)
+	var salePrice sql.Null[models.Money]
+	var saleEnd sql.NullTime
+	row := p.db.QueryRow("SELECT Products.Id, Products.Name, Products.Manufacturer, Products.Quantity, Products.Price, Sale.Price, Sale.EndDate, Products.Weight, Products.Description, Products.Available FROM Products "+salePriceJoin+" where Products.Id = $1", id, time.Now().UTC())
 	err = row.Scan(&pModel.Id, &pModel.Name, &pModel.Manufacturer,
-		&pModel.Quantity, &pModel.Price, &pModel.Description, &pModel.Available)
+		&pModel.Quantity, &pModel.RegularPrice, &salePrice, &saleEnd, &pModel.Weight, &pModel.Description, &pModel.Available)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -50,6 +60,11 @@ func (p *ProductRepo) GetProductById(id int) (pModel models.Product_db, exists b
 		}
 		return
 	}
+	pModel.Price = pModel.RegularPrice
+	if salePrice.Valid {
+		pModel.Price = salePrice.V
+		pModel.SaleEndDate = &saleEnd.Time
+	}
 	exists = true
 	return
 }
@@ -104,7 +119,7 @@ func (p *ProductRepo) RemoveProductCategory(prodId int) (err error) {
 }
 
 func (p *ProductRepo) GetProductsByCategory(catId int) (prods []entities.ProductPreview, err error) {
-	rows, e := p.db.Query("select Id, Name, Manufacturer, Price, Available FROM Products JOIN ProductsCategories ON ProductsCategories.ProductId=Products.Id where ProductsCategories.CategoryId =$1", catId)
+	rows, e := p.db.Query("select Products.Id, Products.Name, Products.Manufacturer, Products.Price, COALESCE(Sale.Price, Products.Price), Products.Available FROM Products JOIN ProductsCategories ON ProductsCategories.ProductId=Products.Id "+salePriceJoin+" where ProductsCategories.CategoryId =$1", catId, time.Now().UTC())
 	if e != nil {
 		log.Printf("GetProductsByCategory[1]: %v", e)
 		err = models.ErrServerError
@@ -112,7 +127,7 @@ func (p *ProductRepo) GetProductsByCategory(catId int) (prods []entities.Product
 	}
 	for rows.Next() {
 		prod := entities.ProductPreview{}
-		err = rows.Scan(&prod.Id, &prod.Name, &prod.Manufacturer, &prod.Price, &prod.Available)
+		err = rows.Scan(&prod.Id, &prod.Name, &prod.Manufacturer, &prod.RegularPrice, &prod.Price, &prod.Available)
 		if err != nil {
 			log.Printf("GetProductsByCategory[2]: %v", err)
 			err = models.ErrServerError
@@ -125,7 +140,8 @@ func (p *ProductRepo) GetProductsByCategory(catId int) (prods []entities.Product
 
 func (p *ProductRepo) UpdateProductById(pModel models.Product) (updatedProd models.Product_db, err error) {
 	var ex bool
-	_, ex, err = p.GetProductById(pModel.Id)
+	var curProd models.Product_db
+	curProd, ex, err = p.GetProductById(pModel.Id)
 	if err != nil {
 		return
 	}
@@ -135,41 +151,63 @@ func (p *ProductRepo) UpdateProductById(pModel models.Product) (updatedProd mode
 		return
 	}
 
-	queryParams := make([]any, 0, 7)
+	queryParams := make([]any, 0, 8)
 	query := "UPDATE Products SET "
 	if isValidLen(pModel.Name, 5, 30) && isValidString(pModel.Name) {
-		query = query + "Name = $1, "
 		queryParams = append(queryParams, pModel.Name)
+		query = query + "Name = $" + strconv.Itoa(len(queryParams)) + ", "
 	}
 	if isValidLen(pModel.Manufacturer, 5, 30) && isValidString(pModel.Manufacturer) {
-		query = query + "Manufacturer = $2, "
 		queryParams = append(queryParams, pModel.Manufacturer)
+		query = query + "Manufacturer = $" + strconv.Itoa(len(queryParams)) + ", "
 	}
 	if pModel.Quantity > 0 {
-		query = query + "Quantity = $3, "
 		queryParams = append(queryParams, pModel.Quantity)
+		query = query + "Quantity = $" + strconv.Itoa(len(queryParams)) + ", "
 	}
 	if pModel.Price > 0 {
-		query = query + "Price = $4, "
 		queryParams = append(queryParams, pModel.Price)
+		query = query + "Price = $" + strconv.Itoa(len(queryParams)) + ", "
+	}
+	if pModel.Weight > 0 {
+		queryParams = append(queryParams, pModel.Weight)
+		query = query + "Weight = $" + strconv.Itoa(len(queryParams)) + ", "
 	}
 	if isValidLen(pModel.Description, 5, 100) && isValidString(pModel.Description) {
-		query = query + "Description = $5, "
 		queryParams = append(queryParams, pModel.Description)
+		query = query + "Description = $" + strconv.Itoa(len(queryParams)) + ", "
 	}
 	if pModel.Available != nil {
-		query = query + "Available = $6, "
 		queryParams = append(queryParams, *pModel.Available)
+		query = query + "Available = $" + strconv.Itoa(len(queryParams)) + ", "
+	}
+	if len(queryParams) == 0 {
+		log.Printf("UpdateProductById: no valid fields to update")
+		err = models.ErrBadRequest
+		return
 	}
 	query = query[0 : len(query)-2]
-	query = query + " WHERE Id = $7"
 	queryParams = append(queryParams, pModel.Id)
+	query = query + " WHERE Id = $" + strconv.Itoa(len(queryParams))
 	_, e := p.db.Exec(query, queryParams...)
 	if e != nil {
 		log.Printf("UpdateProductById: %v", e)
 		err = models.ErrServerError
 		return
 	}
+	if pModel.Price > 0 && pModel.Price != curProd.RegularPrice {
+		now := time.Now().UTC()
+		_, err = p.AddProductPrice(models.ProductPrice_db{
+			ProductId: pModel.Id,
+			Price:     pModel.Price,
+			Kind:      models.PriceRegular,
+			StartDate: now,
+			CreatedAt: now,
+		})
+		if err != nil {
+			return
+		}
+	}
 
 	updatedProd, _, err = p.GetProductById(pModel.Id)
 	if err != nil {
@@ -243,3 +281,51 @@ func (p *ProductRepo) CreateProduct(pModel models.Product) (err error) {
 	}
 	return
 }
+
+func (p *ProductRepo) AddProductPrice(price models.ProductPrice_db) (newPriceId int, err error) {
+	err = p.db.QueryRow("INSERT INTO ProductPrices (ProductId, Price, Kind, StartDate, EndDate, CreatedAt) VALUES ($1, $2, $3, $4, $5, $6) RETURNING Id",
+		price.ProductId, price.Price, price.Kind, price.StartDate, price.EndDate, price.CreatedAt).Scan(&newPriceId)
+	if err != nil {
+		log.Printf("AddProductPrice: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (p *ProductRepo) RemoveSalePrice(prodId int, priceId int) (err error) {
+	res, e := p.db.Exec("DELETE FROM ProductPrices WHERE Id = $1 AND ProductId = $2 AND Kind = 'sale'", priceId, prodId)
+	if e != nil {
+		log.Printf("RemoveSalePrice: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+func (p *ProductRepo) GetPriceHistory(prodId int) (prices []models.ProductPrice_db, err error) {
+	rows, e := p.db.Query("SELECT Id, ProductId, Price, Kind, StartDate, EndDate, CreatedAt FROM ProductPrices WHERE ProductId = $1 ORDER BY StartDate, Id", prodId)
+	if e != nil {
+		log.Printf("GetPriceHistory[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var price models.ProductPrice_db
+		var endDate sql.NullTime
+		err = rows.Scan(&price.Id, &price.ProductId, &price.Price, &price.Kind, &price.StartDate, &endDate, &price.CreatedAt)
+		if err != nil {
+			log.Printf("GetPriceHistory[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		if endDate.Valid {
+			price.EndDate = &endDate.Time
+		}
+		prices = append(prices, price)
+	}
+	return
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"toyStore/models"
+
+	"github.com/lib/pq"
+)
+
+type PromotionRepository interface {
+	GetActivePromotions() (promos []models.Promotion_db, err error)
+	GetAllPromotions() (promos []models.Promotion_db, err error)
+	CreatePromotion(promo models.Promotion_db) (newPromoId int, err error)
+	SetPromotionActive(promoId int, active bool) (err error)
+}
+
+type PromotionRepo struct {
+	db *sql.DB
+}
+
+func NewPromotionRepository(conn *sql.DB) (PromotionRepository, error) {
+	if conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := conn.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return &PromotionRepo{
+		db: conn,
+	}, nil
+}
+
+func (p *PromotionRepo) GetActivePromotions() (promos []models.Promotion_db, err error) {
+	promos, err = p.queryPromotions("SELECT Id, Name, Type, CategoryId, ProductIds, BuyQuantity, FreeQuantity, MinTotal, DiscountPercent, Active FROM Promotions WHERE Active = true ORDER BY Id")
+	return
+}
+
+func (p *PromotionRepo) GetAllPromotions() (promos []models.Promotion_db, err error) {
+	promos, err = p.queryPromotions("SELECT Id, Name, Type, CategoryId, ProductIds, BuyQuantity, FreeQuantity, MinTotal, DiscountPercent, Active FROM Promotions ORDER BY Id")
+	return
+}
+
+func (p *PromotionRepo) queryPromotions(query string) (promos []models.Promotion_db, err error) {
+	rows, e := p.db.Query(query)
+	if e != nil {
+		log.Printf("queryPromotions[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var promo models.Promotion_db
+		var catId sql.NullInt64
+		var prodIds pq.Int64Array
+		err = rows.Scan(&promo.Id, &promo.Name, &promo.Type, &catId, &prodIds,
+			&promo.BuyQuantity, &promo.FreeQuantity, &promo.MinTotal, &promo.DiscountPercent, &promo.Active)
+		if err != nil {
+			log.Printf("queryPromotions[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		promo.CategoryId = int(catId.Int64)
+		for _, v := range prodIds {
+			promo.ProductIds = append(promo.ProductIds, int(v))
+		}
+		promos = append(promos, promo)
+	}
+	return
+}
+
+func (p *PromotionRepo) CreatePromotion(promo models.Promotion_db) (newPromoId int, err error) {
+	var catId sql.NullInt64
+	if promo.CategoryId != 0 {
+		catId = sql.NullInt64{Int64: int64(promo.CategoryId), Valid: true}
+	}
+	prodIds := pq.Int64Array{}
+	for _, v := range promo.ProductIds {
+		prodIds = append(prodIds, int64(v))
+	}
+	err = p.db.QueryRow("INSERT INTO Promotions (Name, Type, CategoryId, ProductIds, BuyQuantity, FreeQuantity, MinTotal, DiscountPercent, Active) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING Id",
+		promo.Name, promo.Type, catId, prodIds, promo.BuyQuantity, promo.FreeQuantity,
+		promo.MinTotal, promo.DiscountPercent, promo.Active).Scan(&newPromoId)
+	if err != nil {
+		log.Printf("CreatePromotion: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (p *PromotionRepo) SetPromotionActive(promoId int, active bool) (err error) {
+	res, e := p.db.Exec("UPDATE Promotions SET Active = $1 WHERE Id = $2", active, promoId)
+	if e != nil {
+		log.Printf("SetPromotionActive: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
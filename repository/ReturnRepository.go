@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+	"toyStore/models"
+)
+
+type ReturnRepository interface {
+	CreateReturn(ret models.Return_db) (newReturnId int, err error)
+	GetReturn(returnId int) (ret models.Return_db, exists bool, err error)
+	SearchReturns(userId *int, orderId *int, status *string) (rets []models.Return_db, err error)
+	GetReturnedQuantities(orderId int) (quantities map[int]int, err error)
+	SetReturnStatus(returnId int, from []string, status string, comment string) (err error)
+	ReceiveReturn(returnId int, items []models.ReturnItem_db) (err error)
+}
+
+type ReturnRepo struct {
+	db *sql.DB
+}
+
+func NewReturnRepository(conn *sql.DB) (ReturnRepository, error) {
+	if conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := conn.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return &ReturnRepo{
+		db: conn,
+	}, nil
+}
+
+func (r *ReturnRepo) CreateReturn(ret models.Return_db) (newReturnId int, err error) {
+	tx, e := r.db.Begin()
+	if e != nil {
+		log.Printf("CreateReturn[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("INSERT INTO Returns (OrderId, UserId, Status, Reason, Comment, CreatedAt, UpdatedAt) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING Id",
+		ret.OrderId, ret.UserId, ret.Status, ret.Reason, ret.Comment, ret.CreatedAt, ret.UpdatedAt).Scan(&newReturnId)
+	if err != nil {
+		log.Printf("CreateReturn[2]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	for _, v := range ret.Items {
+		_, err = tx.Exec("INSERT INTO ReturnItems (ReturnId, OrderItemId, ProductId, Quantity) VALUES ($1, $2, $3, $4)", newReturnId, v.OrderItemId, v.ProductId, v.Quantity)
+		if err != nil {
+			log.Printf("CreateReturn[3]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("CreateReturn[4]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (r *ReturnRepo) GetReturn(returnId int) (ret models.Return_db, exists bool, err error) {
+	row := r.db.QueryRow("SELECT Id, OrderId, UserId, Status, Reason, Comment, CreatedAt, UpdatedAt FROM Returns WHERE Id = $1", returnId)
+	err = row.Scan(&ret.Id, &ret.OrderId, &ret.UserId, &ret.Status, &ret.Reason, &ret.Comment, &ret.CreatedAt, &ret.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		} else {
+			log.Printf("GetReturn: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	ret.Items, err = r.getReturnItems(ret.Id)
+	if err != nil {
+		return
+	}
+	exists = true
+	return
+}
+
+func (r *ReturnRepo) SearchReturns(userId *int, orderId *int, status *string) (rets []models.Return_db, err error) {
+	query := "SELECT Id, OrderId, UserId, Status, Reason, Comment, CreatedAt, UpdatedAt FROM Returns WHERE true "
+	var queryParams []any
+	if userId != nil {
+		queryParams = append(queryParams, *userId)
+		query = query + "AND UserId = $" + strconv.Itoa(len(queryParams)) + " "
+	}
+	if orderId != nil {
+		queryParams = append(queryParams, *orderId)
+		query = query + "AND OrderId = $" + strconv.Itoa(len(queryParams)) + " "
+	}
+	if status != nil {
+		queryParams = append(queryParams, *status)
+		query = query + "AND Status = $" + strconv.Itoa(len(queryParams)) + " "
+	}
+	rows, e := r.db.Query(query+"ORDER BY Id", queryParams...)
+	if e != nil {
+		log.Printf("SearchReturns[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	rets = []models.Return_db{}
+	for rows.Next() {
+		var ret models.Return_db
+		err = rows.Scan(&ret.Id, &ret.OrderId, &ret.UserId, &ret.Status, &ret.Reason, &ret.Comment, &ret.CreatedAt, &ret.UpdatedAt)
+		if err != nil {
+			log.Printf("SearchReturns[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		rets = append(rets, ret)
+	}
+	for i := range rets {
+		rets[i].Items, err = r.getReturnItems(rets[i].Id)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// GetReturnedQuantities sums the quantities of the order lines in all returns except rejected ones
+func (r *ReturnRepo) GetReturnedQuantities(orderId int) (quantities map[int]int, err error) {
+	rows, e := r.db.Query("SELECT ReturnItems.OrderItemId, SUM(ReturnItems.Quantity) FROM ReturnItems JOIN Returns ON Returns.Id = ReturnItems.ReturnId WHERE Returns.OrderId = $1 AND Returns.Status <> $2 GROUP BY ReturnItems.OrderItemId",
+		orderId, models.ReturnRejected)
+	if e != nil {
+		log.Printf("GetReturnedQuantities[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	quantities = map[int]int{}
+	for rows.Next() {
+		var itemId, quantity int
+		err = rows.Scan(&itemId, &quantity)
+		if err != nil {
+			log.Printf("GetReturnedQuantities[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		quantities[itemId] = quantity
+	}
+	return
+}
+
+// SetReturnStatus changes the status only if the current one is in from
+func (r *ReturnRepo) SetReturnStatus(returnId int, from []string, status string, comment string) (err error) {
+	var current string
+	err = r.db.QueryRow("SELECT Status FROM Returns WHERE Id = $1", returnId).Scan(&current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = models.ErrNotFoundError
+		} else {
+			log.Printf("SetReturnStatus[1]: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	allowed := false
+	for _, v := range from {
+		allowed = allowed || v == current
+	}
+	if !allowed {
+		log.Printf("return status can not be changed from %v to %v", current, status)
+		err = models.ErrNotAllowed
+		return
+	}
+	_, err = r.db.Exec("UPDATE Returns SET Status = $1, Comment = CASE WHEN $2 = '' THEN Comment ELSE $2 END, UpdatedAt = $3 WHERE Id = $4 AND Status = $5",
+		status, comment, time.Now().UTC(), returnId, current)
+	if err != nil {
+		log.Printf("SetReturnStatus[2]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// ReceiveReturn records the received quantities and puts the items back into stock
+func (r *ReturnRepo) ReceiveReturn(returnId int, items []models.ReturnItem_db) (err error) {
+	tx, e := r.db.Begin()
+	if e != nil {
+		log.Printf("ReceiveReturn[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	// the status is changed first, a return received concurrently is not put into stock twice
+	res, e := tx.Exec("UPDATE Returns SET Status = $1, UpdatedAt = $2 WHERE Id = $3 AND Status = $4", models.ReturnReceived, time.Now().UTC(), returnId, models.ReturnApproved)
+	if e != nil {
+		log.Printf("ReceiveReturn[2]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		log.Printf("return %v is not approved", returnId)
+		err = models.ErrNotAllowed
+		return
+	}
+	for _, v := range items {
+		_, err = tx.Exec("UPDATE ReturnItems SET ReceivedQuantity = $1 WHERE Id = $2 AND ReturnId = $3", v.ReceivedQuantity, v.Id, returnId)
+		if err != nil {
+			log.Printf("ReceiveReturn[3]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		_, err = tx.Exec("UPDATE Products SET Quantity = Quantity + $1 WHERE Id = $2", v.ReceivedQuantity, v.ProductId)
+		if err != nil {
+			log.Printf("ReceiveReturn[4]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ReceiveReturn[5]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (r *ReturnRepo) getReturnItems(returnId int) (items []models.ReturnItem_db, err error) {
+	rows, e := r.db.Query("SELECT Id, ReturnId, OrderItemId, ProductId, Quantity, ReceivedQuantity FROM ReturnItems WHERE ReturnId = $1 ORDER BY Id", returnId)
+	if e != nil {
+		log.Printf("getReturnItems[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item models.ReturnItem_db
+		err = rows.Scan(&item.Id, &item.ReturnId, &item.OrderItemId, &item.ProductId, &item.Quantity, &item.ReceivedQuantity)
+		if err != nil {
+			log.Printf("getReturnItems[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		items = append(items, item)
+	}
+	return
+}
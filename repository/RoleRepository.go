@@ -0,0 +1,223 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"toyStore/models"
+)
+
+type RoleRepository interface {
+	GetRoles() (roles []models.Role_db, err error)
+	GetRole(name string) (role models.Role_db, exists bool, err error)
+	HasPermission(role string, permission string) (allowed bool, err error)
+	CreateRole(role models.Role_db) (err error)
+	UpdateRole(role models.Role_db) (err error)
+	DeleteRole(name string) (err error)
+}
+
+type RoleRepo struct {
+	db *sql.DB
+}
+
+func NewRoleRepository(conn *sql.DB) (RoleRepository, error) {
+	if conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := conn.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return &RoleRepo{
+		db: conn,
+	}, nil
+}
+
+func (r *RoleRepo) GetRoles() (roles []models.Role_db, err error) {
+	rows, e := r.db.Query("SELECT Name, Description FROM Roles ORDER BY Name")
+	if e != nil {
+		log.Printf("GetRoles[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	roles = []models.Role_db{}
+	for rows.Next() {
+		var role models.Role_db
+		err = rows.Scan(&role.Name, &role.Description)
+		if err != nil {
+			log.Printf("GetRoles[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		roles = append(roles, role)
+	}
+	rows.Close()
+
+	for i := range roles {
+		roles[i].Permissions, err = r.getRolePermissions(roles[i].Name)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (r *RoleRepo) GetRole(name string) (role models.Role_db, exists bool, err error) {
+	err = r.db.QueryRow("SELECT Name, Description FROM Roles WHERE Name = $1", name).Scan(&role.Name, &role.Description)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		} else {
+			log.Printf("GetRole: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	role.Permissions, err = r.getRolePermissions(name)
+	if err != nil {
+		return
+	}
+	exists = true
+	return
+}
+
+func (r *RoleRepo) HasPermission(role string, permission string) (allowed bool, err error) {
+	err = r.db.QueryRow("SELECT EXISTS (SELECT 1 FROM RolePermissions WHERE Role = $1 AND Permission = $2)", role, permission).Scan(&allowed)
+	if err != nil {
+		log.Printf("HasPermission: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// CreateRole returns ErrConflict if the role already exists
+func (r *RoleRepo) CreateRole(role models.Role_db) (err error) {
+	tx, e := r.db.Begin()
+	if e != nil {
+		log.Printf("CreateRole[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	res, e := tx.Exec("INSERT INTO Roles (Name, Description) VALUES ($1, $2) ON CONFLICT (Name) DO NOTHING", role.Name, role.Description)
+	if e != nil {
+		log.Printf("CreateRole[2]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		log.Printf("role %v already exists", role.Name)
+		err = models.ErrConflict
+		return
+	}
+	err = setRolePermissions(tx, role)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("CreateRole[3]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// UpdateRole changes the description and replaces the permissions of the role
+func (r *RoleRepo) UpdateRole(role models.Role_db) (err error) {
+	tx, e := r.db.Begin()
+	if e != nil {
+		log.Printf("UpdateRole[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	res, e := tx.Exec("UPDATE Roles SET Description = $1 WHERE Name = $2", role.Description, role.Name)
+	if e != nil {
+		log.Printf("UpdateRole[2]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+		return
+	}
+	_, err = tx.Exec("DELETE FROM RolePermissions WHERE Role = $1", role.Name)
+	if err != nil {
+		log.Printf("UpdateRole[3]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	err = setRolePermissions(tx, role)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("UpdateRole[4]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// DeleteRole deletes a role nobody has, users have to get another role first
+func (r *RoleRepo) DeleteRole(name string) (err error) {
+	var users int
+	err = r.db.QueryRow("SELECT COUNT(*) FROM Users WHERE Role = $1", name).Scan(&users)
+	if err != nil {
+		log.Printf("DeleteRole[1]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	if users > 0 {
+		log.Printf("role %v is used by %v user(s)", name, users)
+		err = models.ErrNotAllowed
+		return
+	}
+	res, e := r.db.Exec("DELETE FROM Roles WHERE Name = $1", name)
+	if e != nil {
+		log.Printf("DeleteRole[2]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+func (r *RoleRepo) getRolePermissions(name string) (permissions []string, err error) {
+	rows, e := r.db.Query("SELECT Permission FROM RolePermissions WHERE Role = $1 ORDER BY Permission", name)
+	if e != nil {
+		log.Printf("getRolePermissions[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	permissions = []string{}
+	for rows.Next() {
+		var permission string
+		err = rows.Scan(&permission)
+		if err != nil {
+			log.Printf("getRolePermissions[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		permissions = append(permissions, permission)
+	}
+	return
+}
+
+func setRolePermissions(tx *sql.Tx, role models.Role_db) (err error) {
+	for _, v := range role.Permissions {
+		_, err = tx.Exec("INSERT INTO RolePermissions (Role, Permission) VALUES ($1, $2)", role.Name, v)
+		if err != nil {
+			log.Printf("setRolePermissions: %v", err)
+			err = models.ErrServerError
+			return
+		}
+	}
+	return
+}
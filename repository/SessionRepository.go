@@ -2,8 +2,11 @@ package repository
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"log"
+	"sort"
 	"strconv"
 	"time"
 	"toyStore/models"
@@ -13,11 +16,18 @@ import (
 )
 
 type SessionRepository interface {
-	CreateSession(userId int, role string) (sessionId string, err error)
+	CreateSession(userId int, role string, meta models.SessionMeta) (sessionId string, err error)
 	CheckSession(sessionId string) (bool, error)
 	DeleteSession(sessionId string) (err error)
 	RefreshSession(sessionId string, expirationTime time.Duration) (err error)
 	GetUserSessionInfo(sessionId string) (userId int, role string, exists bool, err error)
+	RevokeUserSessions(userId int) (err error)
+	SetSessionMfa(sessionId string) (err error)
+	GetSessionMfa(sessionId string) (mfa bool, err error)
+	GetUserSessions(userId int, currentSessionId string) (sessions []models.Session_db, err error)
+	DeleteUserSession(userId int, publicId string) (err error)
+	SaveRefreshToken(tokenHash string, sessionId string, ttl time.Duration) (err error)
+	TakeRefreshToken(tokenHash string, ttl time.Duration) (sessionId string, reused bool, exists bool, err error)
 }
 
 type SessionRepo struct {
@@ -39,20 +49,68 @@ func NewSessionRepository(redis_conn *redis.Client, _ctx context.Context) (Sessi
 	}, nil
 }
 
-func (s *SessionRepo) CreateSession(userId int, role string) (sessionId string, err error) {
+func (s *SessionRepo) CreateSession(userId int, role string, meta models.SessionMeta) (sessionId string, err error) {
 	sessionId = uuid.NewString()
-	err = s.rdb.HSet(s.ctx, sessionId, "userId", userId, "role", role).Err()
+	sessionType := models.SessionTypeCookie
+	if meta.Token {
+		sessionId = models.TokenSessionPrefix + sessionId
+		sessionType = models.SessionTypeToken
+	}
+	now := time.Now().Unix()
+	err = s.rdb.HSet(s.ctx, sessionId, "userId", userId, "role", role, "type", sessionType,
+		"ip", meta.Ip, "userAgent", meta.UserAgent, "device", meta.Device, "createdAt", now, "lastSeenAt", now).Err()
 	if err != nil {
 		log.Printf("CreateSession: %v", err)
 		err = models.ErrServerError
 		return
 	}
-	expired := 30 * time.Minute
+	expired := models.SessionTtl
 	s.rdb.Expire(s.ctx, sessionId, expired)
+
+	// the set of the user sessions lives as long as the longest of them
+	key := userSessionsKey(userId)
+	err = s.rdb.SAdd(s.ctx, key, sessionId).Err()
+	if err != nil {
+		log.Printf("CreateSession: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	s.extendUserSessions(key, expired)
 	return
 }
 
+// extendUserSessions sets the expiry of the new set and only extends it later,
+// a short cookie session must not expire the index of a long token session
+func (s *SessionRepo) extendUserSessions(key string, ttl time.Duration) {
+	s.rdb.ExpireNX(s.ctx, key, ttl)
+	s.rdb.ExpireGT(s.ctx, key, ttl)
+}
+
+// RevokeUserSessions deletes all sessions of the user, for example when the user is blocked
+func (s *SessionRepo) RevokeUserSessions(userId int) (err error) {
+	key := userSessionsKey(userId)
+	sessionIds, err := s.rdb.SMembers(s.ctx, key).Result()
+	if err != nil {
+		log.Printf("RevokeUserSessions[1]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	err = s.rdb.Del(s.ctx, append(sessionIds, key)...).Err()
+	if err != nil {
+		log.Printf("RevokeUserSessions[2]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func userSessionsKey(userId int) string {
+	return "userSessions:" + strconv.Itoa(userId)
+}
+
 func (s *SessionRepo) DeleteSession(sessionId string) (err error) {
+	if userId, e := s.rdb.HGet(s.ctx, sessionId, "userId").Int(); e == nil {
+		s.rdb.SRem(s.ctx, userSessionsKey(userId), sessionId)
+	}
 	err = s.rdb.Del(s.ctx, sessionId).Err()
 	if err != nil {
 		log.Printf("DeleteSession: %v", err)
@@ -97,6 +155,156 @@ func (s *SessionRepo) RefreshSession(sessionId string, expirationTime time.Durat
 	if err != nil {
 		log.Printf("RefreshSession: %v", err)
 		err = models.ErrServerError
+		return
+	}
+	err = setIfExistsScript.Run(s.ctx, s.rdb, []string{sessionId}, "lastSeenAt", time.Now().Unix()).Err()
+	if err != nil {
+		log.Printf("RefreshSession: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	if userId, e := s.rdb.HGet(s.ctx, sessionId, "userId").Int(); e == nil {
+		s.extendUserSessions(userSessionsKey(userId), expirationTime)
+	}
+	return
+}
+
+// setIfExistsScript does not create a session without expiry when it has just expired
+var setIfExistsScript = redis.NewScript(`if redis.call("EXISTS", KEYS[1]) == 1 then return redis.call("HSET", KEYS[1], ARGV[1], ARGV[2]) end return 0`)
+
+// SetSessionMfa marks the session as signed in with the second factor
+func (s *SessionRepo) SetSessionMfa(sessionId string) (err error) {
+	err = setIfExistsScript.Run(s.ctx, s.rdb, []string{sessionId}, "mfa", 1).Err()
+	if err != nil {
+		log.Printf("SetSessionMfa: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (s *SessionRepo) GetSessionMfa(sessionId string) (mfa bool, err error) {
+	val, err := s.rdb.HGet(s.ctx, sessionId, "mfa").Result()
+	if err != nil {
+		if err == redis.Nil {
+			err = nil
+		} else {
+			log.Printf("GetSessionMfa: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	mfa = val == "1"
+	return
+}
+
+// sessionPublicId identifies a session in the list of the user sessions,
+// the session id itself is the secret of the cookie and is never shown
+func sessionPublicId(sessionId string) string {
+	sum := sha256.Sum256([]byte(sessionId))
+	return hex.EncodeToString(sum[:8])
+}
+
+// GetUserSessions returns the live sessions of the user, the expired ones are removed from the index
+func (s *SessionRepo) GetUserSessions(userId int, currentSessionId string) (sessions []models.Session_db, err error) {
+	key := userSessionsKey(userId)
+	sessionIds, err := s.rdb.SMembers(s.ctx, key).Result()
+	if err != nil {
+		log.Printf("GetUserSessions[1]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	sessions = []models.Session_db{}
+	for _, v := range sessionIds {
+		val, e := s.rdb.HGetAll(s.ctx, v).Result()
+		if e != nil {
+			log.Printf("GetUserSessions[2]: %v", e)
+			err = models.ErrServerError
+			return
+		}
+		if len(val) == 0 {
+			s.rdb.SRem(s.ctx, key, v)
+			continue
+		}
+		createdAt, _ := strconv.ParseInt(val["createdAt"], 10, 64)
+		lastSeenAt, _ := strconv.ParseInt(val["lastSeenAt"], 10, 64)
+		sessions = append(sessions, models.Session_db{
+			Id:         sessionPublicId(v),
+			Ip:         val["ip"],
+			UserAgent:  val["userAgent"],
+			Device:     val["device"],
+			Type:       val["type"],
+			CreatedAt:  time.Unix(createdAt, 0).UTC(),
+			LastSeenAt: time.Unix(lastSeenAt, 0).UTC(),
+			Current:    v == currentSessionId,
+		})
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+	return
+}
+
+// DeleteUserSession signs out one session of the user by its public id
+func (s *SessionRepo) DeleteUserSession(userId int, publicId string) (err error) {
+	sessionIds, err := s.rdb.SMembers(s.ctx, userSessionsKey(userId)).Result()
+	if err != nil {
+		log.Printf("DeleteUserSession: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	for _, v := range sessionIds {
+		if sessionPublicId(v) == publicId {
+			err = s.DeleteSession(v)
+			return
+		}
+	}
+	err = models.ErrNotFoundError
+	return
+}
+
+func refreshTokenKey(tokenHash string) string {
+	return "refreshToken:" + tokenHash
+}
+
+func usedRefreshTokenKey(tokenHash string) string {
+	return "refreshTokenUsed:" + tokenHash
+}
+
+func (s *SessionRepo) SaveRefreshToken(tokenHash string, sessionId string, ttl time.Duration) (err error) {
+	err = s.rdb.Set(s.ctx, refreshTokenKey(tokenHash), sessionId, ttl).Err()
+	if err != nil {
+		log.Printf("SaveRefreshToken: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// TakeRefreshToken returns the session of the token and deletes the token. A used token is remembered
+// for the ttl, reused is true when it comes again, so the caller can revoke the stolen session.
+func (s *SessionRepo) TakeRefreshToken(tokenHash string, ttl time.Duration) (sessionId string, reused bool, exists bool, err error) {
+	sessionId, err = s.rdb.GetDel(s.ctx, refreshTokenKey(tokenHash)).Result()
+	if err == nil {
+		exists = true
+		err = s.rdb.Set(s.ctx, usedRefreshTokenKey(tokenHash), sessionId, ttl).Err()
+		if err != nil {
+			log.Printf("TakeRefreshToken[1]: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	if err != redis.Nil {
+		log.Printf("TakeRefreshToken[2]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	sessionId, err = s.rdb.Get(s.ctx, usedRefreshTokenKey(tokenHash)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			err = nil
+		} else {
+			log.Printf("TakeRefreshToken[3]: %v", err)
+			err = models.ErrServerError
+		}
+		return
 	}
+	reused = true
 	return
 }
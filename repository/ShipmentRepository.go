@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"toyStore/models"
+)
+
+type ShipmentRepository interface {
+	GetCarriers(onlyActive bool) (carriers []models.Carrier_db, err error)
+	GetCarrier(carrierId int) (carrier models.Carrier_db, exists bool, err error)
+	CreateCarrier(carrier models.Carrier_db) (newCarrierId int, err error)
+	UpdateCarrier(carrier models.Carrier_db) (err error)
+	GetShippedQuantities(orderId int) (shipped map[int]int, err error)
+	CreateShipment(shipment models.Shipment_db) (newShipmentId int, err error)
+	GetOrderShipments(orderId int) (shipments []models.Shipment_db, err error)
+}
+
+type ShipmentRepo struct {
+	db *sql.DB
+}
+
+func NewShipmentRepository(conn *sql.DB) (ShipmentRepository, error) {
+	if conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := conn.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return &ShipmentRepo{
+		db: conn,
+	}, nil
+}
+
+func (s *ShipmentRepo) GetCarriers(onlyActive bool) (carriers []models.Carrier_db, err error) {
+	query := "SELECT Id, Name, TrackingUrlTemplate, Active FROM Carriers "
+	if onlyActive {
+		query = query + "WHERE Active = true "
+	}
+	rows, e := s.db.Query(query + "ORDER BY Id")
+	if e != nil {
+		log.Printf("GetCarriers[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	carriers = []models.Carrier_db{}
+	for rows.Next() {
+		var carrier models.Carrier_db
+		err = rows.Scan(&carrier.Id, &carrier.Name, &carrier.TrackingUrlTemplate, &carrier.Active)
+		if err != nil {
+			log.Printf("GetCarriers[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		carriers = append(carriers, carrier)
+	}
+	return
+}
+
+func (s *ShipmentRepo) GetCarrier(carrierId int) (carrier models.Carrier_db, exists bool, err error) {
+	row := s.db.QueryRow("SELECT Id, Name, TrackingUrlTemplate, Active FROM Carriers WHERE Id = $1", carrierId)
+	err = row.Scan(&carrier.Id, &carrier.Name, &carrier.TrackingUrlTemplate, &carrier.Active)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		} else {
+			log.Printf("GetCarrier: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	exists = true
+	return
+}
+
+func (s *ShipmentRepo) CreateCarrier(carrier models.Carrier_db) (newCarrierId int, err error) {
+	err = s.db.QueryRow("INSERT INTO Carriers (Name, TrackingUrlTemplate, Active) VALUES ($1, $2, $3) RETURNING Id",
+		carrier.Name, carrier.TrackingUrlTemplate, carrier.Active).Scan(&newCarrierId)
+	if err != nil {
+		log.Printf("CreateCarrier: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (s *ShipmentRepo) UpdateCarrier(carrier models.Carrier_db) (err error) {
+	res, e := s.db.Exec("UPDATE Carriers SET Name = $1, TrackingUrlTemplate = $2, Active = $3 WHERE Id = $4",
+		carrier.Name, carrier.TrackingUrlTemplate, carrier.Active, carrier.Id)
+	if e != nil {
+		log.Printf("UpdateCarrier: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+// GetShippedQuantities returns the shipped quantity of every order item, map[orderItemId]quantity
+func (s *ShipmentRepo) GetShippedQuantities(orderId int) (shipped map[int]int, err error) {
+	rows, e := s.db.Query("SELECT ShipmentItems.OrderItemId, SUM(ShipmentItems.Quantity) FROM ShipmentItems JOIN Shipments ON ShipmentItems.ShipmentId = Shipments.Id WHERE Shipments.OrderId = $1 GROUP BY ShipmentItems.OrderItemId", orderId)
+	if e != nil {
+		log.Printf("GetShippedQuantities[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	shipped = map[int]int{}
+	for rows.Next() {
+		var itemId, quantity int
+		err = rows.Scan(&itemId, &quantity)
+		if err != nil {
+			log.Printf("GetShippedQuantities[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		shipped[itemId] = quantity
+	}
+	return
+}
+
+// CreateShipment saves the shipment and moves the order to partially_shipped or shipped
+// by the quantities left to ship. The order row is locked, so parallel shipments can not ship a line twice.
+func (s *ShipmentRepo) CreateShipment(shipment models.Shipment_db) (newShipmentId int, err error) {
+	tx, e := s.db.Begin()
+	if e != nil {
+		log.Printf("CreateShipment[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	var oldStatus string
+	err = tx.QueryRow("SELECT Status FROM Orders WHERE Id = $1 FOR UPDATE", shipment.OrderId).Scan(&oldStatus)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = models.ErrNotFoundError
+		} else {
+			log.Printf("CreateShipment[2]: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	if oldStatus != "confirmed" && oldStatus != "partially_shipped" {
+		log.Printf("order %v can not be shipped, status is %v", shipment.OrderId, oldStatus)
+		err = models.ErrNotAllowed
+		return
+	}
+
+	err = tx.QueryRow("INSERT INTO Shipments (OrderId, CarrierId, TrackingNumber, ShippedAt) VALUES ($1, $2, $3, $4) RETURNING Id",
+		shipment.OrderId, shipment.CarrierId, shipment.TrackingNumber, shipment.ShippedAt).Scan(&newShipmentId)
+	if err != nil {
+		log.Printf("CreateShipment[3]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	for _, v := range shipment.Items {
+		_, err = tx.Exec("INSERT INTO ShipmentItems (ShipmentId, OrderItemId, ProductId, Quantity) VALUES ($1, $2, $3, $4)", newShipmentId, v.OrderItemId, v.ProductId, v.Quantity)
+		if err != nil {
+			log.Printf("CreateShipment[4]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+	}
+
+	var over, left int
+	err = tx.QueryRow(`SELECT COUNT(*) FILTER (WHERE s.Shipped > s.Quantity), COUNT(*) FILTER (WHERE s.Shipped < s.Quantity) FROM (
+		SELECT OrdersProducts.Quantity, COALESCE(SUM(ShipmentItems.Quantity), 0) AS Shipped
+		FROM OrdersProducts LEFT JOIN ShipmentItems ON ShipmentItems.OrderItemId = OrdersProducts.Id
+		WHERE OrdersProducts.OrderId = $1 GROUP BY OrdersProducts.Id, OrdersProducts.Quantity) s`, shipment.OrderId).Scan(&over, &left)
+	if err != nil {
+		log.Printf("CreateShipment[5]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	if over > 0 {
+		log.Printf("CreateShipment: order %v lines are already shipped", shipment.OrderId)
+		err = models.ErrConflict
+		return
+	}
+	status := "partially_shipped"
+	if left == 0 {
+		status = "shipped"
+	}
+	if status != oldStatus {
+		_, err = tx.Exec("UPDATE Orders SET Status = $1 WHERE Id = $2", status, shipment.OrderId)
+		if err != nil {
+			log.Printf("CreateShipment[6]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		err = addStatusHistory(tx, shipment.OrderId, oldStatus, status, "shipment "+strconv.Itoa(newShipmentId))
+		if err != nil {
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("CreateShipment[7]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (s *ShipmentRepo) GetOrderShipments(orderId int) (shipments []models.Shipment_db, err error) {
+	shipments, err = getOrderShipments(s.db, orderId)
+	return
+}
+
+// getOrderShipments is shared with OrderRepo, the orders are returned with their shipments
+func getOrderShipments(db *sql.DB, orderId int) (shipments []models.Shipment_db, err error) {
+	rows, e := db.Query("SELECT Shipments.Id, Shipments.OrderId, Shipments.CarrierId, Carriers.Name, Carriers.TrackingUrlTemplate, Shipments.TrackingNumber, Shipments.ShippedAt FROM Shipments JOIN Carriers ON Shipments.CarrierId = Carriers.Id WHERE Shipments.OrderId = $1 ORDER BY Shipments.Id", orderId)
+	if e != nil {
+		log.Printf("getOrderShipments[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	shipments = []models.Shipment_db{}
+	for rows.Next() {
+		var shipment models.Shipment_db
+		var carrier models.Carrier_db
+		err = rows.Scan(&shipment.Id, &shipment.OrderId, &shipment.CarrierId, &carrier.Name, &carrier.TrackingUrlTemplate, &shipment.TrackingNumber, &shipment.ShippedAt)
+		if err != nil {
+			log.Printf("getOrderShipments[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		shipment.Carrier = carrier.Name
+		shipment.TrackingUrl = carrier.TrackingUrl(shipment.TrackingNumber)
+		shipments = append(shipments, shipment)
+	}
+	rows.Close()
+
+	for i := range shipments {
+		shipments[i].Items, err = getShipmentItems(db, shipments[i].Id)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+func getShipmentItems(db *sql.DB, shipmentId int) (items []models.ShipmentItem_db, err error) {
+	rows, e := db.Query("SELECT Id, ShipmentId, OrderItemId, ProductId, Quantity FROM ShipmentItems WHERE ShipmentId = $1 ORDER BY Id", shipmentId)
+	if e != nil {
+		log.Printf("getShipmentItems[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	items = []models.ShipmentItem_db{}
+	for rows.Next() {
+		var item models.ShipmentItem_db
+		err = rows.Scan(&item.Id, &item.ShipmentId, &item.OrderItemId, &item.ProductId, &item.Quantity)
+		if err != nil {
+			log.Printf("getShipmentItems[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		items = append(items, item)
+	}
+	return
+}
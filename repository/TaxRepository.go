@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"toyStore/entities"
+	"toyStore/models"
+)
+
+type TaxRepository interface {
+	GetTaxClasses() (classes []entities.TaxClass, err error)
+	TaxClassExist(classId int) (bool, error)
+	CreateTaxClass(class models.TaxClass_db) (newClassId int, err error)
+	SetTaxRate(rate models.TaxRate_db) (err error)
+	GetProductTaxRate(prodId int, region string) (rate models.TaxRate_db, exists bool, err error)
+	SetProductTaxClass(prodId int, classId int) (err error)
+	SetCategoryTaxClass(catId int, classId int) (err error)
+}
+
+type TaxRepo struct {
+	db *sql.DB
+}
+
+func NewTaxRepository(conn *sql.DB) (TaxRepository, error) {
+	if conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := conn.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return &TaxRepo{
+		db: conn,
+	}, nil
+}
+
+func (t *TaxRepo) GetTaxClasses() (classes []entities.TaxClass, err error) {
+	rows, e := t.db.Query("SELECT Id, Name FROM TaxClasses ORDER BY Id")
+	if e != nil {
+		log.Printf("GetTaxClasses[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		class := entities.TaxClass{Rates: []models.TaxRate_db{}}
+		err = rows.Scan(&class.Id, &class.Name)
+		if err != nil {
+			log.Printf("GetTaxClasses[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		classes = append(classes, class)
+	}
+
+	for i := range classes {
+		rowsRates, e2 := t.db.Query("SELECT Id, TaxClassId, Region, Rate, Inclusive FROM TaxRates WHERE TaxClassId = $1 ORDER BY Region", classes[i].Id)
+		if e2 != nil {
+			log.Printf("GetTaxClasses[3]: %v", e2)
+			err = models.ErrServerError
+			return
+		}
+		for rowsRates.Next() {
+			var rate models.TaxRate_db
+			err = rowsRates.Scan(&rate.Id, &rate.TaxClassId, &rate.Region, &rate.Rate, &rate.Inclusive)
+			if err != nil {
+				rowsRates.Close()
+				log.Printf("GetTaxClasses[4]: %v", err)
+				err = models.ErrServerError
+				return
+			}
+			classes[i].Rates = append(classes[i].Rates, rate)
+		}
+		rowsRates.Close()
+	}
+	return
+}
+
+func (t *TaxRepo) TaxClassExist(classId int) (bool, error) {
+	var id int
+	err := t.db.QueryRow("SELECT Id FROM TaxClasses WHERE Id = $1", classId).Scan(&id)
+	if err == nil {
+		return true, nil
+	}
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	log.Printf("TaxClassExist: %v", err)
+	return false, models.ErrServerError
+}
+
+func (t *TaxRepo) CreateTaxClass(class models.TaxClass_db) (newClassId int, err error) {
+	err = t.db.QueryRow("INSERT INTO TaxClasses (Name) VALUES ($1) ON CONFLICT (Name) DO NOTHING RETURNING Id", class.Name).Scan(&newClassId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			log.Printf("Tax class name is not unique")
+			err = models.ErrNotAllowed
+			return
+		}
+		log.Printf("CreateTaxClass: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (t *TaxRepo) SetTaxRate(rate models.TaxRate_db) (err error) {
+	_, err = t.db.Exec("INSERT INTO TaxRates (TaxClassId, Region, Rate, Inclusive) VALUES ($1, $2, $3, $4) ON CONFLICT (TaxClassId, Region) DO UPDATE SET Rate = EXCLUDED.Rate, Inclusive = EXCLUDED.Inclusive",
+		rate.TaxClassId, rate.Region, rate.Rate, rate.Inclusive)
+	if err != nil {
+		log.Printf("SetTaxRate: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// GetProductTaxRate finds the rate by the tax class of the product, or of its category if the product has none
+func (t *TaxRepo) GetProductTaxRate(prodId int, region string) (rate models.TaxRate_db, exists bool, err error) {
+	row := t.db.QueryRow("SELECT TaxRates.Id, TaxRates.TaxClassId, TaxRates.Region, TaxRates.Rate, TaxRates.Inclusive FROM Products LEFT JOIN ProductsCategories ON ProductsCategories.ProductId = Products.Id LEFT JOIN Categories ON Categories.Id = ProductsCategories.CategoryId JOIN TaxRates ON TaxRates.TaxClassId = COALESCE(Products.TaxClassId, Categories.TaxClassId) WHERE Products.Id = $1 AND TaxRates.Region = $2", prodId, region)
+	err = row.Scan(&rate.Id, &rate.TaxClassId, &rate.Region, &rate.Rate, &rate.Inclusive)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+		} else {
+			log.Printf("GetProductTaxRate: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	exists = true
+	return
+}
+
+func (t *TaxRepo) SetProductTaxClass(prodId int, classId int) (err error) {
+	var class sql.NullInt64
+	if classId != 0 {
+		class = sql.NullInt64{Int64: int64(classId), Valid: true}
+	}
+	res, e := t.db.Exec("UPDATE Products SET TaxClassId = $1 WHERE Id = $2", class, prodId)
+	if e != nil {
+		log.Printf("SetProductTaxClass: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+func (t *TaxRepo) SetCategoryTaxClass(catId int, classId int) (err error) {
+	var class sql.NullInt64
+	if classId != 0 {
+		class = sql.NullInt64{Int64: int64(classId), Valid: true}
+	}
+	res, e := t.db.Exec("UPDATE Categories SET TaxClassId = $1 WHERE Id = $2", class, catId)
+	if e != nil {
+		log.Printf("SetCategoryTaxClass: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
@@ -0,0 +1,89 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+	"toyStore/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TokenRepository keeps single-use tokens in Redis by their hash, the token itself is never stored
+type TokenRepository interface {
+	SaveToken(kind string, tokenHash string, userId int, ttl time.Duration) (err error)
+	TakeToken(kind string, tokenHash string) (userId int, exists bool, err error)
+	GetToken(kind string, tokenHash string) (userId int, exists bool, err error)
+}
+
+type TokenRepo struct {
+	rdb *redis.Client
+	ctx context.Context
+}
+
+func NewTokenRepository(redis_conn *redis.Client, _ctx context.Context) (TokenRepository, error) {
+	if redis_conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := redis_conn.Ping(_ctx).Err()
+	if err != nil {
+		return nil, err
+	}
+	return &TokenRepo{
+		rdb: redis_conn,
+		ctx: _ctx,
+	}, nil
+}
+
+func (t *TokenRepo) SaveToken(kind string, tokenHash string, userId int, ttl time.Duration) (err error) {
+	err = t.rdb.Set(t.ctx, tokenKey(kind, tokenHash), userId, ttl).Err()
+	if err != nil {
+		log.Printf("SaveToken: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// TakeToken returns the user of the token and deletes it, so the token works only once
+func (t *TokenRepo) TakeToken(kind string, tokenHash string) (userId int, exists bool, err error) {
+	val, err := t.rdb.GetDel(t.ctx, tokenKey(kind, tokenHash)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			err = nil
+		} else {
+			log.Printf("TakeToken: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	userId, err = strconv.Atoi(val)
+	if err != nil {
+		log.Printf("TakeToken: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	exists = true
+	return
+}
+
+// GetToken returns the user of the token and keeps it, for tokens that allow a few attempts
+func (t *TokenRepo) GetToken(kind string, tokenHash string) (userId int, exists bool, err error) {
+	userId, err = t.rdb.Get(t.ctx, tokenKey(kind, tokenHash)).Int()
+	if err != nil {
+		if err == redis.Nil {
+			err = nil
+		} else {
+			log.Printf("GetToken: %v", err)
+			err = models.ErrServerError
+		}
+		return
+	}
+	exists = true
+	return
+}
+
+func tokenKey(kind string, tokenHash string) string {
+	return "token:" + kind + ":" + tokenHash
+}
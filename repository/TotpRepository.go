@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+	"toyStore/models"
+)
+
+// TotpRepository keeps the 2FA secret in Users and the hashes of the recovery codes
+type TotpRepository interface {
+	SetTotpSecret(userId int, secret string) (err error)
+	EnableTotp(userId int, codeHashes []string) (err error)
+	DisableTotp(userId int) (err error)
+	UseTotpStep(userId int, step int64) (ok bool, err error)
+	ReplaceRecoveryCodes(userId int, codeHashes []string) (err error)
+	UseRecoveryCode(userId int, codeHash string) (ok bool, err error)
+}
+
+type TotpRepo struct {
+	db *sql.DB
+}
+
+func NewTotpRepository(conn *sql.DB) (TotpRepository, error) {
+	if conn == nil {
+		return nil, errors.New("conn must be non-nil")
+	}
+	err := conn.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return &TotpRepo{
+		db: conn,
+	}, nil
+}
+
+// SetTotpSecret saves a new secret that is not used until EnableTotp
+func (t *TotpRepo) SetTotpSecret(userId int, secret string) (err error) {
+	res, e := t.db.Exec("UPDATE Users SET TotpSecret = $1, TotpEnabled = false, TotpLastStep = 0 WHERE Id = $2 AND DeletedAt IS NULL", secret, userId)
+	if e != nil {
+		log.Printf("SetTotpSecret: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+func (t *TotpRepo) EnableTotp(userId int, codeHashes []string) (err error) {
+	tx, e := t.db.Begin()
+	if e != nil {
+		log.Printf("EnableTotp[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE Users SET TotpEnabled = true WHERE Id = $1", userId)
+	if err != nil {
+		log.Printf("EnableTotp[2]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	err = replaceRecoveryCodes(tx, userId, codeHashes)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("EnableTotp[3]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func (t *TotpRepo) DisableTotp(userId int) (err error) {
+	tx, e := t.db.Begin()
+	if e != nil {
+		log.Printf("DisableTotp[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec("UPDATE Users SET TotpSecret = '', TotpEnabled = false, TotpLastStep = 0 WHERE Id = $1", userId)
+	if err != nil {
+		log.Printf("DisableTotp[2]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	err = replaceRecoveryCodes(tx, userId, nil)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("DisableTotp[3]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// UseTotpStep remembers the time step of an accepted code, a code of the same or an earlier step
+// is refused, so an intercepted code can not be used again
+func (t *TotpRepo) UseTotpStep(userId int, step int64) (ok bool, err error) {
+	res, e := t.db.Exec("UPDATE Users SET TotpLastStep = $1 WHERE Id = $2 AND TotpLastStep < $1", step, userId)
+	if e != nil {
+		log.Printf("UseTotpStep: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	n, _ := res.RowsAffected()
+	ok = n > 0
+	return
+}
+
+func (t *TotpRepo) ReplaceRecoveryCodes(userId int, codeHashes []string) (err error) {
+	tx, e := t.db.Begin()
+	if e != nil {
+		log.Printf("ReplaceRecoveryCodes[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(tx, userId, codeHashes)
+	if err != nil {
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("ReplaceRecoveryCodes[2]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// UseRecoveryCode marks an unused code used, every code works once
+func (t *TotpRepo) UseRecoveryCode(userId int, codeHash string) (ok bool, err error) {
+	res, e := t.db.Exec("UPDATE RecoveryCodes SET UsedAt = $1 WHERE UserId = $2 AND CodeHash = $3 AND UsedAt IS NULL", time.Now().UTC(), userId, codeHash)
+	if e != nil {
+		log.Printf("UseRecoveryCode: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	n, _ := res.RowsAffected()
+	ok = n > 0
+	return
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userId int, codeHashes []string) (err error) {
+	_, err = tx.Exec("DELETE FROM RecoveryCodes WHERE UserId = $1", userId)
+	if err != nil {
+		log.Printf("replaceRecoveryCodes[1]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	for _, v := range codeHashes {
+		_, err = tx.Exec("INSERT INTO RecoveryCodes (UserId, CodeHash) VALUES ($1, $2)", userId, v)
+		if err != nil {
+			log.Printf("replaceRecoveryCodes[2]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+	}
+	return
+}
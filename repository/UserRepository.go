@@ -4,19 +4,29 @@ import (
 	"database/sql"
 	"errors"
 	"log"
+	"strconv"
+	"time"
 
 	"toyStore/models"
 
+	"github.com/lib/pq"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type UserRepository interface {
 	GetUserById(id int) (models.User_db, bool, error)
 	GetUserByName(name string) (models.User_db, bool, error)
+	GetUserByEmail(email string) (models.User_db, bool, error)
 	EncryptPassword(userPass string) (hashedPassword string, err error)
 	VerifyPassword(hashedPassword string, sentPassword string) bool
 	UpdatePassword(userId int, newPassword string) error
 	AddNewUser(uModel models.User_db) (newUserId int, err error)
+	SearchUsers(data models.UserSearchData) (users []models.UserInfo, total int, err error)
+	SetUserRole(userId int, role string) (err error)
+	SetUserBlocked(userId int, blocked bool) (err error)
+	AnonymizeUser(userId int) (err error)
+	UpdateProfile(uModel models.User_db) (err error)
+	SetEmailVerified(userId int, email string) (err error)
 }
 
 type UserRepo struct {
@@ -36,9 +46,28 @@ func NewUserRepository(conn *sql.DB) (UserRepository, error) {
 	}, nil
 }
 
+const userColumns = "Id, Nickname, Password, Role, Email, EmailVerifiedAt, DisplayName, Phone, BirthDate, TotpSecret, TotpEnabled, Blocked, CreatedAt, DeletedAt"
+
+func scanUser(row interface{ Scan(...any) error }, u *models.User_db) error {
+	var email sql.NullString
+	var verifiedAt, birthDate, deletedAt sql.NullTime
+	err := row.Scan(&u.Id, &u.Nickname, &u.Password, &u.Role, &email, &verifiedAt, &u.DisplayName, &u.Phone, &birthDate, &u.TotpSecret, &u.TotpEnabled, &u.Blocked, &u.CreatedAt, &deletedAt)
+	u.Email = email.String
+	if verifiedAt.Valid {
+		u.EmailVerifiedAt = &verifiedAt.Time
+	}
+	if birthDate.Valid {
+		u.BirthDate = &birthDate.Time
+	}
+	if deletedAt.Valid {
+		u.DeletedAt = &deletedAt.Time
+	}
+	return err
+}
+
 func (u *UserRepo) GetUserById(id int) (uModel models.User_db, exists bool, err error) {
-	row := u.db.QueryRow("select Id, Nickname, Password, Role from Users where Id = $1", id)
-	err = row.Scan(&uModel.Id, &uModel.Nickname, &uModel.Password, &uModel.Role)
+	row := u.db.QueryRow("select "+userColumns+" from Users where Id = $1", id)
+	err = scanUser(row, &uModel)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			err = nil
@@ -46,14 +75,15 @@ func (u *UserRepo) GetUserById(id int) (uModel models.User_db, exists bool, err
 		}
 		log.Printf("GetUserById: %v", err)
 		err = models.ErrServerError
+		return
 	}
 	exists = true
 	return
 }
 
 func (u *UserRepo) GetUserByName(name string) (uModel models.User_db, exists bool, err error) {
-	row := u.db.QueryRow("select Id, Nickname, Password, Role from Users where Nickname = $1", name)
-	err = row.Scan(&uModel.Id, &uModel.Nickname, &uModel.Password, &uModel.Role)
+	row := u.db.QueryRow("select "+userColumns+" from Users where Nickname = $1", name)
+	err = scanUser(row, &uModel)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			err = nil
@@ -61,6 +91,23 @@ func (u *UserRepo) GetUserByName(name string) (uModel models.User_db, exists boo
 		}
 		log.Printf("GetUserByName: %v", err)
 		err = models.ErrServerError
+		return
+	}
+	exists = true
+	return
+}
+
+func (u *UserRepo) GetUserByEmail(email string) (uModel models.User_db, exists bool, err error) {
+	row := u.db.QueryRow("select "+userColumns+" from Users where Email = $1", email)
+	err = scanUser(row, &uModel)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+			return
+		}
+		log.Printf("GetUserByEmail: %v", err)
+		err = models.ErrServerError
+		return
 	}
 	exists = true
 	return
@@ -87,7 +134,9 @@ func (u *UserRepo) VerifyPassword(hashedPassword string, sentPassword string) bo
 }
 
 func (u *UserRepo) AddNewUser(uModel models.User_db) (newUserId int, err error) {
-	err = u.db.QueryRow("INSERT INTO Users (Nickname, Password, Role) VALUES ($1, $2, $3) RETURNING id;", uModel.Nickname, uModel.Password, uModel.Role).Scan(newUserId)
+	// the email is optional, NULL keeps the unique index free for users without one
+	email := sql.NullString{String: uModel.Email, Valid: uModel.Email != ""}
+	err = u.db.QueryRow("INSERT INTO Users (Nickname, Password, Role, Email) VALUES ($1, $2, $3, $4) RETURNING id;", uModel.Nickname, uModel.Password, uModel.Role, email).Scan(&newUserId)
 	if err != nil {
 		log.Printf("AddNewUser: %v", err)
 		err = models.ErrServerError
@@ -103,3 +152,154 @@ func (u *UserRepo) UpdatePassword(userId int, newPassword string) error {
 	}
 	return err
 }
+
+// SearchUsers finds users by a part of the name, role and block status, a page of users and the total count are returned
+func (u *UserRepo) SearchUsers(data models.UserSearchData) (users []models.UserInfo, total int, err error) {
+	where := "WHERE true "
+	var queryParams []any
+	if data.Query != "" {
+		queryParams = append(queryParams, "%"+data.Query+"%")
+		where = where + "AND Nickname ILIKE $" + strconv.Itoa(len(queryParams)) + " "
+	}
+	if data.Role != nil {
+		queryParams = append(queryParams, *data.Role)
+		where = where + "AND Role = $" + strconv.Itoa(len(queryParams)) + " "
+	}
+	if data.Blocked != nil {
+		queryParams = append(queryParams, *data.Blocked)
+		where = where + "AND Blocked = $" + strconv.Itoa(len(queryParams)) + " "
+	}
+	err = u.db.QueryRow("SELECT COUNT(*) FROM Users "+where, queryParams...).Scan(&total)
+	if err != nil {
+		log.Printf("SearchUsers[1]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+
+	queryParams = append(queryParams, data.PerPage, (data.Page-1)*data.PerPage)
+	query := "SELECT " + userColumns + " FROM Users " + where + "ORDER BY Id LIMIT $" + strconv.Itoa(len(queryParams)-1) + " OFFSET $" + strconv.Itoa(len(queryParams))
+	rows, e := u.db.Query(query, queryParams...)
+	if e != nil {
+		log.Printf("SearchUsers[2]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer rows.Close()
+	users = []models.UserInfo{}
+	for rows.Next() {
+		var uModel models.User_db
+		err = scanUser(rows, &uModel)
+		if err != nil {
+			log.Printf("SearchUsers[3]: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		users = append(users, uModel.Info())
+	}
+	return
+}
+
+// SetUserRole changes the role, deleted users can not be changed
+func (u *UserRepo) SetUserRole(userId int, role string) (err error) {
+	res, e := u.db.Exec("UPDATE Users SET Role = $1 WHERE Id = $2 AND DeletedAt IS NULL", role, userId)
+	if e != nil {
+		log.Printf("SetUserRole: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+func (u *UserRepo) SetUserBlocked(userId int, blocked bool) (err error) {
+	res, e := u.db.Exec("UPDATE Users SET Blocked = $1 WHERE Id = $2 AND DeletedAt IS NULL", blocked, userId)
+	if e != nil {
+		log.Printf("SetUserBlocked: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+// AnonymizeUser deletes the personal data of the user but keeps the row, so the orders
+// of the user are not removed by ON DELETE CASCADE. The user can not sign in any more.
+func (u *UserRepo) AnonymizeUser(userId int) (err error) {
+	tx, e := u.db.Begin()
+	if e != nil {
+		log.Printf("AnonymizeUser[1]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	defer tx.Rollback()
+
+	res, e := tx.Exec("UPDATE Users SET Nickname = 'deleted-' || Id, Password = '', Role = 'user', Email = NULL, EmailVerifiedAt = NULL, DisplayName = '', Phone = '', BirthDate = NULL, TotpSecret = '', TotpEnabled = false, Blocked = true, DeletedAt = $1 WHERE Id = $2 AND DeletedAt IS NULL", time.Now().UTC(), userId)
+	if e != nil {
+		log.Printf("AnonymizeUser[2]: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+		return
+	}
+	// the addresses hold names and phones, orders keep their own copy of the shipping address
+	_, err = tx.Exec("DELETE FROM Addresses WHERE UserId = $1", userId)
+	if err != nil {
+		log.Printf("AnonymizeUser[3]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	_, err = tx.Exec("DELETE FROM RecoveryCodes WHERE UserId = $1", userId)
+	if err != nil {
+		log.Printf("AnonymizeUser[4]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("AnonymizeUser[5]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// UpdateProfile saves the email and the personal data, a changed email is saved unverified by the service
+func (u *UserRepo) UpdateProfile(uModel models.User_db) (err error) {
+	email := sql.NullString{String: uModel.Email, Valid: uModel.Email != ""}
+	res, e := u.db.Exec("UPDATE Users SET Email = $1, EmailVerifiedAt = $2, DisplayName = $3, Phone = $4, BirthDate = $5 WHERE Id = $6 AND DeletedAt IS NULL",
+		email, uModel.EmailVerifiedAt, uModel.DisplayName, uModel.Phone, uModel.BirthDate, uModel.Id)
+	if e != nil {
+		if pqErr, ok := e.(*pq.Error); ok && pqErr.Code == "23505" {
+			log.Printf("UpdateProfile: email is already used")
+			err = models.ErrNotAllowed
+			return
+		}
+		log.Printf("UpdateProfile: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+// SetEmailVerified marks the email verified only while the user still has this email
+func (u *UserRepo) SetEmailVerified(userId int, email string) (err error) {
+	res, e := u.db.Exec("UPDATE Users SET EmailVerifiedAt = COALESCE(EmailVerifiedAt, $1) WHERE Id = $2 AND Email = $3 AND DeletedAt IS NULL", time.Now().UTC(), userId, email)
+	if e != nil {
+		log.Printf("SetEmailVerified: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		log.Printf("SetEmailVerified: user %v has another email", userId)
+		err = models.ErrBadRequest
+	}
+	return
+}
@@ -1,8 +1,53 @@
+CREATE TABLE roles (
+    Name TEXT PRIMARY KEY,
+    Description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE rolePermissions (
+    Role TEXT NOT NULL,
+    Permission TEXT NOT NULL,
+    PRIMARY KEY (Role, Permission),
+    CONSTRAINT FK_RolePermissions_Roles FOREIGN KEY (Role) REFERENCES Roles (Name) ON DELETE CASCADE
+);
+
+-- built-in roles, the application needs them to sign users up and to be managed
+INSERT INTO roles (Name, Description) VALUES
+('user', 'Customer'),
+('manager', 'Store manager with every permission');
+
+INSERT INTO rolePermissions (Role, Permission) VALUES
+('manager', 'catalog:write'),
+('manager', 'promotions:manage'),
+('manager', 'orders:read'),
+('manager', 'orders:manage'),
+('manager', 'payments:refund'),
+('manager', 'settings:manage'),
+('manager', 'users:manage'),
+('manager', 'roles:manage'),
+('manager', 'api_keys:manage');
+
 CREATE TABLE users (
     Id SERIAL PRIMARY KEY,
     Nickname TEXT NOT NULL UNIQUE,
     Password TEXT NOT NULL,
-    Role TEXT DEFAULT 'user'
+    Role TEXT NOT NULL DEFAULT 'user',
+    Email TEXT UNIQUE,
+    EmailVerifiedAt TIMESTAMP,
+    DisplayName TEXT NOT NULL DEFAULT '',
+    Phone TEXT NOT NULL DEFAULT '',
+    BirthDate DATE,
+    TotpSecret TEXT NOT NULL DEFAULT '',
+    TotpEnabled BOOLEAN NOT NULL DEFAULT false,
+    TotpLastStep BIGINT NOT NULL DEFAULT 0,
+    Blocked BOOLEAN NOT NULL DEFAULT false,
+    CreatedAt TIMESTAMP NOT NULL DEFAULT (now() AT TIME ZONE 'utc'),
+    DeletedAt TIMESTAMP,
+    CONSTRAINT FK_Users_Roles FOREIGN KEY (Role) REFERENCES Roles (Name)
+);
+
+CREATE TABLE taxClasses (
+    Id SERIAL PRIMARY KEY,
+    Name TEXT NOT NULL UNIQUE
 );
 
 CREATE TABLE products (
@@ -12,14 +57,35 @@ CREATE TABLE products (
     Quantity INTEGER NOT NULL,
     Price NUMERIC(10, 2) NOT NULL,
     Description TEXT,
-    Available BOOLEAN NOT NULL
+    Available BOOLEAN NOT NULL,
+    Weight INTEGER NOT NULL DEFAULT 0,
+    TaxClassId INTEGER,
+    CONSTRAINT FK_Products_TaxClasses FOREIGN KEY (TaxClassId) REFERENCES TaxClasses (Id) ON DELETE SET NULL
 );
 
+CREATE SEQUENCE invoice_numbers;
+
 CREATE TABLE orders (
     Id SERIAL PRIMARY KEY,
     UserId INTEGER NOT NULL,
     Date TIMESTAMP NOT NULL,
     TotalPrice NUMERIC(10, 2),
+    Discount NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    Tax NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    TaxRegion TEXT,
+    Currency TEXT NOT NULL DEFAULT 'RUB',
+    ExchangeRate NUMERIC(18, 6) NOT NULL DEFAULT 1,
+    DeliveryMethodId INTEGER,
+    DeliveryMethod TEXT,
+    ShippingPrice NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    ShippingAddress JSONB,
+    RefundedAmount NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    Note TEXT NOT NULL DEFAULT '',
+    GiftMessage TEXT NOT NULL DEFAULT '',
+    GiftWrap BOOLEAN NOT NULL DEFAULT FALSE,
+    GiftWrapPrice NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    InvoiceNumber INTEGER UNIQUE,
+    InvoiceDate TIMESTAMP,
     Status TEXT,
     CONSTRAINT FK_Orders_Users_UserId FOREIGN KEY (UserId) REFERENCES Users (Id) ON DELETE CASCADE
 );
@@ -27,7 +93,9 @@ CREATE TABLE orders (
 CREATE TABLE categories (
     Id SERIAL PRIMARY KEY,
     Name TEXT NOT NULL,
-    ParentId INTEGER
+    ParentId INTEGER,
+    TaxClassId INTEGER,
+    CONSTRAINT FK_Categories_TaxClasses FOREIGN KEY (TaxClassId) REFERENCES TaxClasses (Id) ON DELETE SET NULL
 );
 
 CREATE TABLE attributes (
@@ -58,9 +126,224 @@ CREATE TABLE ordersProducts (
     ProductId INTEGER NOT NULL,
     Quantity INTEGER NOT NULL,
     Price NUMERIC(10, 2) NOT NULL,
+    Discount NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    Tax NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    TaxIncluded BOOLEAN NOT NULL DEFAULT true,
     CONSTRAINT FK_OrdersProducts_Orders FOREIGN KEY (OrderId) REFERENCES Orders (Id) ON DELETE CASCADE,
     CONSTRAINT FK_OrdersProducts_Products FOREIGN KEY (ProductId) REFERENCES Products (Id) ON DELETE CASCADE
 );
 
+CREATE TABLE promotions (
+    Id SERIAL PRIMARY KEY,
+    Name TEXT NOT NULL,
+    Type TEXT NOT NULL,
+    CategoryId INTEGER,
+    ProductIds INTEGER[],
+    BuyQuantity INTEGER NOT NULL DEFAULT 0,
+    FreeQuantity INTEGER NOT NULL DEFAULT 0,
+    MinTotal NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    DiscountPercent INTEGER NOT NULL DEFAULT 0,
+    Active BOOLEAN NOT NULL DEFAULT true,
+    CONSTRAINT FK_Promotions_Categories FOREIGN KEY (CategoryId) REFERENCES Categories (Id) ON DELETE CASCADE
+);
+
+CREATE TABLE productPrices (
+    Id SERIAL PRIMARY KEY,
+    ProductId INTEGER NOT NULL,
+    Price NUMERIC(10, 2) NOT NULL,
+    Kind TEXT NOT NULL,
+    StartDate TIMESTAMP NOT NULL,
+    EndDate TIMESTAMP,
+    CreatedAt TIMESTAMP NOT NULL,
+    CONSTRAINT FK_ProductPrices_Products FOREIGN KEY (ProductId) REFERENCES Products (Id) ON DELETE CASCADE
+);
+
+CREATE TABLE exchangeRates (
+    Currency TEXT PRIMARY KEY,
+    Rate NUMERIC(18, 6) NOT NULL,
+    UpdatedAt TIMESTAMP NOT NULL
+);
+
+CREATE TABLE taxRates (
+    Id SERIAL PRIMARY KEY,
+    TaxClassId INTEGER NOT NULL,
+    Region TEXT NOT NULL,
+    Rate NUMERIC(5, 2) NOT NULL,
+    Inclusive BOOLEAN NOT NULL DEFAULT true,
+    CONSTRAINT UQ_TaxRates_Class_Region UNIQUE (TaxClassId, Region),
+    CONSTRAINT FK_TaxRates_TaxClasses FOREIGN KEY (TaxClassId) REFERENCES TaxClasses (Id) ON DELETE CASCADE
+);
+
+CREATE TABLE addresses (
+    Id SERIAL PRIMARY KEY,
+    UserId INTEGER NOT NULL,
+    Recipient TEXT NOT NULL,
+    Phone TEXT NOT NULL DEFAULT '',
+    Country TEXT NOT NULL,
+    Region TEXT NOT NULL DEFAULT '',
+    City TEXT NOT NULL,
+    Street TEXT NOT NULL,
+    PostalCode TEXT NOT NULL DEFAULT '',
+    CONSTRAINT FK_Addresses_Users FOREIGN KEY (UserId) REFERENCES Users (Id) ON DELETE CASCADE
+);
+
+CREATE TABLE deliveryMethods (
+    Id SERIAL PRIMARY KEY,
+    Name TEXT NOT NULL,
+    Type TEXT NOT NULL,
+    Price NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    PricePerKg NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    FreeThreshold NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    Active BOOLEAN NOT NULL DEFAULT true
+);
+
+CREATE TABLE payments (
+    Id SERIAL PRIMARY KEY,
+    OrderId INTEGER NOT NULL,
+    Provider TEXT NOT NULL,
+    ProviderPaymentId TEXT NOT NULL,
+    Amount NUMERIC(10, 2) NOT NULL,
+    Currency TEXT NOT NULL,
+    Status TEXT NOT NULL,
+    ConfirmationUrl TEXT NOT NULL DEFAULT '',
+    CreatedAt TIMESTAMP NOT NULL,
+    UpdatedAt TIMESTAMP NOT NULL,
+    CONSTRAINT UQ_Payments_Provider_PaymentId UNIQUE (Provider, ProviderPaymentId),
+    CONSTRAINT FK_Payments_Orders FOREIGN KEY (OrderId) REFERENCES Orders (Id) ON DELETE CASCADE
+);
+
+CREATE TABLE paymentEvents (
+    Provider TEXT NOT NULL,
+    EventId TEXT NOT NULL,
+    ProviderPaymentId TEXT NOT NULL,
+    Status TEXT NOT NULL,
+    ReceivedAt TIMESTAMP NOT NULL,
+    PRIMARY KEY (Provider, EventId)
+);
+
+CREATE TABLE returns (
+    Id SERIAL PRIMARY KEY,
+    OrderId INTEGER NOT NULL,
+    UserId INTEGER NOT NULL,
+    Status TEXT NOT NULL,
+    Reason TEXT NOT NULL DEFAULT '',
+    Comment TEXT NOT NULL DEFAULT '',
+    CreatedAt TIMESTAMP NOT NULL,
+    UpdatedAt TIMESTAMP NOT NULL,
+    CONSTRAINT FK_Returns_Orders FOREIGN KEY (OrderId) REFERENCES Orders (Id) ON DELETE CASCADE,
+    CONSTRAINT FK_Returns_Users FOREIGN KEY (UserId) REFERENCES Users (Id) ON DELETE CASCADE
+);
+
+CREATE TABLE returnItems (
+    Id SERIAL PRIMARY KEY,
+    ReturnId INTEGER NOT NULL,
+    OrderItemId INTEGER NOT NULL,
+    ProductId INTEGER NOT NULL,
+    Quantity INTEGER NOT NULL,
+    ReceivedQuantity INTEGER NOT NULL DEFAULT 0,
+    CONSTRAINT FK_ReturnItems_Returns FOREIGN KEY (ReturnId) REFERENCES Returns (Id) ON DELETE CASCADE,
+    CONSTRAINT FK_ReturnItems_OrdersProducts FOREIGN KEY (OrderItemId) REFERENCES OrdersProducts (Id) ON DELETE CASCADE
+);
+
+CREATE TABLE refunds (
+    Id SERIAL PRIMARY KEY,
+    OrderId INTEGER NOT NULL,
+    PaymentId INTEGER NOT NULL,
+    ReturnId INTEGER,
+    Amount NUMERIC(10, 2) NOT NULL,
+    CurrencyAmount NUMERIC(10, 2) NOT NULL,
+    Currency TEXT NOT NULL,
+    ProviderRefundId TEXT NOT NULL DEFAULT '',
+    Status TEXT NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL,
+    CONSTRAINT FK_Refunds_Orders FOREIGN KEY (OrderId) REFERENCES Orders (Id) ON DELETE CASCADE,
+    CONSTRAINT FK_Refunds_Payments FOREIGN KEY (PaymentId) REFERENCES Payments (Id) ON DELETE CASCADE,
+    CONSTRAINT FK_Refunds_Returns FOREIGN KEY (ReturnId) REFERENCES Returns (Id) ON DELETE SET NULL
+);
+
+CREATE TABLE orderStatusHistory (
+    Id SERIAL PRIMARY KEY,
+    OrderId INTEGER NOT NULL,
+    OldStatus TEXT NOT NULL,
+    NewStatus TEXT NOT NULL,
+    Reason TEXT NOT NULL DEFAULT '',
+    ChangedAt TIMESTAMP NOT NULL,
+    CONSTRAINT FK_OrderStatusHistory_Orders FOREIGN KEY (OrderId) REFERENCES Orders (Id) ON DELETE CASCADE
+);
+
+CREATE TABLE orderChanges (
+    Id SERIAL PRIMARY KEY,
+    OrderId INTEGER NOT NULL,
+    ItemId INTEGER NOT NULL,
+    Action TEXT NOT NULL,
+    OldValue TEXT NOT NULL DEFAULT '',
+    NewValue TEXT NOT NULL DEFAULT '',
+    Reason TEXT NOT NULL DEFAULT '',
+    ChangedBy INTEGER NOT NULL,
+    ChangedAt TIMESTAMP NOT NULL,
+    CONSTRAINT FK_OrderChanges_Orders FOREIGN KEY (OrderId) REFERENCES Orders (Id) ON DELETE CASCADE
+);
+
+CREATE TABLE orderNotes (
+    Id SERIAL PRIMARY KEY,
+    OrderId INTEGER NOT NULL,
+    AuthorId INTEGER NOT NULL,
+    Text TEXT NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL,
+    CONSTRAINT FK_OrderNotes_Orders FOREIGN KEY (OrderId) REFERENCES Orders (Id) ON DELETE CASCADE
+);
+
+CREATE TABLE carriers (
+    Id SERIAL PRIMARY KEY,
+    Name TEXT NOT NULL,
+    TrackingUrlTemplate TEXT NOT NULL DEFAULT '',
+    Active BOOLEAN NOT NULL DEFAULT true
+);
 
+CREATE TABLE shipments (
+    Id SERIAL PRIMARY KEY,
+    OrderId INTEGER NOT NULL,
+    CarrierId INTEGER NOT NULL,
+    TrackingNumber TEXT NOT NULL,
+    ShippedAt TIMESTAMP NOT NULL,
+    CONSTRAINT FK_Shipments_Orders FOREIGN KEY (OrderId) REFERENCES Orders (Id) ON DELETE CASCADE,
+    CONSTRAINT FK_Shipments_Carriers FOREIGN KEY (CarrierId) REFERENCES Carriers (Id)
+);
 
+CREATE TABLE shipmentItems (
+    Id SERIAL PRIMARY KEY,
+    ShipmentId INTEGER NOT NULL,
+    OrderItemId INTEGER NOT NULL,
+    ProductId INTEGER NOT NULL,
+    Quantity INTEGER NOT NULL,
+    CONSTRAINT FK_ShipmentItems_Shipments FOREIGN KEY (ShipmentId) REFERENCES Shipments (Id) ON DELETE CASCADE,
+    CONSTRAINT FK_ShipmentItems_OrdersProducts FOREIGN KEY (OrderItemId) REFERENCES OrdersProducts (Id) ON DELETE CASCADE
+);
+
+CREATE TABLE recoveryCodes (
+    Id SERIAL PRIMARY KEY,
+    UserId INTEGER NOT NULL,
+    CodeHash TEXT NOT NULL,
+    UsedAt TIMESTAMP,
+    CONSTRAINT FK_RecoveryCodes_Users FOREIGN KEY (UserId) REFERENCES Users (Id) ON DELETE CASCADE
+);
+
+CREATE TABLE apiKeys (
+    Id SERIAL PRIMARY KEY,
+    Name TEXT NOT NULL,
+    Prefix TEXT NOT NULL,
+    KeyHash TEXT NOT NULL UNIQUE,
+    CreatedBy INTEGER NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL,
+    ExpiresAt TIMESTAMP,
+    LastUsedAt TIMESTAMP,
+    RevokedAt TIMESTAMP,
+    CONSTRAINT FK_ApiKeys_Users FOREIGN KEY (CreatedBy) REFERENCES Users (Id)
+);
+
+CREATE TABLE apiKeyScopes (
+    ApiKeyId INTEGER NOT NULL,
+    Permission TEXT NOT NULL,
+    PRIMARY KEY (ApiKeyId, Permission),
+    CONSTRAINT FK_ApiKeyScopes_ApiKeys FOREIGN KEY (ApiKeyId) REFERENCES ApiKeys (Id) ON DELETE CASCADE
+);
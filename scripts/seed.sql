@@ -1,19 +1,27 @@
-INSERT INTO public.Users (Nickname, Password, Role) VALUES 
-('TestUser1', '$2a$08$LwOq/vPD0AmKOAUBPw9GtOteaU92j3.LZvdawL.g7.YdiJzZNnVbe', 'manager'), 
-('TestUser2', '$2a$08$qtJCXo37l37dJhicvEHWJe/4Ct.WA/izS6ZVgo0C8QFdIkMQ8lNDe', 'user'), 
-('TestUser3', '$2a$08$dVgIwUHbLQ9eDR79hlebh.4T6f.lViFBZNsIgK64qF8qqYxyNZKxG', 'user'), 
-('TestUser4', '$2a$08$UGNRe1l1MW6UKiHi/Ra8pu8YVwE6LDozFntng4HEShrWUeL4OAXAa', 'user'), 
-('TestUser5', '$2a$08$ea8mNDmjxQ0A0V19ZP4knuBdQ3PvIVwd8OgYJ7D36l1hZBhbutLem', 'user'), 
-('TestUser6', '$2a$08$MPdF72QxxPg8xnvAjhtxbe4oSjIMpVA8m7vhcS1otw3.wBQwAxm66', 'user'), 
-('TestUser7', '$2a$08$4uyGsfq2boVutXhXhbYz3e1LGlLgbVCkKJQttSd/dY11hhC1AYHS6', 'user'), 
-('TestUser8', '$2a$08$ERlvMLPGH6AgHgR2pIMFY.mYXRkmjqwGjwsAS8I.Se5KgfTrVuixq', 'user'), 
-('TestUser9', '$2a$08$U7Rc9Ges5IKWOGTKISsaiOWKNp6eUlyvsITKfbE5BaOzy9XGwi40i', 'user'), 
-('TestUser10', '$2a$08$i.2eVJ9dIkzsmZbKUsdaY.UX06ALF1d..jNPbGHkzhFJGleNxPeAe', 'user'), 
-('TestUser11', '$2a$08$ykTOvXH0jQZW.cssUPAz.OKKSLJ2A21WaLnQ8rM5.RLfMJD/af1w2', 'user'), 
-('TestUser12', '$2a$08$gE0zPLNPIz4La.Iz/OKr.egrlqCG69.jv8VO1BfZevi7ftosvML5m', 'user'), 
-('TestUser13', '$2a$08$Q7v6jF0hZQ23nAxLHjjjh.ia3/6bkzsKvnsIFhhyUUwgwoclcwH6C', 'user'), 
-('TestUser14', '$2a$08$./yyDNmYo5ni6Bl0kieK7uIT0cj1kN.AZYl8mRtNlxJR4.dyNrW8i', 'user'), 
-('TestUser15', '$2a$08$WQc.gulZeSErJBni5RLIMufOhJtn50.WA6DpnxYfEsSFDrPrcDTl.', 'user');
+INSERT INTO public.Roles (Name, Description) VALUES
+('warehouse', 'Warehouse staff: confirms, edits and ships orders, handles returns');
+
+INSERT INTO public.RolePermissions (Role, Permission) VALUES
+('warehouse', 'orders:read'),
+('warehouse', 'orders:manage');
+
+-- the emails are verified, so the test users can place orders right away
+INSERT INTO public.Users (Nickname, Password, Role, Email, EmailVerifiedAt) VALUES 
+('TestUser1', '$2a$08$LwOq/vPD0AmKOAUBPw9GtOteaU92j3.LZvdawL.g7.YdiJzZNnVbe', 'manager', 'testuser1@example.com', now()), 
+('TestUser2', '$2a$08$qtJCXo37l37dJhicvEHWJe/4Ct.WA/izS6ZVgo0C8QFdIkMQ8lNDe', 'user', 'testuser2@example.com', now()), 
+('TestUser3', '$2a$08$dVgIwUHbLQ9eDR79hlebh.4T6f.lViFBZNsIgK64qF8qqYxyNZKxG', 'user', 'testuser3@example.com', now()), 
+('TestUser4', '$2a$08$UGNRe1l1MW6UKiHi/Ra8pu8YVwE6LDozFntng4HEShrWUeL4OAXAa', 'user', 'testuser4@example.com', now()), 
+('TestUser5', '$2a$08$ea8mNDmjxQ0A0V19ZP4knuBdQ3PvIVwd8OgYJ7D36l1hZBhbutLem', 'user', 'testuser5@example.com', now()), 
+('TestUser6', '$2a$08$MPdF72QxxPg8xnvAjhtxbe4oSjIMpVA8m7vhcS1otw3.wBQwAxm66', 'user', 'testuser6@example.com', now()), 
+('TestUser7', '$2a$08$4uyGsfq2boVutXhXhbYz3e1LGlLgbVCkKJQttSd/dY11hhC1AYHS6', 'user', 'testuser7@example.com', now()), 
+('TestUser8', '$2a$08$ERlvMLPGH6AgHgR2pIMFY.mYXRkmjqwGjwsAS8I.Se5KgfTrVuixq', 'user', 'testuser8@example.com', now()), 
+('TestUser9', '$2a$08$U7Rc9Ges5IKWOGTKISsaiOWKNp6eUlyvsITKfbE5BaOzy9XGwi40i', 'user', 'testuser9@example.com', now()), 
+('TestUser10', '$2a$08$i.2eVJ9dIkzsmZbKUsdaY.UX06ALF1d..jNPbGHkzhFJGleNxPeAe', 'user', 'testuser10@example.com', now()), 
+('TestUser11', '$2a$08$ykTOvXH0jQZW.cssUPAz.OKKSLJ2A21WaLnQ8rM5.RLfMJD/af1w2', 'user', 'testuser11@example.com', now()), 
+('TestUser12', '$2a$08$gE0zPLNPIz4La.Iz/OKr.egrlqCG69.jv8VO1BfZevi7ftosvML5m', 'user', 'testuser12@example.com', now()), 
+('TestUser13', '$2a$08$Q7v6jF0hZQ23nAxLHjjjh.ia3/6bkzsKvnsIFhhyUUwgwoclcwH6C', 'user', 'testuser13@example.com', now()), 
+('TestUser14', '$2a$08$./yyDNmYo5ni6Bl0kieK7uIT0cj1kN.AZYl8mRtNlxJR4.dyNrW8i', 'user', 'testuser14@example.com', now()), 
+('TestUser15', '$2a$08$WQc.gulZeSErJBni5RLIMufOhJtn50.WA6DpnxYfEsSFDrPrcDTl.', 'user', 'testuser15@example.com', now());
 
 INSERT INTO public.products (Available, Description,  Manufacturer, Name, Price, Quantity) VALUES 
 (true, 'Description of the product',  'LEGO', 'LEGO Ninjago Water Dragon', 29890, 50),
@@ -71,6 +79,9 @@ INSERT INTO public.products (Available, Description,  Manufacturer, Name, Price,
 (true, 'Description of the product',  'Aurora', 'Big Pink Teddy Bear', 13790, 30),
 (true, 'Description of the product',  'Test Manufacturer', 'Test Prod', 10500.99, 100);
 
+INSERT INTO public.ProductPrices (ProductId, Price, Kind, StartDate, CreatedAt)
+SELECT Id, Price, 'regular', '2023-01-01 00:00:00', '2023-01-01 00:00:00' FROM public.products;
+
 INSERT INTO public.attributes (name) VALUES 
 ('Constructor type'),
 ('Country of Origin'),
@@ -230,6 +241,30 @@ INSERT INTO public.ProductsAttributes (ProductId,  AttributeId,  Value) VALUES
 ('kkjmikoj', 5);
 
 
+INSERT INTO public.TaxClasses (Name) VALUES
+('Standard'),
+('Books and educational');
+
+INSERT INTO public.TaxRates (TaxClassId, Region, Rate, Inclusive) VALUES
+(1, 'RU', 20, true),
+(1, 'KZ', 12, true),
+(2, 'RU', 10, true),
+(2, 'KZ', 12, true);
+
+UPDATE public.categories SET TaxClassId = 1;
+UPDATE public.categories SET TaxClassId = 2 WHERE Id IN (11, 12);
+
+INSERT INTO public.DeliveryMethods (Name, Type, Price, PricePerKg, FreeThreshold) VALUES
+('Courier', 'flat', 500, 0, 0),
+('Russian Post', 'weight', 250, 60, 0),
+('Courier, free from 10000', 'free_threshold', 400, 0, 10000),
+('Pickup from the store', 'pickup', 0, 0, 0);
+
+INSERT INTO public.Carriers (Name, TrackingUrlTemplate) VALUES
+('Russian Post', 'https://www.pochta.ru/tracking?barcode={tracking}'),
+('CDEK', 'https://www.cdek.ru/ru/tracking?order_id={tracking}'),
+('Store courier', '');
+
 INSERT INTO public.ProductsCategories (ProductId,  CategoryId) VALUES 
 (1, 6), 
 (2, 6), 
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+// jwtHeader is the only header accepted, so a token can not choose another algorithm or "none"
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type accessClaims struct {
+	Subject   string `json:"sub"`
+	SessionId string `json:"sid"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// AccessTokenService issues JWT (HS256) access tokens and opaque refresh tokens for the sessions of
+// bearer tokens. The access token names the session, so revoking the session revokes the tokens.
+type AccessTokenService struct {
+	sr         repository.SessionRepository
+	secret     []byte
+	accessTtl  time.Duration
+	refreshTtl time.Duration
+}
+
+func NewAccessTokenService(sessionRepo repository.SessionRepository, secret string, accessTtl time.Duration, refreshTtl time.Duration) AccessTokenService {
+	return AccessTokenService{
+		sr:         sessionRepo,
+		secret:     []byte(secret),
+		accessTtl:  accessTtl,
+		refreshTtl: refreshTtl,
+	}
+}
+
+// IssueTokens makes a new pair for the session, the session lives as long as the refresh token
+func (tks *AccessTokenService) IssueTokens(sessionId string) (pair models.TokenPair, err error) {
+	userId, _, exists, e := tks.sr.GetUserSessionInfo(sessionId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !exists {
+		err = models.ErrUnautorized
+		return
+	}
+	err = tks.sr.RefreshSession(sessionId, tks.refreshTtl)
+	if err != nil {
+		return
+	}
+	pair.RefreshToken, err = newToken()
+	if err != nil {
+		return
+	}
+	err = tks.sr.SaveRefreshToken(hashToken(pair.RefreshToken), sessionId, tks.refreshTtl)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	pair.AccessToken, err = tks.sign(accessClaims{
+		Subject:   strconv.Itoa(userId),
+		SessionId: sessionId,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(tks.accessTtl).Unix(),
+	})
+	pair.TokenType = "Bearer"
+	pair.ExpiresIn = int(tks.accessTtl.Seconds())
+	return
+}
+
+// Refresh rotates the refresh token. A refresh token that was already used means it was stolen,
+// the whole session is revoked then.
+func (tks *AccessTokenService) Refresh(refreshToken string) (pair models.TokenPair, err error) {
+	sessionId, reused, exists, e := tks.sr.TakeRefreshToken(hashToken(refreshToken), tks.refreshTtl)
+	if e != nil {
+		err = e
+		return
+	}
+	if reused {
+		log.Printf("Refresh: a used refresh token, the session is revoked")
+		err = tks.sr.DeleteSession(sessionId)
+		if err == nil {
+			err = models.ErrUnautorized
+		}
+		return
+	}
+	if !exists {
+		err = models.ErrUnautorized
+		return
+	}
+	pair, err = tks.IssueTokens(sessionId)
+	return
+}
+
+// Revoke signs out the session of the refresh token
+func (tks *AccessTokenService) Revoke(refreshToken string) (err error) {
+	sessionId, _, exists, e := tks.sr.TakeRefreshToken(hashToken(refreshToken), tks.refreshTtl)
+	if e != nil {
+		err = e
+		return
+	}
+	if !exists {
+		err = models.ErrUnautorized
+		return
+	}
+	err = tks.sr.DeleteSession(sessionId)
+	return
+}
+
+// ParseAccessToken checks the signature and the expiry and returns the session of the token
+func (tks *AccessTokenService) ParseAccessToken(token string) (sessionId string, ok bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != jwtHeader {
+		return
+	}
+	sig, e := base64.RawURLEncoding.DecodeString(parts[2])
+	if e != nil || !hmac.Equal(sig, tks.mac(parts[0]+"."+parts[1])) {
+		return
+	}
+	payload, e := base64.RawURLEncoding.DecodeString(parts[1])
+	if e != nil {
+		return
+	}
+	var claims accessClaims
+	if json.Unmarshal(payload, &claims) != nil || time.Now().Unix() >= claims.ExpiresAt {
+		return
+	}
+	if !strings.HasPrefix(claims.SessionId, models.TokenSessionPrefix) {
+		return
+	}
+	sessionId = claims.SessionId
+	ok = true
+	return
+}
+
+func (tks *AccessTokenService) sign(claims accessClaims) (token string, err error) {
+	payload, e := json.Marshal(claims)
+	if e != nil {
+		log.Printf("sign: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	token = jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	token = token + "." + base64.RawURLEncoding.EncodeToString(tks.mac(token))
+	return
+}
+
+func (tks *AccessTokenService) mac(data string) []byte {
+	h := hmac.New(sha256.New, tks.secret)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+// fakeTokenSessionRepo keeps the sessions and the refresh tokens in memory, used tokens are remembered
+// the same way as in redis
+type fakeTokenSessionRepo struct {
+	repository.SessionRepository
+	sessions map[string]int
+	tokens   map[string]string
+	used     map[string]string
+}
+
+func newFakeTokenSessionRepo() *fakeTokenSessionRepo {
+	return &fakeTokenSessionRepo{sessions: map[string]int{}, tokens: map[string]string{}, used: map[string]string{}}
+}
+
+func (f *fakeTokenSessionRepo) GetUserSessionInfo(sessionId string) (userId int, role string, exists bool, err error) {
+	userId, exists = f.sessions[sessionId]
+	return
+}
+
+func (f *fakeTokenSessionRepo) RefreshSession(sessionId string, expirationTime time.Duration) (err error) {
+	return
+}
+
+func (f *fakeTokenSessionRepo) DeleteSession(sessionId string) (err error) {
+	delete(f.sessions, sessionId)
+	return
+}
+
+func (f *fakeTokenSessionRepo) SaveRefreshToken(tokenHash string, sessionId string, ttl time.Duration) (err error) {
+	f.tokens[tokenHash] = sessionId
+	return
+}
+
+func (f *fakeTokenSessionRepo) TakeRefreshToken(tokenHash string, ttl time.Duration) (sessionId string, reused bool, exists bool, err error) {
+	if sessionId, reused = f.used[tokenHash]; reused {
+		return
+	}
+	sessionId, exists = f.tokens[tokenHash]
+	if exists {
+		delete(f.tokens, tokenHash)
+		f.used[tokenHash] = sessionId
+	}
+	return
+}
+
+func TestParseAccessToken(t *testing.T) {
+	tks := NewAccessTokenService(newFakeTokenSessionRepo(), "secret", 15*time.Minute, time.Hour)
+	other := NewAccessTokenService(newFakeTokenSessionRepo(), "other", 15*time.Minute, time.Hour)
+	now := time.Now()
+	claims := accessClaims{Subject: "1", SessionId: models.TokenSessionPrefix + "abc", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+
+	valid, _ := tks.sign(claims)
+	expiredClaims := claims
+	expiredClaims.ExpiresAt = now.Add(-time.Second).Unix()
+	expired, _ := tks.sign(expiredClaims)
+	cookieClaims := claims
+	cookieClaims.SessionId = "abc"
+	cookieSession, _ := tks.sign(cookieClaims)
+	foreign, _ := other.sign(claims)
+	parts := strings.Split(valid, ".")
+	forgedClaims := claims
+	forgedClaims.SessionId = models.TokenSessionPrefix + "victim"
+	forged, _ := tks.sign(forgedClaims)
+	tampered := parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", valid, true},
+		{"expired", expired, false},
+		{"session of a cookie", cookieSession, false},
+		{"signed with another secret", foreign, false},
+		{"payload of another token", tampered, false},
+		{"algorithm none", none, false},
+		{"empty", "", false},
+		{"garbage", "a.b.c", false},
+	}
+	for _, tt := range tests {
+		sessionId, ok := tks.ParseAccessToken(tt.token)
+		if ok != tt.ok {
+			t.Errorf("%v: ok = %v, want %v", tt.name, ok, tt.ok)
+		}
+		if ok && sessionId != claims.SessionId {
+			t.Errorf("%v: session = %v, want %v", tt.name, sessionId, claims.SessionId)
+		}
+	}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	sr := newFakeTokenSessionRepo()
+	sessionId := models.TokenSessionPrefix + "session"
+	sr.sessions[sessionId] = 1
+	tks := NewAccessTokenService(sr, "secret", 15*time.Minute, time.Hour)
+
+	first, err := tks.IssueTokens(sessionId)
+	if err != nil {
+		t.Fatalf("IssueTokens: %v", err)
+	}
+	second, err := tks.Refresh(first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatalf("Refresh did not rotate the refresh token")
+	}
+	if got, ok := tks.ParseAccessToken(second.AccessToken); !ok || got != sessionId {
+		t.Fatalf("access token of the refresh: %v, %v", got, ok)
+	}
+
+	// the first token again: it was stolen, the session is revoked
+	_, err = tks.Refresh(first.RefreshToken)
+	if err != models.ErrUnautorized {
+		t.Fatalf("reuse of a refresh token: error = %v, want %v", err, models.ErrUnautorized)
+	}
+	if _, exists := sr.sessions[sessionId]; exists {
+		t.Fatalf("reuse of a refresh token did not revoke the session")
+	}
+	// the rotated token belongs to the revoked session
+	_, err = tks.Refresh(second.RefreshToken)
+	if err != models.ErrUnautorized {
+		t.Errorf("refresh of a revoked session: error = %v, want %v", err, models.ErrUnautorized)
+	}
+	_, err = tks.Refresh("unknown")
+	if err != models.ErrUnautorized {
+		t.Errorf("unknown refresh token: error = %v, want %v", err, models.ErrUnautorized)
+	}
+}
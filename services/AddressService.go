@@ -0,0 +1,96 @@
+package services
+
+import (
+	"log"
+	"strings"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+type AddressService struct {
+	sr repository.SessionRepository
+	ar repository.AddressRepository
+}
+
+func NewAddressService(sessionRepo repository.SessionRepository, addressRepo repository.AddressRepository) AddressService {
+	return AddressService{
+		sr: sessionRepo,
+		ar: addressRepo,
+	}
+}
+
+func (as *AddressService) GetUserAddresses(sessionId string) (addrs []models.Address_db, err error) {
+	userId, e := as.userId(sessionId)
+	if e != nil {
+		err = e
+		return
+	}
+	addrs, err = as.ar.GetUserAddresses(userId)
+	return
+}
+
+func (as *AddressService) CreateAddress(sessionId string, addr models.Address_db) (newAddrId int, err error) {
+	addr.UserId, err = as.userId(sessionId)
+	if err != nil {
+		return
+	}
+	err = validateAddress(&addr)
+	if err != nil {
+		return
+	}
+	newAddrId, err = as.ar.CreateAddress(addr)
+	return
+}
+
+func (as *AddressService) UpdateAddress(sessionId string, addr models.Address_db) (err error) {
+	addr.UserId, err = as.userId(sessionId)
+	if err != nil {
+		return
+	}
+	err = validateAddress(&addr)
+	if err != nil {
+		return
+	}
+	err = as.ar.UpdateAddress(addr)
+	return
+}
+
+func (as *AddressService) DeleteAddress(sessionId string, addrId int) (err error) {
+	userId, e := as.userId(sessionId)
+	if e != nil {
+		err = e
+		return
+	}
+	err = as.ar.DeleteAddress(addrId, userId)
+	return
+}
+
+func (as *AddressService) userId(sessionId string) (userId int, err error) {
+	userId, _, _, err = as.sr.GetUserSessionInfo(sessionId)
+	if err != nil {
+		log.Printf("AddressService: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// validateAddress trims the fields and checks the required ones, country is an ISO code like RU
+func validateAddress(addr *models.Address_db) (err error) {
+	addr.Recipient = strings.TrimSpace(addr.Recipient)
+	addr.Phone = strings.TrimSpace(addr.Phone)
+	addr.Country = strings.ToUpper(strings.TrimSpace(addr.Country))
+	addr.Region = strings.TrimSpace(addr.Region)
+	addr.City = strings.TrimSpace(addr.City)
+	addr.Street = strings.TrimSpace(addr.Street)
+	addr.PostalCode = strings.TrimSpace(addr.PostalCode)
+	if addr.Recipient == "" || addr.City == "" || addr.Street == "" {
+		log.Printf("recipient, city and street are required")
+		err = models.ErrBadRequest
+		return
+	}
+	if len(addr.Country) != 2 || strings.Trim(addr.Country, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		log.Printf("country must be a 2-letter code")
+		err = models.ErrBadRequest
+	}
+	return
+}
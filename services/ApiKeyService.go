@@ -0,0 +1,152 @@
+package services
+
+import (
+	"log"
+	"strings"
+	"time"
+	"toyStore/models"
+	"toyStore/repository"
+	"unicode/utf8"
+)
+
+type ApiKeyService struct {
+	sr repository.SessionRepository
+	ur repository.UserRepository
+	rr repository.RoleRepository
+	kr repository.ApiKeyRepository
+}
+
+func NewApiKeyService(sessionRepo repository.SessionRepository, userRepo repository.UserRepository, roleRepo repository.RoleRepository, apiKeyRepo repository.ApiKeyRepository) ApiKeyService {
+	return ApiKeyService{
+		sr: sessionRepo,
+		ur: userRepo,
+		rr: roleRepo,
+		kr: apiKeyRepo,
+	}
+}
+
+const (
+	apiKeyPrefix        = "tsk_"
+	apiKeyShownLength   = 8
+	maxApiKeyNameLength = 100
+)
+
+func (aks *ApiKeyService) GetApiKeys() (keys []models.ApiKey_db, err error) {
+	keys, err = aks.kr.GetApiKeys()
+	return
+}
+
+// CreateApiKey creates a key with the scopes the creator has themselves. The key is returned only here,
+// the db keeps only its hash.
+func (aks *ApiKeyService) CreateApiKey(sessionId string, req models.ApiKeyRequest) (newKey models.NewApiKey, err error) {
+	userId, role, exists, e := aks.sr.GetUserSessionInfo(sessionId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !exists {
+		err = models.ErrUnautorized
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > maxApiKeyNameLength {
+		err = models.ErrBadRequest
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		log.Printf("CreateApiKey: expiry %v is in the past", req.ExpiresAt)
+		err = models.ErrBadRequest
+		return
+	}
+	scopes := []string{}
+	for _, v := range req.Scopes {
+		// a key can not create other keys
+		if !statusIn(v, models.Permissions) || v == models.PermApiKeysManage {
+			log.Printf("CreateApiKey: scope %q is not allowed", v)
+			err = models.ErrBadRequest
+			return
+		}
+		if statusIn(v, scopes) {
+			continue
+		}
+		allowed, e := aks.rr.HasPermission(role, v)
+		if e != nil {
+			err = e
+			return
+		}
+		if !allowed {
+			log.Printf("CreateApiKey: role %v has no permission %v", role, v)
+			err = models.ErrNotAllowed
+			return
+		}
+		scopes = append(scopes, v)
+	}
+	if len(scopes) == 0 {
+		err = models.ErrBadRequest
+		return
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return
+	}
+	key := apiKeyPrefix + token
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		t := req.ExpiresAt.UTC()
+		expiresAt = &t
+	}
+	newKey.Id, err = aks.kr.CreateApiKey(models.ApiKey_db{
+		Name:      req.Name,
+		Prefix:    key[:len(apiKeyPrefix)+apiKeyShownLength],
+		Scopes:    scopes,
+		CreatedBy: userId,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}, hashToken(key))
+	if err != nil {
+		return
+	}
+	newKey.Key = key
+	return
+}
+
+func (aks *ApiKeyService) RevokeApiKey(keyId int) (err error) {
+	err = aks.kr.RevokeApiKey(keyId)
+	return
+}
+
+// CheckApiKey checks that the key is active and was granted the permission, exists is false for an unknown,
+// revoked or expired key and for a key of a blocked or deleted user. The key never gives more than the current
+// role of its creator, so it loses a scope when the creator loses the permission.
+func (aks *ApiKeyService) CheckApiKey(key string, permission string) (apiKey models.ApiKey_db, exists bool, access bool, err error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return
+	}
+	apiKey, ex, err := aks.kr.GetApiKeyByHash(hashToken(key))
+	if err != nil || !ex {
+		return
+	}
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && !apiKey.ExpiresAt.After(time.Now())) {
+		return
+	}
+	creator, ex, err := aks.ur.GetUserById(apiKey.CreatedBy)
+	if err != nil {
+		return
+	}
+	if !ex || creator.Blocked || creator.DeletedAt != nil {
+		log.Printf("CheckApiKey: creator %v of the key %v is blocked or deleted", apiKey.CreatedBy, apiKey.Id)
+		return
+	}
+	exists = true
+	if statusIn(permission, apiKey.Scopes) {
+		access, err = aks.rr.HasPermission(creator.Role, permission)
+		if err != nil {
+			return
+		}
+	}
+	if e := aks.kr.TouchApiKey(apiKey.Id); e != nil {
+		log.Printf("CheckApiKey: %v", e)
+	}
+	return
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"log"
+	"strconv"
 	"toyStore/entities"
 	"toyStore/models"
 	"toyStore/repository"
@@ -10,14 +11,18 @@ import (
 )
 
 type CartService struct {
-	pr repository.ProductRepository
-	cr repository.CartRepository
+	pr  repository.ProductRepository
+	cr  repository.CartRepository
+	prs PromotionService
+	ts  TaxService
 }
 
-func NewCartService(productRepo repository.ProductRepository, cartRepo repository.CartRepository) CartService {
+func NewCartService(productRepo repository.ProductRepository, cartRepo repository.CartRepository, promoService PromotionService, taxService TaxService) CartService {
 	return CartService{
-		pr: productRepo,
-		cr: cartRepo,
+		pr:  productRepo,
+		cr:  cartRepo,
+		prs: promoService,
+		ts:  taxService,
 	}
 }
 
@@ -41,6 +46,55 @@ func (cs *CartService) AddCartItem(cartSessionId string, product entities.CartRe
 	return
 }
 
+// AddOrderItems copies the lines of a past order into the cart. Lines of unavailable products are
+// skipped, the quantity is reduced to what is left in stock after the items already in the cart.
+func (cs *CartService) AddOrderItems(cartSessionId string, items []entities.ProductOrderFormat) (report []entities.ReorderItem, err error) {
+	cart, e := cs.cr.GetCart(cartSessionId)
+	if e != nil {
+		err = e
+		return
+	}
+	ordered := map[int]int{}
+	for _, v := range items {
+		if _, ok := ordered[v.Id]; !ok {
+			report = append(report, entities.ReorderItem{ProductId: v.Id, Name: v.Name})
+		}
+		ordered[v.Id] = ordered[v.Id] + v.Quantity
+	}
+
+	for i := range report {
+		item := &report[i]
+		item.Ordered = ordered[item.ProductId]
+		p, ex, e := cs.pr.GetProductById(item.ProductId)
+		if e != nil {
+			err = e
+			return
+		}
+		if !ex || !p.Available {
+			item.Status = entities.ReorderSkipped
+			item.Reason = "product is unavailable"
+			continue
+		}
+		left := p.Quantity - cart.Items[item.ProductId]
+		if left <= 0 {
+			item.Status = entities.ReorderSkipped
+			item.Reason = "out of stock"
+			continue
+		}
+		item.Added = min(item.Ordered, left)
+		err = cs.AddCartItem(cartSessionId, entities.CartRequest{ProductId: item.ProductId, Quantity: item.Added})
+		if err != nil {
+			return
+		}
+		item.Status = entities.ReorderAdded
+		if item.Added < item.Ordered {
+			item.Status = entities.ReorderReduced
+			item.Reason = "only " + strconv.Itoa(left) + " left in stock"
+		}
+	}
+	return
+}
+
 func (cs *CartService) RemoveCartItem(cartSessionId string, product entities.CartRequest) (err error) {
 	err = cs.cr.RemoveCartItem(cartSessionId, product)
 	return
@@ -54,14 +108,14 @@ func (cs *CartService) CreateCartSession() (cartSessionId string, err error) {
 	return
 }
 
-func (cs *CartService) GetCartItems(cartSessionId string) (resp entities.CartResponse, err error) {
+func (cs *CartService) GetCartItems(cartSessionId string, region string) (resp entities.CartResponse, err error) {
 	cart, e := cs.cr.GetCart(cartSessionId)
 	if e != nil {
 		err = e
 		return
 	}
 	items := []entities.CartItem{}
-	var totalPrice float64
+	var totalPrice models.Money
 	for key, value := range cart.Items {
 		p, _, e := cs.pr.GetProductById(key)
 		if e != nil {
@@ -73,15 +127,28 @@ func (cs *CartService) GetCartItems(cartSessionId string) (resp entities.CartRes
 			Name:      p.Name,
 			Quantity:  value,
 			Price:     p.Price,
-			SumPrice:  float64(value) * p.Price,
+			SumPrice:  p.Price.Mul(value),
 			Available: p.Available,
 		}
 		totalPrice = totalPrice + prodCart.SumPrice
 		items = append(items, prodCart)
 	}
+	discount, e := cs.prs.ApplyPromotions(items)
+	if e != nil {
+		err = e
+		return
+	}
+	tax, taxToAdd, e := cs.ts.ApplyTaxes(items, region)
+	if e != nil {
+		err = e
+		return
+	}
 	resp = entities.CartResponse{
 		Products:   items,
-		TotalPrice: totalPrice,
+		Discount:   discount,
+		Tax:        tax,
+		TaxRegion:  cs.ts.Region(region),
+		TotalPrice: totalPrice - discount + taxToAdd,
 	}
 	return
 }
@@ -0,0 +1,29 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// CsrfService makes the csrf tokens of cookie sessions. The token is the HMAC-SHA256 of the session id,
+// so it is not stored and a token set by another site does not match the session.
+type CsrfService struct {
+	secret []byte
+}
+
+func NewCsrfService(secret string) CsrfService {
+	return CsrfService{
+		secret: []byte(secret),
+	}
+}
+
+func (css *CsrfService) Token(sessionId string) string {
+	mac := hmac.New(sha256.New, css.secret)
+	mac.Write([]byte(sessionId))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (css *CsrfService) Check(sessionId string, token string) bool {
+	return token != "" && hmac.Equal([]byte(token), []byte(css.Token(sessionId)))
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"time"
+	"toyStore/entities"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+type CurrencyService struct {
+	cr   repository.CurrencyRepository
+	base string
+}
+
+func NewCurrencyService(currencyRepo repository.CurrencyRepository, baseCurrency string) CurrencyService {
+	return CurrencyService{
+		cr:   currencyRepo,
+		base: strings.ToUpper(baseCurrency),
+	}
+}
+
+func (crs *CurrencyService) BaseCurrency() string {
+	return crs.base
+}
+
+func (crs *CurrencyService) GetRates() (rates []models.ExchangeRate_db, err error) {
+	rates, err = crs.cr.GetRates()
+	return
+}
+
+// GetRate returns the rate of the currency to the base one, empty currency means the base currency
+func (crs *CurrencyService) GetRate(currency string) (rate models.Rate, err error) {
+	currency = strings.ToUpper(currency)
+	if currency == "" || currency == crs.base {
+		rate = models.RateScale
+		return
+	}
+	r, ex, e := crs.cr.GetRate(currency)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex {
+		log.Printf("GetRate: unknown currency %v", currency)
+		err = models.ErrBadRequest
+		return
+	}
+	rate = r.Rate
+	return
+}
+
+func (crs *CurrencyService) SetRate(rate models.ExchangeRate_db) (err error) {
+	rate.Currency = strings.ToUpper(rate.Currency)
+	err = crs.validateRate(rate)
+	if err != nil {
+		return
+	}
+	rate.UpdatedAt = time.Now().UTC()
+	err = crs.cr.SetRates([]models.ExchangeRate_db{rate})
+	return
+}
+
+// ImportRates reads "currency,rate" lines, an optional header line is skipped
+func (crs *CurrencyService) ImportRates(data io.Reader) (imported int, err error) {
+	reader := csv.NewReader(data)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	rates := []models.ExchangeRate_db{}
+	now := time.Now().UTC()
+	for {
+		record, e := reader.Read()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+		if e != nil {
+			log.Printf("ImportRates: %v", e)
+			err = models.ErrBadRequest
+			return
+		}
+		if len(rates) == 0 && strings.EqualFold(record[0], "currency") {
+			continue
+		}
+		rate := models.ExchangeRate_db{
+			Currency:  strings.ToUpper(strings.TrimSpace(record[0])),
+			UpdatedAt: now,
+		}
+		rate.Rate, e = models.ParseRate(record[1])
+		if e != nil {
+			log.Printf("ImportRates: invalid rate for %v", rate.Currency)
+			err = models.ErrBadRequest
+			return
+		}
+		err = crs.validateRate(rate)
+		if err != nil {
+			return
+		}
+		rates = append(rates, rate)
+	}
+	if len(rates) == 0 {
+		log.Printf("ImportRates: no rates to import")
+		err = models.ErrBadRequest
+		return
+	}
+	err = crs.cr.SetRates(rates)
+	if err != nil {
+		return
+	}
+	imported = len(rates)
+	return
+}
+
+func (crs *CurrencyService) validateRate(rate models.ExchangeRate_db) (err error) {
+	if len(rate.Currency) != 3 || strings.Trim(rate.Currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		log.Printf("currency code must contain 3 letters")
+		err = models.ErrBadRequest
+		return
+	}
+	if rate.Currency == crs.base {
+		log.Printf("rate of the base currency can not be changed")
+		err = models.ErrNotAllowed
+		return
+	}
+	if rate.Rate <= 0 {
+		log.Printf("rate must be positive")
+		err = models.ErrBadRequest
+	}
+	return
+}
+
+func (crs *CurrencyService) ConvertProduct(p *entities.Product, currency string) (err error) {
+	rate, e := crs.GetRate(currency)
+	if e != nil {
+		err = e
+		return
+	}
+	p.Price = p.Price.Convert(rate)
+	p.RegularPrice = p.RegularPrice.Convert(rate)
+	p.Currency = crs.currencyCode(currency)
+	return
+}
+
+// ConvertCart converts the lines for display, the totals are converted from the base currency once,
+// the same way as the total of an order, so the cart and the order of it show the same sum
+func (crs *CurrencyService) ConvertCart(c *entities.CartResponse, currency string) (err error) {
+	rate, e := crs.GetRate(currency)
+	if e != nil {
+		err = e
+		return
+	}
+	for i := range c.Products {
+		item := &c.Products[i]
+		item.Price = item.Price.Convert(rate)
+		item.SumPrice = item.Price.Mul(item.Quantity)
+		item.Discount = item.Discount.Convert(rate)
+		for j := range item.Promotions {
+			item.Promotions[j].Discount = item.Promotions[j].Discount.Convert(rate)
+		}
+		item.Tax = item.Tax.Convert(rate)
+	}
+	c.Discount = c.Discount.Convert(rate)
+	c.Tax = c.Tax.Convert(rate)
+	c.TotalPrice = c.TotalPrice.Convert(rate)
+	c.Currency = crs.currencyCode(currency)
+	return
+}
+
+func (crs *CurrencyService) currencyCode(currency string) string {
+	if currency == "" {
+		return crs.base
+	}
+	return strings.ToUpper(currency)
+}
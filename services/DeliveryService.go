@@ -0,0 +1,113 @@
+package services
+
+import (
+	"log"
+	"strings"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+type DeliveryService struct {
+	dr repository.DeliveryRepository
+}
+
+func NewDeliveryService(deliveryRepo repository.DeliveryRepository) DeliveryService {
+	return DeliveryService{
+		dr: deliveryRepo,
+	}
+}
+
+func (ds *DeliveryService) GetDeliveryMethods(onlyActive bool) (methods []models.DeliveryMethod_db, err error) {
+	methods, err = ds.dr.GetDeliveryMethods(onlyActive)
+	if methods == nil {
+		methods = []models.DeliveryMethod_db{}
+	}
+	return
+}
+
+// GetDeliveryMethod returns only active methods, inactive ones can not be chosen on checkout
+func (ds *DeliveryService) GetDeliveryMethod(methodId int) (method models.DeliveryMethod_db, err error) {
+	method, ex, e := ds.dr.GetDeliveryMethod(methodId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex || !method.Active {
+		log.Printf("Delivery method %v is unavailable", methodId)
+		err = models.ErrBadRequest
+	}
+	return
+}
+
+// OrderDeliveryMethod returns the method of a placed order even if it is inactive now
+func (ds *DeliveryService) OrderDeliveryMethod(methodId int) (method models.DeliveryMethod_db, exists bool, err error) {
+	method, exists, err = ds.dr.GetDeliveryMethod(methodId)
+	return
+}
+
+func (ds *DeliveryService) CreateDeliveryMethod(method models.DeliveryMethod_db) (newMethodId int, err error) {
+	err = validateDeliveryMethod(&method)
+	if err != nil {
+		return
+	}
+	newMethodId, err = ds.dr.CreateDeliveryMethod(method)
+	return
+}
+
+func (ds *DeliveryService) UpdateDeliveryMethod(method models.DeliveryMethod_db) (err error) {
+	err = validateDeliveryMethod(&method)
+	if err != nil {
+		return
+	}
+	err = ds.dr.UpdateDeliveryMethod(method)
+	return
+}
+
+// ShippingPrice calculates the delivery cost by the total weight in grams and the goods total after discounts
+func (ds *DeliveryService) ShippingPrice(method models.DeliveryMethod_db, weight int, goodsTotal models.Money) (price models.Money) {
+	switch method.Type {
+	case models.DeliveryFlat:
+		price = method.Price
+	case models.DeliveryWeight:
+		kg := (weight + 999) / 1000
+		price = method.Price + method.PricePerKg.Mul(kg)
+	case models.DeliveryFreeThreshold:
+		if goodsTotal < method.FreeThreshold {
+			price = method.Price
+		}
+	}
+	return
+}
+
+func validateDeliveryMethod(method *models.DeliveryMethod_db) (err error) {
+	method.Name = strings.TrimSpace(method.Name)
+	if method.Name == "" {
+		log.Printf("delivery method name can not be empty")
+		err = models.ErrBadRequest
+		return
+	}
+	if method.Price < 0 || method.PricePerKg < 0 || method.FreeThreshold < 0 {
+		log.Printf("delivery prices can not be negative")
+		err = models.ErrBadRequest
+		return
+	}
+	switch method.Type {
+	case models.DeliveryFlat:
+	case models.DeliveryWeight:
+		if method.PricePerKg == 0 {
+			log.Printf("price per kg is required")
+			err = models.ErrBadRequest
+		}
+	case models.DeliveryFreeThreshold:
+		if method.FreeThreshold == 0 {
+			log.Printf("free threshold is required")
+			err = models.ErrBadRequest
+		}
+	case models.DeliveryPickup:
+		method.Price = 0
+	default:
+		log.Printf("unknown delivery method type %v", method.Type)
+		err = models.ErrBadRequest
+	}
+	return
+}
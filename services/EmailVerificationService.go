@@ -0,0 +1,111 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+// EmailVerificationService verifies emails by signed tokens. Nothing is stored: the token
+// carries the user id, the email and the expiry, signed with HMAC-SHA256. A token is
+// useless after the user changes the email, the email in it does not match any more.
+type EmailVerificationService struct {
+	ur     repository.UserRepository
+	ms     MailSender
+	secret []byte
+	ttl    time.Duration
+	appUrl string
+}
+
+func NewEmailVerificationService(userRepo repository.UserRepository, mailSender MailSender, secret string, ttl time.Duration, appUrl string) EmailVerificationService {
+	return EmailVerificationService{
+		ur:     userRepo,
+		ms:     mailSender,
+		secret: []byte(secret),
+		ttl:    ttl,
+		appUrl: strings.TrimRight(appUrl, "/"),
+	}
+}
+
+// SendVerification mails a verification link for the current email of the user
+func (evs *EmailVerificationService) SendVerification(uModel models.User_db) (err error) {
+	if uModel.Email == "" {
+		log.Printf("SendVerification: user %v has no email", uModel.Id)
+		err = models.ErrBadRequest
+		return
+	}
+	if uModel.EmailVerifiedAt != nil {
+		log.Printf("SendVerification: email of the user %v is already verified", uModel.Id)
+		err = models.ErrNotAllowed
+		return
+	}
+	token := evs.sign(uModel.Id, uModel.Email, time.Now().Add(evs.ttl))
+	body := "Hello, " + uModel.Nickname + "!\n\n" +
+		"To confirm your email open the link:\n" +
+		evs.appUrl + "/verify-email?token=" + url.QueryEscape(token) + "\n\n" +
+		"The link expires in " + evs.ttl.String() + ".\n"
+	err = evs.ms.Send(uModel.Email, "Confirm your email", body)
+	return
+}
+
+// VerifyEmail checks the signature and the expiry of the token and marks the email verified
+func (evs *EmailVerificationService) VerifyEmail(token string) (err error) {
+	userId, email, ok := evs.parse(token)
+	if !ok {
+		log.Printf("VerifyEmail: the token is invalid or expired")
+		err = models.ErrBadRequest
+		return
+	}
+	err = evs.ur.SetEmailVerified(userId, email)
+	return
+}
+
+// sign makes the token "payload.signature", the payload is "userId:expiry:email"
+func (evs *EmailVerificationService) sign(userId int, email string, expires time.Time) string {
+	payload := strconv.Itoa(userId) + ":" + strconv.FormatInt(expires.Unix(), 10) + ":" + email
+	enc := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return enc + "." + base64.RawURLEncoding.EncodeToString(evs.mac(enc))
+}
+
+func (evs *EmailVerificationService) parse(token string) (userId int, email string, ok bool) {
+	enc, sig, found := strings.Cut(token, ".")
+	if !found {
+		return
+	}
+	mac, e := base64.RawURLEncoding.DecodeString(sig)
+	if e != nil || !hmac.Equal(mac, evs.mac(enc)) {
+		return
+	}
+	payload, e := base64.RawURLEncoding.DecodeString(enc)
+	if e != nil {
+		return
+	}
+	parts := strings.SplitN(string(payload), ":", 3)
+	if len(parts) != 3 {
+		return
+	}
+	userId, e = strconv.Atoi(parts[0])
+	if e != nil {
+		return
+	}
+	expires, e := strconv.ParseInt(parts[1], 10, 64)
+	if e != nil || time.Now().Unix() > expires {
+		return
+	}
+	email = parts[2]
+	ok = true
+	return
+}
+
+func (evs *EmailVerificationService) mac(data string) []byte {
+	h := hmac.New(sha256.New, evs.secret)
+	h.Write([]byte("email-verification:" + data))
+	return h.Sum(nil)
+}
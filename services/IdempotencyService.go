@@ -0,0 +1,77 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+// idempotencyLockTtl is how long a key stays in progress. If the server stops before the response
+// is saved, the key is freed after this time instead of answering 409 until the saved ttl ends.
+const idempotencyLockTtl = time.Minute
+
+type IdempotencyService struct {
+	ir  repository.IdempotencyRepository
+	ttl time.Duration
+}
+
+func NewIdempotencyService(idempotencyRepo repository.IdempotencyRepository, ttl time.Duration) IdempotencyService {
+	return IdempotencyService{
+		ir:  idempotencyRepo,
+		ttl: ttl,
+	}
+}
+
+// Begin reserves the key of the client scope. It returns the saved response if the request was
+// already completed, ErrConflict while it is in progress and ErrBadRequest if the key was used
+// for a different request.
+func (is *IdempotencyService) Begin(scope string, key string, fingerprint string) (saved *models.IdempotentResponse, err error) {
+	if len(key) == 0 || len(key) > 255 {
+		log.Printf("Idempotency-Key must contain from 1 to 255 characters")
+		err = models.ErrBadRequest
+		return
+	}
+	resp, reserved, e := is.ir.Reserve(is.redisKey(scope, key), fingerprint, idempotencyLockTtl)
+	if e != nil || reserved {
+		err = e
+		return
+	}
+	if resp.Fingerprint != fingerprint {
+		log.Printf("Idempotency-Key is reused for a different request")
+		err = models.ErrBadRequest
+		return
+	}
+	if resp.Status == 0 {
+		log.Printf("request with the Idempotency-Key is in progress")
+		err = models.ErrConflict
+		return
+	}
+	saved = &resp
+	return
+}
+
+// Finish saves the response. Server errors and unauthorized responses are not saved,
+// the key is released so the request can be retried.
+func (is *IdempotencyService) Finish(scope string, key string, resp models.IdempotentResponse) (err error) {
+	if resp.Status >= http.StatusInternalServerError || resp.Status == http.StatusUnauthorized {
+		err = is.ir.Release(is.redisKey(scope, key))
+		return
+	}
+	err = is.ir.Save(is.redisKey(scope, key), resp, is.ttl)
+	return
+}
+
+// Abort releases the key of a request that ended without a response, so it can be retried
+func (is *IdempotencyService) Abort(scope string, key string) (err error) {
+	err = is.ir.Release(is.redisKey(scope, key))
+	return
+}
+
+func (is *IdempotencyService) redisKey(scope string, key string) string {
+	sum := sha256.Sum256([]byte(scope + "\n" + key))
+	return "idempotency:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,392 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"strconv"
+	"strings"
+	"toyStore/entities"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+type InvoiceService struct {
+	sr     repository.SessionRepository
+	or     repository.OrderRepository
+	rr     repository.RoleRepository
+	base   string
+	seller string
+}
+
+func NewInvoiceService(sessionRepo repository.SessionRepository, orderRepo repository.OrderRepository, roleRepo repository.RoleRepository, baseCurrency string, seller string) InvoiceService {
+	return InvoiceService{
+		sr:     sessionRepo,
+		or:     orderRepo,
+		rr:     roleRepo,
+		base:   strings.ToUpper(baseCurrency),
+		seller: seller,
+	}
+}
+
+// GetInvoice is available to the customer of the order and to staff who can read orders.
+// The invoice number is assigned on the first request and stays the same.
+func (is *InvoiceService) GetInvoice(sessionId string, orderId int) (inv entities.Invoice, err error) {
+	userId, role, _, e := is.sr.GetUserSessionInfo(sessionId)
+	if e != nil {
+		log.Printf("GetInvoice: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	inv, err = is.prepare(orderId)
+	if err != nil {
+		return
+	}
+	if inv.Order.UserData.Id != userId {
+		allowed, e := is.rr.HasPermission(role, models.PermOrdersRead)
+		if e != nil {
+			err = e
+			return
+		}
+		if !allowed {
+			err = models.ErrNotFoundError
+			return
+		}
+	}
+	if inv.Order.Status == "cancelled" || inv.Order.Status == "rejected" {
+		log.Printf("invoice is not issued for %v orders", inv.Order.Status)
+		err = models.ErrNotAllowed
+		return
+	}
+	number, date, e := is.or.AssignInvoiceNumber(orderId)
+	if e != nil {
+		err = e
+		return
+	}
+	inv.Number = fmt.Sprintf("INV-%06d", number)
+	inv.Date = date
+	return
+}
+
+func (is *InvoiceService) GetPackingSlip(orderId int) (inv entities.Invoice, err error) {
+	inv, err = is.prepare(orderId)
+	return
+}
+
+func (is *InvoiceService) prepare(orderId int) (inv entities.Invoice, err error) {
+	order, e := is.or.GetOrderById(orderId)
+	if e != nil {
+		err = e
+		return
+	}
+	inv = entities.Invoice{
+		BaseCurrency: is.base,
+		Order:        order,
+	}
+	for _, v := range order.Products {
+		inv.Subtotal = inv.Subtotal + v.Price.Mul(v.Quantity)
+		if !v.TaxIncluded {
+			inv.TaxAdded = inv.TaxAdded + v.Tax
+		}
+	}
+	return
+}
+
+func (is *InvoiceService) InvoicePDF(inv entities.Invoice) []byte {
+	d := newPDFDocument()
+	d.text(d.margin, 18, true, "Invoice "+inv.Number)
+	d.down(22)
+	d.text(d.margin, 10, false, "Date: "+inv.Date.Format("2006-01-02")+"    Order: "+strconv.Itoa(inv.Order.OrderId)+" of "+inv.Order.Date.Format("2006-01-02 15:04"))
+	d.down(14)
+	d.text(d.margin, 10, false, "Seller: "+is.seller)
+	d.down(20)
+	is.pdfParties(d, inv.Order)
+
+	cols := []float64{d.margin, d.margin + 25, 330, 380, 440, 495, d.width - d.margin}
+	d.ensure(30)
+	d.text(cols[0], 9, true, "#")
+	d.text(cols[1], 9, true, "Product")
+	d.textRight(cols[3], 9, true, "Qty")
+	d.textRight(cols[4], 9, true, "Price")
+	d.textRight(cols[5], 9, true, "Discount")
+	d.textRight(cols[6], 9, true, "Total")
+	d.down(5)
+	d.hline()
+	d.down(12)
+	for i, v := range inv.Order.Products {
+		d.ensure(14)
+		d.text(cols[0], 9, false, strconv.Itoa(i+1))
+		d.text(cols[1], 9, false, truncate(v.Name+" ("+v.Manufacturer+")", 50))
+		d.textRight(cols[3], 9, false, strconv.Itoa(v.Quantity))
+		d.textRight(cols[4], 9, false, v.Price.String())
+		d.textRight(cols[5], 9, false, v.Discount.String())
+		d.textRight(cols[6], 9, false, v.TotalPrice.String())
+		d.down(12)
+		if v.Tax != 0 {
+			d.text(cols[1], 8, false, "tax "+taxNote(v.Tax, v.TaxIncluded))
+			d.down(12)
+		}
+	}
+	d.hline()
+	d.down(16)
+
+	for _, t := range is.totals(inv) {
+		d.ensure(14)
+		d.textRight(cols[5], 10, t.Bold, t.Name)
+		d.textRight(cols[6], 10, t.Bold, t.Value)
+		d.down(14)
+	}
+	return d.bytes()
+}
+
+func (is *InvoiceService) PackingSlipPDF(inv entities.Invoice) []byte {
+	d := newPDFDocument()
+	d.text(d.margin, 18, true, "Packing slip")
+	d.down(22)
+	d.text(d.margin, 10, false, "Order: "+strconv.Itoa(inv.Order.OrderId)+" of "+inv.Order.Date.Format("2006-01-02 15:04"))
+	d.down(20)
+	is.pdfParties(d, inv.Order)
+
+	d.ensure(30)
+	d.text(d.margin, 9, true, "#")
+	d.text(d.margin+25, 9, true, "Product")
+	d.text(360, 9, true, "Manufacturer")
+	d.textRight(d.width-d.margin, 9, true, "Qty")
+	d.down(5)
+	d.hline()
+	d.down(12)
+	for i, v := range inv.Order.Products {
+		d.ensure(14)
+		d.text(d.margin, 9, false, strconv.Itoa(i+1))
+		d.text(d.margin+25, 9, false, truncate(v.Name, 55))
+		d.text(360, 9, false, truncate(v.Manufacturer, 25))
+		d.textRight(d.width-d.margin, 9, false, strconv.Itoa(v.Quantity))
+		d.down(12)
+	}
+	d.hline()
+	d.down(20)
+
+	o := inv.Order
+	if o.GiftWrap {
+		d.ensure(14)
+		d.text(d.margin, 10, true, "Gift wrap the order")
+		d.down(18)
+	}
+	for _, v := range []struct{ title, text string }{{"Gift message", o.GiftMessage}, {"Customer note", o.Note}} {
+		if v.text == "" {
+			continue
+		}
+		d.ensure(28)
+		d.text(d.margin, 10, true, v.title)
+		d.down(14)
+		for _, line := range wrapText(v.text, 95) {
+			d.ensure(13)
+			d.text(d.margin, 10, false, line)
+			d.down(13)
+		}
+		d.down(8)
+	}
+	return d.bytes()
+}
+
+func (is *InvoiceService) pdfParties(d *pdfDocument, order entities.Order) {
+	d.text(d.margin, 10, true, "Customer")
+	d.text(320, 10, true, "Delivery")
+	d.down(14)
+	left := []string{order.UserData.Nickname}
+	right := []string{order.DeliveryMethod}
+	right = append(right, addressLines(order.ShippingAddress)...)
+	for i := 0; i < len(left) || i < len(right); i++ {
+		if i < len(left) {
+			d.text(d.margin, 10, false, left[i])
+		}
+		if i < len(right) {
+			d.text(320, 10, false, right[i])
+		}
+		d.down(13)
+	}
+	d.down(12)
+}
+
+type invoiceTotal struct {
+	Name  string
+	Value string
+	Bold  bool
+}
+
+func (is *InvoiceService) totals(inv entities.Invoice) (totals []invoiceTotal) {
+	o := inv.Order
+	totals = append(totals, invoiceTotal{Name: "Subtotal", Value: inv.Subtotal.String()})
+	if o.Discount != 0 {
+		totals = append(totals, invoiceTotal{Name: "Discount", Value: "-" + o.Discount.String()})
+	}
+	if o.Tax != 0 {
+		totals = append(totals, invoiceTotal{Name: "Tax (" + o.TaxRegion + ")", Value: taxNote(o.Tax, inv.TaxAdded == 0)})
+	}
+	if o.ShippingPrice != 0 {
+		totals = append(totals, invoiceTotal{Name: "Shipping", Value: o.ShippingPrice.String()})
+	}
+	if o.GiftWrapPrice != 0 {
+		totals = append(totals, invoiceTotal{Name: "Gift wrap", Value: o.GiftWrapPrice.String()})
+	}
+	totals = append(totals, invoiceTotal{Name: "Total, " + inv.BaseCurrency, Value: o.TotalPrice.String(), Bold: true})
+	if o.Currency != "" && o.Currency != inv.BaseCurrency {
+		totals = append(totals, invoiceTotal{Name: "Total, " + o.Currency, Value: o.CurrencyTotalPrice.String(), Bold: true})
+	}
+	if o.RefundedAmount != 0 {
+		totals = append(totals, invoiceTotal{Name: "Refunded", Value: "-" + o.RefundedAmount.String()})
+	}
+	return
+}
+
+func (is *InvoiceService) InvoiceHTML(inv entities.Invoice) (data []byte, err error) {
+	data, err = is.renderHTML(invoiceTemplate, inv)
+	return
+}
+
+func (is *InvoiceService) PackingSlipHTML(inv entities.Invoice) (data []byte, err error) {
+	data, err = is.renderHTML(packingSlipTemplate, inv)
+	return
+}
+
+func (is *InvoiceService) renderHTML(t *template.Template, inv entities.Invoice) (data []byte, err error) {
+	var buf bytes.Buffer
+	e := t.Execute(&buf, map[string]any{
+		"Invoice": inv,
+		"Seller":  is.seller,
+		"Address": addressLines(inv.Order.ShippingAddress),
+		"Totals":  is.totals(inv),
+	})
+	if e != nil {
+		log.Printf("renderHTML: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	data = buf.Bytes()
+	return
+}
+
+func addressLines(addr *models.Address_db) (lines []string) {
+	if addr == nil {
+		return
+	}
+	lines = append(lines, addr.Recipient)
+	if addr.Phone != "" {
+		lines = append(lines, addr.Phone)
+	}
+	lines = append(lines, addr.Street)
+	city := strings.TrimSpace(strings.Join([]string{addr.PostalCode, addr.City}, " "))
+	if addr.Region != "" && addr.Region != addr.City {
+		city = city + ", " + addr.Region
+	}
+	lines = append(lines, city, addr.Country)
+	return
+}
+
+func taxNote(tax models.Money, included bool) string {
+	if included {
+		return tax.String() + " incl."
+	}
+	return tax.String()
+}
+
+// wrapText splits the text into lines of at most n characters on spaces, longer words are cut
+func wrapText(s string, n int) (lines []string) {
+	for _, paragraph := range strings.Split(s, "\n") {
+		line := []rune{}
+		for _, word := range strings.Fields(paragraph) {
+			w := []rune(word)
+			if len(line) > 0 && len(line)+1+len(w) > n {
+				lines = append(lines, string(line))
+				line = line[:0]
+			}
+			for len(w) > n {
+				lines = append(lines, string(w[:n]))
+				w = w[n:]
+			}
+			if len(line) > 0 {
+				line = append(line, ' ')
+			}
+			line = append(line, w...)
+		}
+		lines = append(lines, string(line))
+	}
+	return
+}
+
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-3]) + "..."
+}
+
+var templateFuncs = template.FuncMap{
+	"inc": func(i int) int { return i + 1 },
+}
+
+var invoiceTemplate = template.Must(template.New("invoice").Funcs(templateFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Invoice {{.Invoice.Number}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; font-size: 14px; margin: 40px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: 4px 8px; border-bottom: 1px solid #ccc; text-align: left; }
+.num { text-align: right; }
+.parties td { border: none; vertical-align: top; width: 50%; }
+</style>
+</head>
+<body>
+<h1>Invoice {{.Invoice.Number}}</h1>
+<p>Date: {{.Invoice.Date.Format "2006-01-02"}}<br>
+Order: {{.Invoice.Order.OrderId}} of {{.Invoice.Order.Date.Format "2006-01-02 15:04"}}<br>
+Seller: {{.Seller}}</p>
+<table class="parties"><tr>
+<td><b>Customer</b><br>{{.Invoice.Order.UserData.Nickname}}</td>
+<td><b>Delivery</b><br>{{.Invoice.Order.DeliveryMethod}}{{range .Address}}<br>{{.}}{{end}}</td>
+</tr></table>
+<table>
+<tr><th>#</th><th>Product</th><th class="num">Qty</th><th class="num">Price</th><th class="num">Discount</th><th class="num">Tax</th><th class="num">Total</th></tr>
+{{range $i, $p := .Invoice.Order.Products}}<tr><td>{{inc $i}}</td><td>{{$p.Name}} ({{$p.Manufacturer}})</td><td class="num">{{$p.Quantity}}</td><td class="num">{{$p.Price}}</td><td class="num">{{$p.Discount}}</td><td class="num">{{$p.Tax}}{{if $p.TaxIncluded}} incl.{{end}}</td><td class="num">{{$p.TotalPrice}}</td></tr>
+{{end}}</table>
+<table>
+{{range .Totals}}<tr><td class="num">{{if .Bold}}<b>{{.Name}}</b>{{else}}{{.Name}}{{end}}</td><td class="num" style="width: 120px">{{if .Bold}}<b>{{.Value}}</b>{{else}}{{.Value}}{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+var packingSlipTemplate = template.Must(template.New("packing-slip").Funcs(templateFuncs).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Packing slip, order {{.Invoice.Order.OrderId}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; font-size: 14px; margin: 40px; }
+table { border-collapse: collapse; width: 100%; }
+th, td { padding: 4px 8px; border-bottom: 1px solid #ccc; text-align: left; }
+.num { text-align: right; }
+.parties td { border: none; vertical-align: top; width: 50%; }
+</style>
+</head>
+<body>
+<h1>Packing slip</h1>
+<p>Order: {{.Invoice.Order.OrderId}} of {{.Invoice.Order.Date.Format "2006-01-02 15:04"}}</p>
+<table class="parties"><tr>
+<td><b>Customer</b><br>{{.Invoice.Order.UserData.Nickname}}</td>
+<td><b>Delivery</b><br>{{.Invoice.Order.DeliveryMethod}}{{range .Address}}<br>{{.}}{{end}}</td>
+</tr></table>
+<table>
+<tr><th>#</th><th>Product</th><th>Manufacturer</th><th class="num">Qty</th></tr>
+{{range $i, $p := .Invoice.Order.Products}}<tr><td>{{inc $i}}</td><td>{{$p.Name}}</td><td>{{$p.Manufacturer}}</td><td class="num">{{$p.Quantity}}</td></tr>
+{{end}}</table>
+{{with .Invoice.Order}}{{if .GiftWrap}}<p><b>Gift wrap the order</b></p>
+{{end}}{{if .GiftMessage}}<p><b>Gift message</b><br>{{.GiftMessage}}</p>
+{{end}}{{if .Note}}<p><b>Customer note</b><br>{{.Note}}</p>
+{{end}}{{end}}</body>
+</html>
+`))
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"log"
+	"time"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+// loginPolicy is how many failed sign-ins are free, after them every failure locks the sign-in
+// for twice as long as the previous one, after maxAttempts the lock lasts the whole lockout
+type loginPolicy struct {
+	freeAttempts int
+	maxAttempts  int
+	lockout      time.Duration
+}
+
+func (p loginPolicy) lockFor(failures int) time.Duration {
+	if failures >= p.maxAttempts {
+		return p.lockout
+	}
+	if failures <= p.freeAttempts {
+		return 0
+	}
+	shift := failures - p.freeAttempts - 1
+	if shift > 20 {
+		return p.lockout
+	}
+	return min(time.Second<<shift, p.lockout)
+}
+
+type LoginAttemptService struct {
+	lr      repository.LoginAttemptRepository
+	account loginPolicy
+	ip      loginPolicy
+}
+
+func NewLoginAttemptService(loginAttemptRepo repository.LoginAttemptRepository, maxAttempts int, ipMaxAttempts int, lockout time.Duration) LoginAttemptService {
+	return LoginAttemptService{
+		lr:      loginAttemptRepo,
+		account: loginPolicy{freeAttempts: min(3, maxAttempts), maxAttempts: maxAttempts, lockout: lockout},
+		ip:      loginPolicy{freeAttempts: min(10, ipMaxAttempts), maxAttempts: ipMaxAttempts, lockout: lockout},
+	}
+}
+
+// Check returns ErrTooManyRequests while the account or the address is locked.
+// Unknown usernames are counted and locked the same way, so a lock tells nothing about the user.
+func (las *LoginAttemptService) Check(username string, ip string) (err error) {
+	for _, v := range [][2]string{{models.LoginScopeAccount, username}, {models.LoginScopeIp, ip}} {
+		ttl, e := las.lr.GetLock(v[0], v[1])
+		if e != nil {
+			err = e
+			return
+		}
+		if ttl > 0 {
+			log.Printf("sign-in of %v %q is locked for %v", v[0], v[1], ttl)
+			err = models.ErrTooManyRequests
+			return
+		}
+	}
+	return
+}
+
+// Fail counts a failed sign-in for the account and the address and locks them by the policy
+func (las *LoginAttemptService) Fail(username string, ip string) (err error) {
+	err = las.fail(models.LoginScopeAccount, username, las.account)
+	if err != nil {
+		return
+	}
+	err = las.fail(models.LoginScopeIp, ip, las.ip)
+	return
+}
+
+func (las *LoginAttemptService) fail(scope string, id string, policy loginPolicy) (err error) {
+	// the counter lives a few lockouts, so the next failure after a lockout locks again at once
+	failures, err := las.lr.AddFailure(scope, id, 4*policy.lockout)
+	if err != nil {
+		return
+	}
+	if ttl := policy.lockFor(failures); ttl > 0 {
+		err = las.lr.SetLock(scope, id, ttl)
+	}
+	return
+}
+
+// Succeed resets the account counter, the address keeps its failures
+func (las *LoginAttemptService) Succeed(username string) (err error) {
+	err = las.lr.Reset(models.LoginScopeAccount, username)
+	return
+}
+
+func (las *LoginAttemptService) GetLockedAccounts() (locks []models.LoginLock_db, err error) {
+	locks, err = las.lr.GetLockedAccounts()
+	return
+}
+
+func (las *LoginAttemptService) Unlock(username string) (err error) {
+	err = las.lr.Reset(models.LoginScopeAccount, username)
+	return
+}
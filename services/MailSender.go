@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"toyStore/models"
+
+	"github.com/google/uuid"
+)
+
+// MailSender delivers plain text letters to users
+type MailSender interface {
+	Send(to string, subject string, body string) (err error)
+}
+
+// SMTPMailSender sends letters through an SMTP server, auth is used only when the user is set
+type SMTPMailSender struct {
+	addr     string
+	host     string
+	user     string
+	password string
+	from     string
+}
+
+func NewSMTPMailSender(host string, port string, user string, password string, from string) *SMTPMailSender {
+	return &SMTPMailSender{
+		addr:     host + ":" + port,
+		host:     host,
+		user:     user,
+		password: password,
+		from:     from,
+	}
+}
+
+func (s *SMTPMailSender) Send(to string, subject string, body string) (err error) {
+	var auth smtp.Auth
+	if s.user != "" {
+		auth = smtp.PlainAuth("", s.user, s.password, s.host)
+	}
+	err = smtp.SendMail(s.addr, auth, s.from, []string{to}, buildLetter(s.from, to, subject, body))
+	if err != nil {
+		log.Printf("SMTPMailSender: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+// FileMailSender writes every letter to a .eml file in the directory, for development without a mail server
+type FileMailSender struct {
+	dir  string
+	from string
+}
+
+func NewFileMailSender(dir string, from string) *FileMailSender {
+	return &FileMailSender{
+		dir:  dir,
+		from: from,
+	}
+}
+
+func (f *FileMailSender) Send(to string, subject string, body string) (err error) {
+	err = os.MkdirAll(f.dir, 0o700)
+	if err != nil {
+		log.Printf("FileMailSender[1]: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	name := time.Now().UTC().Format("20060102-150405") + "-" + uuid.NewString() + ".eml"
+	err = os.WriteFile(filepath.Join(f.dir, name), buildLetter(f.from, to, subject, body), 0o600)
+	if err != nil {
+		log.Printf("FileMailSender[2]: %v", err)
+		err = models.ErrServerError
+	}
+	return
+}
+
+func buildLetter(from string, to string, subject string, body string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().UTC().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String())
+}
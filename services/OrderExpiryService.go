@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+	"toyStore/repository"
+)
+
+// OrderExpiryService cancels orders that stay unpaid in created status longer than the age
+type OrderExpiryService struct {
+	or  repository.OrderRepository
+	lr  repository.LockRepository
+	age time.Duration
+}
+
+func NewOrderExpiryService(orderRepo repository.OrderRepository, lockRepo repository.LockRepository, age time.Duration) OrderExpiryService {
+	return OrderExpiryService{
+		or:  orderRepo,
+		lr:  lockRepo,
+		age: age,
+	}
+}
+
+// CancelStaleOrders runs under a lock, if another instance holds it nothing is done
+func (oes *OrderExpiryService) CancelStaleOrders() (orderIds []int, err error) {
+	token, locked, e := oes.lr.TryLock("order-expiry", 5*time.Minute)
+	if e != nil {
+		err = e
+		return
+	}
+	if !locked {
+		log.Printf("CancelStaleOrders: another instance is running")
+		return
+	}
+	defer oes.lr.Unlock("order-expiry", token)
+
+	orderIds, err = oes.or.CancelStaleOrders(time.Now().UTC().Add(-oes.age), "not paid within "+oes.age.String())
+	if err != nil {
+		return
+	}
+	if len(orderIds) > 0 {
+		log.Printf("CancelStaleOrders: cancelled orders %v", orderIds)
+	}
+	return
+}
+
+// Run cancels stale orders every interval until the context is done
+func (oes *OrderExpiryService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		oes.CancelStaleOrders()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,35 +1,105 @@
 package services
 
 import (
+	"database/sql"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 	"toyStore/entities"
 	"toyStore/models"
 	"toyStore/repository"
+	"unicode/utf8"
 )
 
 type OrderService struct {
-	sr repository.SessionRepository
-	pr repository.ProductRepository
-	cr repository.CartRepository
-	or repository.OrderRepository
+	sr  repository.SessionRepository
+	ur  repository.UserRepository
+	pr  repository.ProductRepository
+	cr  repository.CartRepository
+	or  repository.OrderRepository
+	prs PromotionService
+	crs CurrencyService
+	ts  TaxService
+	ar  repository.AddressRepository
+	ds  DeliveryService
+	// price of the gift wrapping of the whole order in the base currency
+	giftWrapPrice models.Money
 }
 
-func NewOrderService(sessionRepo repository.SessionRepository, productRepo repository.ProductRepository, cartRepo repository.CartRepository, orderRepo repository.OrderRepository) OrderService {
+const (
+	maxOrderNoteLength   = 1000
+	maxGiftMessageLength = 500
+)
+
+func NewOrderService(sessionRepo repository.SessionRepository, userRepo repository.UserRepository, productRepo repository.ProductRepository, cartRepo repository.CartRepository, orderRepo repository.OrderRepository, promoService PromotionService, currencyService CurrencyService, taxService TaxService, addressRepo repository.AddressRepository, deliveryService DeliveryService, giftWrapPrice models.Money) OrderService {
 	return OrderService{
-		sr: sessionRepo,
-		pr: productRepo,
-		cr: cartRepo,
-		or: orderRepo,
+		sr:  sessionRepo,
+		ur:  userRepo,
+		pr:  productRepo,
+		cr:  cartRepo,
+		or:  orderRepo,
+		prs: promoService,
+		crs: currencyService,
+		ts:  taxService,
+		ar:  addressRepo,
+		ds:  deliveryService,
+
+		giftWrapPrice: giftWrapPrice,
 	}
 }
 
-func (ors *OrderService) CreateOrder(sessionId string, cartSessionId string) (orderId int, err error) {
+// CreateOrder makes an order from the cart. The address is required for every delivery
+// method except pickup, its country is used as the tax region. The user has to verify the email first.
+func (ors *OrderService) CreateOrder(sessionId string, cartSessionId string, currency string, checkout models.CheckoutRequest) (orderId int, err error) {
 	uId, _, _, e := ors.sr.GetUserSessionInfo(sessionId)
 	if e != nil {
 		err = e
 		return
 	}
+	user, ex, e := ors.ur.GetUserById(uId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex || user.EmailVerifiedAt == nil {
+		log.Printf("CreateOrder: email of the user %v is not verified", uId)
+		err = models.ErrNotAllowed
+		return
+	}
+	checkout.Note = strings.TrimSpace(checkout.Note)
+	checkout.GiftMessage = strings.TrimSpace(checkout.GiftMessage)
+	if utf8.RuneCountInString(checkout.Note) > maxOrderNoteLength || utf8.RuneCountInString(checkout.GiftMessage) > maxGiftMessageLength {
+		log.Printf("CreateOrder: note or gift message is too long")
+		err = models.ErrBadRequest
+		return
+	}
+	method, e := ors.ds.GetDeliveryMethod(checkout.DeliveryMethodId)
+	if e != nil {
+		err = e
+		return
+	}
+	var address *models.Address_db
+	region := ""
+	if method.Type != models.DeliveryPickup {
+		addr, ex, e := ors.ar.GetAddress(checkout.AddressId, uId)
+		if e != nil {
+			err = e
+			return
+		}
+		if !ex {
+			log.Printf("CreateOrder: address %v is not found", checkout.AddressId)
+			err = models.ErrBadRequest
+			return
+		}
+		address = &addr
+		region = addr.Country
+	}
+	rate, e := ors.crs.GetRate(currency)
+	if e != nil {
+		err = e
+		return
+	}
 	var cart entities.Cart
 	cart, _ = ors.cr.GetCart(cartSessionId)
 	if len(cart.Items) == 0 {
@@ -37,8 +107,8 @@ func (ors *OrderService) CreateOrder(sessionId string, cartSessionId string) (or
 		return
 	}
 
-	prods := []models.OrdersProducts_db{}
-	var totalPrice float64
+	items := []entities.CartItem{}
+	weight := 0
 	for key, value := range cart.Items {
 		var p models.Product_db
 		p, _, err = ors.pr.GetProductById(key)
@@ -57,21 +127,64 @@ func (ors *OrderService) CreateOrder(sessionId string, cartSessionId string) (or
 			err = models.ErrNotAllowed
 			return
 		}
+		weight = weight + p.Weight*value
+		items = append(items, entities.CartItem{
+			Id:       p.Id,
+			Name:     p.Name,
+			Quantity: value,
+			Price:    p.Price,
+			SumPrice: p.Price.Mul(value),
+		})
+	}
+	discount, e := ors.prs.ApplyPromotions(items)
+	if e != nil {
+		err = e
+		return
+	}
+	tax, taxToAdd, e := ors.ts.ApplyTaxes(items, region)
+	if e != nil {
+		err = e
+		return
+	}
+
+	prods := []models.OrdersProducts_db{}
+	var totalPrice models.Money
+	for _, v := range items {
 		prodOrd := models.OrdersProducts_db{
-			OrderId:   p.Id,
-			ProductId: p.Id,
-			Quantity:  value,
-			Price:     p.Price,
+			ProductId:   v.Id,
+			Quantity:    v.Quantity,
+			Price:       v.Price,
+			Discount:    v.Discount,
+			Tax:         v.Tax,
+			TaxIncluded: v.TaxIncluded,
 		}
-		totalPrice = totalPrice + float64(value)*p.Price
+		totalPrice = totalPrice + v.SumPrice
 		prods = append(prods, prodOrd)
 	}
+	shipping := ors.ds.ShippingPrice(method, weight, totalPrice-discount)
+	var giftWrap models.Money
+	if checkout.GiftWrap {
+		giftWrap = ors.giftWrapPrice
+	}
 
 	newOrder := models.Order_db{
-		Status:     "created",
-		UserId:     uId,
-		TotalPrice: totalPrice,
-		Date:       time.Now().UTC(),
+		Status:           "created",
+		UserId:           uId,
+		TotalPrice:       totalPrice - discount + taxToAdd + shipping + giftWrap,
+		Discount:         discount,
+		Tax:              tax,
+		TaxRegion:        ors.ts.Region(region),
+		Currency:         ors.crs.currencyCode(currency),
+		ExchangeRate:     rate,
+		DeliveryMethodId: sql.NullInt64{Int64: int64(method.Id), Valid: true},
+		DeliveryMethod:   method.Name,
+		ShippingPrice:    shipping,
+		ShippingAddress:  address,
+		Note:             checkout.Note,
+		GiftMessage:      checkout.GiftMessage,
+		GiftWrap:         checkout.GiftWrap,
+		GiftWrapPrice:    giftWrap,
+		Date:             time.Now().UTC(),
 	}
 
 	orderId, err = ors.or.CreateOrder(newOrder)
@@ -93,6 +206,30 @@ func (ors *OrderService) GetOrderById(orderId int) (order entities.Order, err er
 	return
 }
 
+// GetUserOrder returns the order only if it belongs to the current user
+func (ors *OrderService) GetUserOrder(sessionId string, orderId int) (order entities.Order, err error) {
+	userId, _, _, e := ors.sr.GetUserSessionInfo(sessionId)
+	if e != nil {
+		log.Printf("GetUserOrder: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	order, err = ors.or.GetOrderById(orderId)
+	if err != nil {
+		return
+	}
+	if order.UserData.Id != userId {
+		order = entities.Order{}
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+func (ors *OrderService) GetStatusHistory(orderId int) (history []models.OrderStatusHistory_db, err error) {
+	history, err = ors.or.GetStatusHistory(orderId)
+	return
+}
+
 func (ors *OrderService) SearchOrders(data models.OrderSearchData) (orders []entities.Order, err error) {
 	orders, err = ors.or.SearchOrders(data)
 	return
@@ -126,3 +263,252 @@ func (ors *OrderService) CancelOrder(orderId int, sessionId string) (err error)
 	err = ors.or.CancelOrder(orderId, userId)
 	return
 }
+
+// EditOrderItem changes a line of a created order: the product is substituted first, then the quantity
+// and the price are changed. Every change is recorded, the whole order is repriced.
+func (ors *OrderService) EditOrderItem(managerId int, orderId int, itemId int, req models.OrderItemEditRequest) (err error) {
+	if req.Quantity < 0 || (req.Price != nil && *req.Price < 0) {
+		err = models.ErrBadRequest
+		return
+	}
+	if req.ProductId == 0 && req.Quantity == 0 && req.Price == nil {
+		err = models.ErrBadRequest
+		return
+	}
+	if req.Price != nil && req.Reason == "" {
+		log.Printf("EditOrderItem: price change without a reason")
+		err = models.ErrBadRequest
+		return
+	}
+	order, err := ors.or.GetOrderById(orderId)
+	if err != nil {
+		return
+	}
+	if order.Status != "created" {
+		log.Printf("order can not be edited. Current status is %v", order.Status)
+		err = models.ErrNotAllowed
+		return
+	}
+	item, ex, err := ors.or.GetOrderItem(orderId, itemId)
+	if err != nil {
+		return
+	}
+	if !ex {
+		err = models.ErrNotFoundError
+		return
+	}
+
+	now := time.Now().UTC()
+	changes := []models.OrderChange_db{}
+	addChange := func(action string, oldValue string, newValue string) {
+		changes = append(changes, models.OrderChange_db{
+			OrderId:   orderId,
+			ItemId:    itemId,
+			Action:    action,
+			OldValue:  oldValue,
+			NewValue:  newValue,
+			Reason:    req.Reason,
+			ChangedBy: managerId,
+			ChangedAt: now,
+		})
+	}
+	quantity := item.Quantity
+	if req.Quantity > 0 {
+		quantity = req.Quantity
+	}
+	if req.ProductId != 0 && req.ProductId != item.ProductId {
+		p, ex, e := ors.pr.GetProductById(req.ProductId)
+		if e != nil {
+			err = e
+			return
+		}
+		if !ex {
+			err = models.ErrNotFoundError
+			return
+		}
+		if !p.Available || p.Quantity < quantity {
+			log.Print("product ", p.Name, " is unavailable")
+			err = models.ErrNotAllowed
+			return
+		}
+		addChange(models.OrderChangeSubstitute, strconv.Itoa(item.ProductId), strconv.Itoa(p.Id))
+		item.ProductId = p.Id
+		item.Price = p.Price
+	} else if quantity > item.Quantity {
+		p, _, e := ors.pr.GetProductById(item.ProductId)
+		if e != nil {
+			err = e
+			return
+		}
+		if p.Quantity < quantity {
+			log.Print("quantity of the product ", p.Name, " is unavailable")
+			err = models.ErrNotAllowed
+			return
+		}
+	}
+	if quantity != item.Quantity {
+		addChange(models.OrderChangeQuantity, strconv.Itoa(item.Quantity), strconv.Itoa(quantity))
+		item.Quantity = quantity
+	}
+	if req.Price != nil && *req.Price != item.Price {
+		addChange(models.OrderChangePrice, item.Price.String(), req.Price.String())
+		item.Price = *req.Price
+	}
+	if len(changes) == 0 {
+		return
+	}
+
+	lines := orderLines(order)
+	for i := range lines {
+		if lines[i].Id == itemId {
+			lines[i] = item
+		}
+	}
+	shipping, err := ors.repriceOrder(order, lines)
+	if err != nil {
+		return
+	}
+	err = ors.or.UpdateOrderItems(orderId, lines, shipping, changes)
+	return
+}
+
+// RemoveOrderItem removes a line of a created order, records the change and reprices the rest of the order
+func (ors *OrderService) RemoveOrderItem(managerId int, orderId int, itemId int, reason string) (err error) {
+	order, err := ors.or.GetOrderById(orderId)
+	if err != nil {
+		return
+	}
+	if order.Status != "created" {
+		log.Printf("order can not be edited. Current status is %v", order.Status)
+		err = models.ErrNotAllowed
+		return
+	}
+	item, ex, err := ors.or.GetOrderItem(orderId, itemId)
+	if err != nil {
+		return
+	}
+	if !ex {
+		err = models.ErrNotFoundError
+		return
+	}
+	change := models.OrderChange_db{
+		OrderId:   orderId,
+		ItemId:    itemId,
+		Action:    models.OrderChangeRemove,
+		OldValue:  strconv.Itoa(item.ProductId) + " x " + strconv.Itoa(item.Quantity),
+		Reason:    reason,
+		ChangedBy: managerId,
+		ChangedAt: time.Now().UTC(),
+	}
+	lines := []models.OrdersProducts_db{}
+	for _, v := range orderLines(order) {
+		if v.Id != itemId {
+			lines = append(lines, v)
+		}
+	}
+	shipping, err := ors.repriceOrder(order, lines)
+	if err != nil {
+		return
+	}
+	err = ors.or.RemoveOrderItem(orderId, itemId, lines, shipping, change)
+	return
+}
+
+// repriceOrder applies the promotions and taxes to the lines of an edited order again, the way CreateOrder does,
+// so the discounts and the minimum totals of promotions follow the new lines. The shipping price is calculated
+// again by the delivery method of the order, without the method the old price is kept.
+func (ors *OrderService) repriceOrder(order entities.Order, lines []models.OrdersProducts_db) (shipping models.Money, err error) {
+	items := []entities.CartItem{}
+	weight := 0
+	var total models.Money
+	for _, v := range lines {
+		p, ex, e := ors.pr.GetProductById(v.ProductId)
+		if e != nil {
+			err = e
+			return
+		}
+		if ex {
+			weight = weight + p.Weight*v.Quantity
+		}
+		items = append(items, entities.CartItem{
+			Id:       v.ProductId,
+			Quantity: v.Quantity,
+			Price:    v.Price,
+			SumPrice: v.Price.Mul(v.Quantity),
+		})
+		total = total + v.Price.Mul(v.Quantity)
+	}
+	discount, err := ors.prs.ApplyPromotions(items)
+	if err != nil {
+		return
+	}
+	_, _, err = ors.ts.ApplyTaxes(items, order.TaxRegion)
+	if err != nil {
+		return
+	}
+	for i := range lines {
+		lines[i].Discount = items[i].Discount
+		lines[i].Tax = items[i].Tax
+		lines[i].TaxIncluded = items[i].TaxIncluded
+	}
+
+	shipping = order.ShippingPrice
+	if order.DeliveryMethodId != 0 {
+		method, ex, e := ors.ds.OrderDeliveryMethod(order.DeliveryMethodId)
+		if e != nil {
+			err = e
+			return
+		}
+		if ex {
+			shipping = ors.ds.ShippingPrice(method, weight, total-discount)
+		}
+	}
+	return
+}
+
+// orderLines are the lines of the order in the form they are stored
+func orderLines(order entities.Order) (lines []models.OrdersProducts_db) {
+	for _, v := range order.Products {
+		lines = append(lines, models.OrdersProducts_db{
+			Id:          v.ItemId,
+			OrderId:     order.OrderId,
+			ProductId:   v.Id,
+			Quantity:    v.Quantity,
+			Price:       v.Price,
+			Discount:    v.Discount,
+			Tax:         v.Tax,
+			TaxIncluded: v.TaxIncluded,
+		})
+	}
+	return
+}
+
+func (ors *OrderService) GetOrderChanges(orderId int) (changes []models.OrderChange_db, err error) {
+	changes, err = ors.or.GetOrderChanges(orderId)
+	return
+}
+
+// AddOrderNote adds an internal note of the current manager to the order
+func (ors *OrderService) AddOrderNote(managerId int, orderId int, text string) (newNoteId int, err error) {
+	text = strings.TrimSpace(text)
+	if text == "" || utf8.RuneCountInString(text) > maxOrderNoteLength {
+		err = models.ErrBadRequest
+		return
+	}
+	_, err = ors.or.GetOrderById(orderId)
+	if err != nil {
+		return
+	}
+	newNoteId, err = ors.or.AddOrderNote(models.OrderNote_db{
+		OrderId:   orderId,
+		AuthorId:  managerId,
+		Text:      text,
+		CreatedAt: time.Now().UTC(),
+	})
+	return
+}
+
+func (ors *OrderService) GetOrderNotes(orderId int) (notes []models.OrderNote_db, err error) {
+	notes, err = ors.or.GetOrderNotes(orderId)
+	return
+}
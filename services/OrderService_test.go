@@ -0,0 +1,137 @@
+package services
+
+import (
+	"testing"
+	"toyStore/entities"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+// fakeEditOrderRepo keeps one created order and what the edit saved
+type fakeEditOrderRepo struct {
+	repository.OrderRepository
+	order    entities.Order
+	lines    []models.OrdersProducts_db
+	shipping models.Money
+}
+
+func (f *fakeEditOrderRepo) GetOrderById(orderId int) (order entities.Order, err error) {
+	return f.order, nil
+}
+
+func (f *fakeEditOrderRepo) GetOrderItem(orderId int, itemId int) (item models.OrdersProducts_db, exists bool, err error) {
+	for _, v := range orderLines(f.order) {
+		if v.Id == itemId {
+			return v, true, nil
+		}
+	}
+	return
+}
+
+func (f *fakeEditOrderRepo) UpdateOrderItems(orderId int, lines []models.OrdersProducts_db, shippingPrice models.Money, changes []models.OrderChange_db) (err error) {
+	f.lines, f.shipping = lines, shippingPrice
+	return
+}
+
+func (f *fakeEditOrderRepo) RemoveOrderItem(orderId int, itemId int, lines []models.OrdersProducts_db, shippingPrice models.Money, change models.OrderChange_db) (err error) {
+	f.lines, f.shipping = lines, shippingPrice
+	return
+}
+
+type fakeDeliveryRepo struct {
+	repository.DeliveryRepository
+	method models.DeliveryMethod_db
+}
+
+func (f *fakeDeliveryRepo) GetDeliveryMethod(methodId int) (method models.DeliveryMethod_db, exists bool, err error) {
+	return f.method, methodId == f.method.Id, nil
+}
+
+// the order was placed with 10% off from 100.00 and free delivery from 100.00
+func newTestEditOrderService() (OrderService, *fakeEditOrderRepo) {
+	or := &fakeEditOrderRepo{order: entities.Order{
+		OrderId:          5,
+		Status:           "created",
+		TaxRegion:        "RU",
+		DeliveryMethodId: 1,
+		ShippingPrice:    0,
+		Products: []entities.ProductOrderFormat{
+			{ItemId: 11, Id: 1, Quantity: 2, Price: 6000, Discount: 1200},
+			{ItemId: 12, Id: 2, Quantity: 1, Price: 1000, Discount: 100},
+		},
+	}}
+	prr := &fakeProductRepo{products: map[int]models.Product_db{
+		1: {Id: 1, Price: 6000, Quantity: 10, Available: true},
+		2: {Id: 2, Price: 1000, Quantity: 10, Available: true},
+	}}
+	prs := NewPromotionService(&fakePromotionRepo{promos: []models.Promotion_db{
+		{Id: 1, Type: models.PromotionCartTotal, MinTotal: 10000, DiscountPercent: 10},
+	}}, prr)
+	ds := NewDeliveryService(&fakeDeliveryRepo{method: models.DeliveryMethod_db{
+		Id: 1, Type: models.DeliveryFreeThreshold, Price: 500, FreeThreshold: 10000,
+	}})
+	ts := NewTaxService(&fakeTaxRepo{}, "RU")
+	return NewOrderService(nil, nil, prr, nil, or, prs, CurrencyService{}, ts, nil, ds, 0), or
+}
+
+func TestEditOrderReprices(t *testing.T) {
+	tests := []struct {
+		name          string
+		edit          func(ors *OrderService) error
+		wantDiscounts []models.Money
+		wantShipping  models.Money
+	}{
+		{
+			name: "total stays over the promotion minimum",
+			edit: func(ors *OrderService) error {
+				return ors.EditOrderItem(1, 5, 12, models.OrderItemEditRequest{Quantity: 2})
+			},
+			wantDiscounts: []models.Money{1200, 200},
+			wantShipping:  0,
+		},
+		{
+			name: "quantity falls under the promotion minimum",
+			edit: func(ors *OrderService) error {
+				return ors.EditOrderItem(1, 5, 11, models.OrderItemEditRequest{Quantity: 1})
+			},
+			wantDiscounts: []models.Money{0, 0},
+			wantShipping:  500,
+		},
+		{
+			name: "price cut under the promotion minimum",
+			edit: func(ors *OrderService) error {
+				price := models.Money(4000)
+				return ors.EditOrderItem(1, 5, 11, models.OrderItemEditRequest{Price: &price, Reason: "damaged box"})
+			},
+			wantDiscounts: []models.Money{0, 0},
+			wantShipping:  500,
+		},
+		{
+			name: "removed line",
+			edit: func(ors *OrderService) error {
+				return ors.RemoveOrderItem(1, 5, 11, "out of stock")
+			},
+			wantDiscounts: []models.Money{0},
+			wantShipping:  500,
+		},
+	}
+	for _, tt := range tests {
+		ors, or := newTestEditOrderService()
+		if err := tt.edit(&ors); err != nil {
+			t.Errorf("%v: error = %v", tt.name, err)
+			continue
+		}
+		if len(or.lines) != len(tt.wantDiscounts) {
+			t.Errorf("%v: %v lines saved, want %v", tt.name, len(or.lines), len(tt.wantDiscounts))
+			continue
+		}
+		for i, v := range or.lines {
+			if v.Discount != tt.wantDiscounts[i] {
+				t.Errorf("%v: line %v discount = %v, want %v", tt.name, v.Id, v.Discount, tt.wantDiscounts[i])
+			}
+		}
+		if or.shipping != tt.wantShipping {
+			t.Errorf("%v: shipping = %v, want %v", tt.name, or.shipping, tt.wantShipping)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+const passwordResetToken = "password-reset"
+
+type PasswordResetService struct {
+	ur     repository.UserRepository
+	sr     repository.SessionRepository
+	tr     repository.TokenRepository
+	ms     MailSender
+	ttl    time.Duration
+	appUrl string
+}
+
+func NewPasswordResetService(userRepo repository.UserRepository, sessionRepo repository.SessionRepository, tokenRepo repository.TokenRepository, mailSender MailSender, ttl time.Duration, appUrl string) PasswordResetService {
+	return PasswordResetService{
+		ur:     userRepo,
+		sr:     sessionRepo,
+		tr:     tokenRepo,
+		ms:     mailSender,
+		ttl:    ttl,
+		appUrl: strings.TrimRight(appUrl, "/"),
+	}
+}
+
+// ForgotPassword mails a reset token to the user with the email. Nothing tells the caller
+// whether the email is registered, so the response is the same for unknown addresses.
+func (pws *PasswordResetService) ForgotPassword(email string) (err error) {
+	email, err = normalizeEmail(email)
+	if err != nil {
+		return
+	}
+	uModel, ex, e := pws.ur.GetUserByEmail(email)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex || uModel.DeletedAt != nil {
+		log.Printf("ForgotPassword: no user with the email")
+		return
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return
+	}
+	err = pws.tr.SaveToken(passwordResetToken, hashToken(token), uModel.Id, pws.ttl)
+	if err != nil {
+		return
+	}
+	body := "Hello, " + uModel.Nickname + "!\n\n" +
+		"To set a new password open the link:\n" +
+		pws.appUrl + "/reset-password?token=" + url.QueryEscape(token) + "\n\n" +
+		"The link works once and expires in " + pws.ttl.String() + ".\n" +
+		"If you did not ask for a new password, ignore this letter.\n"
+	err = pws.ms.Send(email, "Password reset", body)
+	return
+}
+
+// ResetPassword sets the new password by a token from the letter and signs the user out everywhere
+func (pws *PasswordResetService) ResetPassword(req models.PasswordResetRequest) (err error) {
+	if req.Token == "" || req.NewPassword == "" {
+		err = models.ErrBadRequest
+		return
+	}
+	userId, ex, e := pws.tr.TakeToken(passwordResetToken, hashToken(req.Token))
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex {
+		log.Printf("ResetPassword: the token is invalid or expired")
+		err = models.ErrBadRequest
+		return
+	}
+	hashedPassword, err := pws.ur.EncryptPassword(req.NewPassword)
+	if err != nil {
+		return
+	}
+	err = pws.ur.UpdatePassword(userId, hashedPassword)
+	if err != nil {
+		return
+	}
+	err = pws.sr.RevokeUserSessions(userId)
+	return
+}
+
+// newToken returns 32 random bytes as url-safe text
+func newToken() (token string, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		log.Printf("newToken: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"toyStore/models"
+
+	"github.com/google/uuid"
+)
+
+// PaymentProvider is a payment gateway. The provider creates payments and refunds on its side and
+// verifies the signature of its webhook notifications.
+type PaymentProvider interface {
+	Name() string
+	CreatePayment(payment models.Payment_db) (providerPaymentId string, confirmationUrl string, err error)
+	Refund(payment models.Payment_db, amount models.Money) (providerRefundId string, err error)
+	ParseWebhook(payload []byte, signature string) (event models.PaymentEvent, err error)
+}
+
+// FakePaymentProvider accepts every payment locally, it is used for development and tests.
+// Webhooks are signed with HMAC-SHA256 of the body in hex.
+type FakePaymentProvider struct {
+	secret []byte
+}
+
+func NewFakePaymentProvider(secret string) *FakePaymentProvider {
+	return &FakePaymentProvider{
+		secret: []byte(secret),
+	}
+}
+
+func (fp *FakePaymentProvider) Name() string {
+	return "fake"
+}
+
+func (fp *FakePaymentProvider) CreatePayment(payment models.Payment_db) (providerPaymentId string, confirmationUrl string, err error) {
+	providerPaymentId = "fake_" + uuid.NewString()
+	confirmationUrl = "https://pay.example.local/" + providerPaymentId
+	return
+}
+
+func (fp *FakePaymentProvider) Refund(payment models.Payment_db, amount models.Money) (providerRefundId string, err error) {
+	providerRefundId = "fake_refund_" + uuid.NewString()
+	return
+}
+
+func (fp *FakePaymentProvider) Sign(payload []byte) string {
+	mac := hmac.New(sha256.New, fp.secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (fp *FakePaymentProvider) ParseWebhook(payload []byte, signature string) (event models.PaymentEvent, err error) {
+	if !hmac.Equal([]byte(fp.Sign(payload)), []byte(signature)) {
+		log.Printf("ParseWebhook: invalid signature")
+		err = models.ErrUnautorized
+		return
+	}
+	err = json.Unmarshal(payload, &event)
+	if err != nil {
+		log.Printf("ParseWebhook: %v", err)
+		err = models.ErrBadRequest
+		return
+	}
+	if event.EventId == "" || event.ProviderPaymentId == "" || (event.Status != models.PaymentSucceeded && event.Status != models.PaymentFailed) {
+		log.Printf("ParseWebhook: event is incomplete")
+		err = models.ErrBadRequest
+	}
+	return
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"testing"
+	"toyStore/models"
+)
+
+func TestFakeProviderParseWebhook(t *testing.T) {
+	fp := NewFakePaymentProvider("secret")
+	body := []byte(`{"event_id":"evt_1","payment_id":"fake_1","status":"succeeded","amount":277.99}`)
+
+	tests := []struct {
+		name      string
+		payload   []byte
+		signature string
+		wantErr   error
+	}{
+		{"valid", body, fp.Sign(body), nil},
+		{"no signature", body, "", models.ErrUnautorized},
+		{"signed with another secret", body, NewFakePaymentProvider("other").Sign(body), models.ErrUnautorized},
+		{"signed with an empty secret", body, NewFakePaymentProvider("").Sign(body), models.ErrUnautorized},
+		{"body changed after signing", []byte(`{"event_id":"evt_1","payment_id":"fake_2","status":"succeeded","amount":277.99}`), fp.Sign(body), models.ErrUnautorized},
+		{"one character changed", body, "X" + fp.Sign(body)[1:], models.ErrUnautorized},
+		{"not json", []byte("hello"), fp.Sign([]byte("hello")), models.ErrBadRequest},
+		{"no event id", []byte(`{"payment_id":"fake_1","status":"succeeded"}`), fp.Sign([]byte(`{"payment_id":"fake_1","status":"succeeded"}`)), models.ErrBadRequest},
+		{"unknown status", []byte(`{"event_id":"evt_1","payment_id":"fake_1","status":"refunded"}`), fp.Sign([]byte(`{"event_id":"evt_1","payment_id":"fake_1","status":"refunded"}`)), models.ErrBadRequest},
+	}
+	for _, tt := range tests {
+		event, err := fp.ParseWebhook(tt.payload, tt.signature)
+		if err != tt.wantErr {
+			t.Errorf("%v: error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (event.EventId != "evt_1" || event.Amount != 27799 || event.Status != models.PaymentSucceeded) {
+			t.Errorf("%v: event = %+v", tt.name, event)
+		}
+	}
+}
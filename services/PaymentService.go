@@ -0,0 +1,231 @@
+package services
+
+import (
+	"log"
+	"time"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+type PaymentService struct {
+	sr        repository.SessionRepository
+	or        repository.OrderRepository
+	pr        repository.PaymentRepository
+	providers map[string]PaymentProvider
+	def       string
+}
+
+// NewPaymentService registers the providers, the first one is used when a request does not name a provider
+func NewPaymentService(sessionRepo repository.SessionRepository, orderRepo repository.OrderRepository, paymentRepo repository.PaymentRepository, providers ...PaymentProvider) PaymentService {
+	ps := PaymentService{
+		sr:        sessionRepo,
+		or:        orderRepo,
+		pr:        paymentRepo,
+		providers: map[string]PaymentProvider{},
+	}
+	for _, p := range providers {
+		if ps.def == "" {
+			ps.def = p.Name()
+		}
+		ps.providers[p.Name()] = p
+	}
+	return ps
+}
+
+// CreatePayment starts the payment of a created order of the current user. A pending payment
+// of the same provider is returned again instead of creating a new one.
+func (pms *PaymentService) CreatePayment(sessionId string, orderId int, providerName string) (payment models.Payment_db, err error) {
+	provider, e := pms.provider(providerName)
+	if e != nil {
+		err = e
+		return
+	}
+	userId, _, _, e := pms.sr.GetUserSessionInfo(sessionId)
+	if e != nil {
+		log.Printf("CreatePayment: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	order, e := pms.or.GetOrderById(orderId)
+	if e != nil {
+		err = e
+		return
+	}
+	if order.UserData.Id != userId {
+		err = models.ErrNotFoundError
+		return
+	}
+	if order.Status != "created" {
+		log.Printf("order %v can not be paid, status is %v", orderId, order.Status)
+		err = models.ErrNotAllowed
+		return
+	}
+
+	payment, ex, e := pms.pr.GetPendingPayment(orderId, provider.Name())
+	if e != nil || ex {
+		err = e
+		return
+	}
+
+	now := time.Now().UTC()
+	payment = models.Payment_db{
+		OrderId:   orderId,
+		Provider:  provider.Name(),
+		Amount:    order.CurrencyTotalPrice,
+		Currency:  order.Currency,
+		Status:    models.PaymentPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	payment.ProviderPaymentId, payment.ConfirmationUrl, err = provider.CreatePayment(payment)
+	if err != nil {
+		log.Printf("CreatePayment: provider %v: %v", provider.Name(), err)
+		err = models.ErrServerError
+		return
+	}
+	payment.Id, err = pms.pr.CreatePayment(payment)
+	return
+}
+
+func (pms *PaymentService) GetOrderPayments(orderId int) (payments []models.Payment_db, err error) {
+	payments, err = pms.pr.GetOrderPayments(orderId)
+	return
+}
+
+// HandleWebhook verifies and applies a provider notification, repeated deliveries of an event are ignored
+func (pms *PaymentService) HandleWebhook(providerName string, payload []byte, signature string) (err error) {
+	provider, ok := pms.providers[providerName]
+	if !ok {
+		log.Printf("HandleWebhook: unknown provider %v", providerName)
+		err = models.ErrNotFoundError
+		return
+	}
+	event, e := provider.ParseWebhook(payload, signature)
+	if e != nil {
+		err = e
+		return
+	}
+	payment, applied, err := pms.pr.ApplyPaymentEvent(provider.Name(), event)
+	if err != nil || !applied || payment.Status != models.PaymentUnmatched {
+		return
+	}
+	pms.refundUnmatched(provider, payment)
+	return
+}
+
+// refundUnmatched returns the money of a payment that succeeded for a changed or cancelled order. The payment
+// is claimed as refunded first, so a repeated event does not refund it twice. If the provider fails the payment
+// stays unmatched for a manager, the webhook itself is still accepted.
+func (pms *PaymentService) refundUnmatched(provider PaymentProvider, payment models.Payment_db) {
+	err := pms.pr.SetPaymentStatus(payment.Id, models.PaymentUnmatched, models.PaymentRefunded)
+	if err != nil {
+		return
+	}
+	providerRefundId, err := provider.Refund(payment, payment.Amount)
+	if err != nil {
+		log.Printf("refundUnmatched: provider %v: %v", provider.Name(), err)
+		if e := pms.pr.SetPaymentStatus(payment.Id, models.PaymentRefunded, models.PaymentUnmatched); e != nil {
+			log.Printf("refundUnmatched: payment %v is not refunded, but marked refunded", payment.Id)
+		}
+		return
+	}
+	log.Printf("unmatched payment %v of the order %v is refunded as %v", payment.Id, payment.OrderId, providerRefundId)
+}
+
+func (pms *PaymentService) GetOrderRefunds(orderId int) (refunds []models.Refund_db, err error) {
+	refunds, err = pms.pr.GetOrderRefunds(orderId)
+	return
+}
+
+// RefundOrder refunds the amount in the base currency through the provider of the succeeded payment.
+// Zero amount refunds everything that is not refunded yet. The refund is claimed in the db before
+// the provider is called, so concurrent requests can not refund the same money twice.
+func (pms *PaymentService) RefundOrder(orderId int, amount models.Money, returnId *int) (refund models.Refund_db, err error) {
+	order, e := pms.or.GetOrderById(orderId)
+	if e != nil {
+		err = e
+		return
+	}
+	remaining := order.TotalPrice - order.RefundedAmount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		log.Printf("RefundOrder: amount %v is invalid, %v can be refunded", amount, remaining)
+		err = models.ErrNotAllowed
+		return
+	}
+	payment, ex, e := pms.pr.GetSucceededPayment(orderId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex {
+		log.Printf("RefundOrder: order %v is not paid", orderId)
+		err = models.ErrNotAllowed
+		return
+	}
+	provider, ok := pms.providers[payment.Provider]
+	if !ok {
+		log.Printf("RefundOrder: unknown provider %v", payment.Provider)
+		err = models.ErrServerError
+		return
+	}
+
+	refund = models.Refund_db{
+		OrderId:        orderId,
+		PaymentId:      payment.Id,
+		ReturnId:       returnId,
+		Amount:         amount,
+		CurrencyAmount: amount.Convert(order.ExchangeRate),
+		Currency:       payment.Currency,
+		Status:         models.RefundPending,
+		CreatedAt:      time.Now().UTC(),
+	}
+	// the last refund takes the rest of the payment, so rounding of the conversion does not leave kopecks
+	if amount == remaining {
+		refunds, e := pms.pr.GetOrderRefunds(orderId)
+		if e != nil {
+			err = e
+			return
+		}
+		refund.CurrencyAmount = payment.Amount
+		for _, v := range refunds {
+			if v.Status != models.RefundFailed {
+				refund.CurrencyAmount = refund.CurrencyAmount - v.CurrencyAmount
+			}
+		}
+	}
+	refund.Id, err = pms.pr.CreateRefund(refund)
+	if err != nil {
+		return
+	}
+	refund.ProviderRefundId, err = provider.Refund(payment, refund.CurrencyAmount)
+	if err != nil {
+		log.Printf("RefundOrder: provider %v: %v", provider.Name(), err)
+		err = models.ErrServerError
+		if e := pms.pr.FailRefund(refund.Id); e != nil {
+			log.Printf("RefundOrder: refund %v stays pending: %v", refund.Id, e)
+		}
+		return
+	}
+	err = pms.pr.CompleteRefund(refund.Id, refund.ProviderRefundId)
+	if err != nil {
+		log.Printf("RefundOrder: refund %v is made by the provider as %v but not recorded", refund.Id, refund.ProviderRefundId)
+		return
+	}
+	refund.Status = models.RefundSucceeded
+	return
+}
+
+func (pms *PaymentService) provider(name string) (provider PaymentProvider, err error) {
+	if name == "" {
+		name = pms.def
+	}
+	provider, ok := pms.providers[name]
+	if !ok {
+		log.Printf("unknown payment provider %v", name)
+		err = models.ErrBadRequest
+	}
+	return
+}
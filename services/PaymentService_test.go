@@ -0,0 +1,80 @@
+package services
+
+import (
+	"testing"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+// fakeWebhookPaymentRepo keeps one payment of an order and applies events to it like the repository does
+type fakeWebhookPaymentRepo struct {
+	repository.PaymentRepository
+	payment     models.Payment_db
+	orderStatus string
+	events      map[string]bool
+}
+
+func (f *fakeWebhookPaymentRepo) ApplyPaymentEvent(provider string, event models.PaymentEvent) (payment models.Payment_db, applied bool, err error) {
+	if f.events[event.EventId] {
+		return f.payment, false, nil
+	}
+	f.events[event.EventId] = true
+	if f.payment.Status == models.PaymentPending || (f.payment.Status == models.PaymentCancelled && event.Status == models.PaymentSucceeded) {
+		f.payment.Status = event.Status
+		if event.Status == models.PaymentSucceeded {
+			f.payment.Status = models.PaymentUnmatched
+			if f.orderStatus == "created" && event.Amount == f.payment.Amount {
+				f.payment.Status = models.PaymentSucceeded
+				f.orderStatus = "paid"
+			}
+		}
+	}
+	return f.payment, true, nil
+}
+
+func (f *fakeWebhookPaymentRepo) SetPaymentStatus(paymentId int, from string, status string) (err error) {
+	if f.payment.Status != from {
+		return models.ErrNotAllowed
+	}
+	f.payment.Status = status
+	return
+}
+
+// the expiry job cancelled the order and its payment while the customer was paying it
+func TestLatePaymentOfCancelledOrder(t *testing.T) {
+	tests := []struct {
+		name        string
+		orderStatus string
+		payment     string
+		fail        bool
+		wantStatus  string
+		wantRefunds int
+	}{
+		{"order still created", "created", models.PaymentPending, false, models.PaymentSucceeded, 0},
+		{"order and payment cancelled", "cancelled", models.PaymentCancelled, false, models.PaymentRefunded, 1},
+		{"order cancelled, payment left pending", "cancelled", models.PaymentPending, false, models.PaymentRefunded, 1},
+		{"provider refuses the refund", "cancelled", models.PaymentCancelled, true, models.PaymentUnmatched, 0},
+	}
+	for _, tt := range tests {
+		pr := &fakeWebhookPaymentRepo{
+			payment:     models.Payment_db{Id: 1, OrderId: 5, Provider: "fake", ProviderPaymentId: "fake_1", Amount: 5000, Currency: "RUB", Status: tt.payment},
+			orderStatus: tt.orderStatus,
+			events:      map[string]bool{},
+		}
+		cp := &countingProvider{FakePaymentProvider: NewFakePaymentProvider("test"), fail: tt.fail}
+		pms := NewPaymentService(nil, nil, pr, cp)
+
+		for _, eventId := range []string{"evt_1", "evt_1", "evt_2"} {
+			body := []byte(`{"event_id":"` + eventId + `","payment_id":"fake_1","status":"succeeded","amount":50.00}`)
+			if err := pms.HandleWebhook("fake", body, cp.Sign(body)); err != nil {
+				t.Errorf("%v: %v: error = %v", tt.name, eventId, err)
+			}
+		}
+		if pr.payment.Status != tt.wantStatus {
+			t.Errorf("%v: payment status = %v, want %v", tt.name, pr.payment.Status, tt.wantStatus)
+		}
+		if cp.refunds != tt.wantRefunds {
+			t.Errorf("%v: %v refunds made, want %v", tt.name, cp.refunds, tt.wantRefunds)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"errors"
 	"log"
+	"time"
 	"toyStore/entities"
 	"toyStore/models"
 	"toyStore/repository"
@@ -47,7 +48,10 @@ func (ps *ProductService) GetProductById(prodId int) (pEnt entities.Product, err
 	pEnt.Name = pModel.Name
 	pEnt.Manufacturer = pModel.Manufacturer
 	pEnt.Price = pModel.Price
+	pEnt.RegularPrice = pModel.RegularPrice
+	pEnt.SaleEndDate = pModel.SaleEndDate
 	pEnt.Quantity = pModel.Quantity
+	pEnt.Weight = pModel.Weight
 	pEnt.Description = pModel.Description.String
 	pEnt.Available = pModel.Available
 
@@ -140,3 +144,56 @@ func (ps *ProductService) RemoveProductCategory(prodId int) (err error) {
 	err = ps.pr.RemoveProductCategory(prodId)
 	return
 }
+
+func (ps *ProductService) GetPriceHistory(prodId int) (prices []models.ProductPrice_db, err error) {
+	var ex bool
+	_, ex, err = ps.pr.GetProductById(prodId)
+	if err != nil {
+		return
+	}
+	if !ex {
+		err = models.ErrNotFoundError
+		return
+	}
+	prices, err = ps.pr.GetPriceHistory(prodId)
+	return
+}
+
+func (ps *ProductService) ScheduleSalePrice(prodId int, req models.SalePriceRequest) (newPriceId int, err error) {
+	var ex bool
+	_, ex, err = ps.pr.GetProductById(prodId)
+	if err != nil {
+		return
+	}
+	if !ex {
+		log.Printf("Product does not exist")
+		err = models.ErrNotAllowed
+		return
+	}
+	if req.Price <= 0 {
+		log.Printf("ScheduleSalePrice: price field is invalid")
+		err = models.ErrBadRequest
+		return
+	}
+	start, e := time.Parse("2006-01-02 15:04:05", req.StartDate)
+	end, e2 := time.Parse("2006-01-02 15:04:05", req.EndDate)
+	if e != nil || e2 != nil || !start.Before(end) || end.Before(time.Now().UTC()) {
+		log.Printf("ScheduleSalePrice: sale dates are invalid")
+		err = models.ErrBadRequest
+		return
+	}
+	newPriceId, err = ps.pr.AddProductPrice(models.ProductPrice_db{
+		ProductId: prodId,
+		Price:     req.Price,
+		Kind:      models.PriceSale,
+		StartDate: start,
+		EndDate:   &end,
+		CreatedAt: time.Now().UTC(),
+	})
+	return
+}
+
+func (ps *ProductService) RemoveSalePrice(prodId int, priceId int) (err error) {
+	err = ps.pr.RemoveSalePrice(prodId, priceId)
+	return
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"log"
+	"sort"
+	"toyStore/entities"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+type PromotionService struct {
+	pr  repository.PromotionRepository
+	prr repository.ProductRepository
+}
+
+func NewPromotionService(promoRepo repository.PromotionRepository, productRepo repository.ProductRepository) PromotionService {
+	return PromotionService{
+		pr:  promoRepo,
+		prr: productRepo,
+	}
+}
+
+func (prs *PromotionService) GetAllPromotions() (promos []models.Promotion_db, err error) {
+	promos, err = prs.pr.GetAllPromotions()
+	return
+}
+
+func (prs *PromotionService) CreatePromotion(promo models.Promotion_db) (newPromoId int, err error) {
+	if promo.Name == "" {
+		log.Printf("promotion name can not be empty")
+		err = models.ErrBadRequest
+		return
+	}
+	switch promo.Type {
+	case models.PromotionBuyXGetY:
+		if promo.CategoryId == 0 || promo.BuyQuantity <= 0 || promo.FreeQuantity <= 0 {
+			log.Printf("CreatePromotion: category, buy and free quantities are required")
+			err = models.ErrBadRequest
+			return
+		}
+	case models.PromotionBundle:
+		if len(promo.ProductIds) < 2 {
+			log.Printf("CreatePromotion: bundle needs at least two products")
+			err = models.ErrBadRequest
+			return
+		}
+	case models.PromotionCartTotal:
+		if promo.MinTotal <= 0 {
+			log.Printf("CreatePromotion: min total is required")
+			err = models.ErrBadRequest
+			return
+		}
+	default:
+		log.Printf("CreatePromotion: unknown promotion type %v", promo.Type)
+		err = models.ErrBadRequest
+		return
+	}
+	if promo.Type != models.PromotionBuyXGetY && (promo.DiscountPercent <= 0 || promo.DiscountPercent > 100) {
+		log.Printf("CreatePromotion: discount percent must be between 1 and 100")
+		err = models.ErrBadRequest
+		return
+	}
+	newPromoId, err = prs.pr.CreatePromotion(promo)
+	return
+}
+
+func (prs *PromotionService) SetPromotionActive(promoId int, active bool) (err error) {
+	err = prs.pr.SetPromotionActive(promoId, active)
+	return
+}
+
+// ApplyPromotions evaluates active rules against the cart lines, fills in Discount
+// and Promotions of every affected item and returns the total discount.
+// Item rules (buy X get Y, bundles) are applied first, then the best matching
+// cart total tier is applied to what is left of every line.
+func (prs *PromotionService) ApplyPromotions(items []entities.CartItem) (discount models.Money, err error) {
+	promos, e := prs.pr.GetActivePromotions()
+	if e != nil {
+		err = e
+		return
+	}
+
+	var tiers []models.Promotion_db
+	for _, promo := range promos {
+		switch promo.Type {
+		case models.PromotionBuyXGetY:
+			err = prs.applyBuyXGetY(items, promo)
+			if err != nil {
+				return
+			}
+		case models.PromotionBundle:
+			applyBundle(items, promo)
+		case models.PromotionCartTotal:
+			tiers = append(tiers, promo)
+		}
+	}
+
+	net := cartNetTotal(items)
+	var bestTier *models.Promotion_db
+	for i := range tiers {
+		if tiers[i].MinTotal <= net && (bestTier == nil || tiers[i].MinTotal > bestTier.MinTotal) {
+			bestTier = &tiers[i]
+		}
+	}
+	if bestTier != nil {
+		for i := range items {
+			left := items[i].SumPrice - items[i].Discount
+			addDiscount(&items[i], *bestTier, left.Percent(bestTier.DiscountPercent))
+		}
+	}
+
+	for _, v := range items {
+		discount = discount + v.Discount
+	}
+	return
+}
+
+// buy X get Y in a category: for every X+Y units the cheapest Y units are free
+func (prs *PromotionService) applyBuyXGetY(items []entities.CartItem, promo models.Promotion_db) (err error) {
+	group := promo.BuyQuantity + promo.FreeQuantity
+	if promo.BuyQuantity <= 0 || promo.FreeQuantity <= 0 {
+		return
+	}
+	var idx []int
+	var total int
+	for i, v := range items {
+		cat, e := prs.prr.GetProductCategory(v.Id)
+		if e != nil {
+			err = e
+			return
+		}
+		if cat.Id == promo.CategoryId {
+			idx = append(idx, i)
+			total = total + v.Quantity
+		}
+	}
+	free := (total / group) * promo.FreeQuantity
+	sort.Slice(idx, func(a, b int) bool { return items[idx[a]].Price < items[idx[b]].Price })
+	for _, i := range idx {
+		if free == 0 {
+			break
+		}
+		units := min(free, items[i].Quantity)
+		addDiscount(&items[i], promo, items[i].Price.Mul(units))
+		free = free - units
+	}
+	return
+}
+
+// bundle: a percent off every complete set of the listed products
+func applyBundle(items []entities.CartItem, promo models.Promotion_db) {
+	if len(promo.ProductIds) == 0 {
+		return
+	}
+	idx := make([]int, 0, len(promo.ProductIds))
+	sets := -1
+	for _, prodId := range promo.ProductIds {
+		found := -1
+		for i, v := range items {
+			if v.Id == prodId {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return
+		}
+		idx = append(idx, found)
+		if sets < 0 || items[found].Quantity < sets {
+			sets = items[found].Quantity
+		}
+	}
+	for _, i := range idx {
+		addDiscount(&items[i], promo, items[i].Price.Mul(sets).Percent(promo.DiscountPercent))
+	}
+}
+
+func addDiscount(item *entities.CartItem, promo models.Promotion_db, amount models.Money) {
+	left := item.SumPrice - item.Discount
+	if amount > left {
+		amount = left
+	}
+	if amount <= 0 {
+		return
+	}
+	item.Discount = item.Discount + amount
+	item.Promotions = append(item.Promotions, entities.AppliedPromotion{
+		PromotionId: promo.Id,
+		Name:        promo.Name,
+		Discount:    amount,
+	})
+}
+
+func cartNetTotal(items []entities.CartItem) (total models.Money) {
+	for _, v := range items {
+		total = total + v.SumPrice - v.Discount
+	}
+	return
+}
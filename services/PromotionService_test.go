@@ -0,0 +1,137 @@
+package services
+
+import (
+	"testing"
+	"toyStore/entities"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+type fakePromotionRepo struct {
+	repository.PromotionRepository
+	promos []models.Promotion_db
+}
+
+func (f *fakePromotionRepo) GetActivePromotions() (promos []models.Promotion_db, err error) {
+	return f.promos, nil
+}
+
+// fakeProductRepo knows only the categories and the products by id
+type fakeProductRepo struct {
+	repository.ProductRepository
+	categories map[int]int
+	products   map[int]models.Product_db
+}
+
+func (f *fakeProductRepo) GetProductCategory(prodId int) (cat entities.Category, err error) {
+	cat.Id = f.categories[prodId]
+	return
+}
+
+func (f *fakeProductRepo) GetProductById(id int) (pModel models.Product_db, exists bool, err error) {
+	pModel, exists = f.products[id]
+	return
+}
+
+func cartLine(id int, price models.Money, quantity int) entities.CartItem {
+	return entities.CartItem{Id: id, Price: price, Quantity: quantity, SumPrice: price.Mul(quantity)}
+}
+
+func TestApplyPromotions(t *testing.T) {
+	categories := map[int]int{1: 10, 2: 10, 3: 20}
+	buy2get1 := models.Promotion_db{Id: 1, Type: models.PromotionBuyXGetY, CategoryId: 10, BuyQuantity: 2, FreeQuantity: 1}
+	bundle := models.Promotion_db{Id: 2, Type: models.PromotionBundle, ProductIds: []int{1, 3}, DiscountPercent: 10}
+	tier5 := models.Promotion_db{Id: 3, Type: models.PromotionCartTotal, MinTotal: 5000, DiscountPercent: 5}
+	tier10 := models.Promotion_db{Id: 4, Type: models.PromotionCartTotal, MinTotal: 10000, DiscountPercent: 10}
+
+	tests := []struct {
+		name         string
+		promos       []models.Promotion_db
+		items        []entities.CartItem
+		wantDiscount models.Money
+		wantLines    []models.Money
+	}{
+		{
+			name:         "no promotions",
+			items:        []entities.CartItem{cartLine(1, 1000, 2)},
+			wantDiscount: 0,
+			wantLines:    []models.Money{0},
+		},
+		{
+			name:         "cheapest unit of the category is free",
+			promos:       []models.Promotion_db{buy2get1},
+			items:        []entities.CartItem{cartLine(1, 1000, 2), cartLine(2, 400, 1), cartLine(3, 100, 3)},
+			wantDiscount: 400,
+			wantLines:    []models.Money{0, 400, 0},
+		},
+		{
+			name:         "incomplete group gets nothing",
+			promos:       []models.Promotion_db{buy2get1},
+			items:        []entities.CartItem{cartLine(1, 1000, 1), cartLine(2, 400, 1)},
+			wantDiscount: 0,
+			wantLines:    []models.Money{0, 0},
+		},
+		{
+			name:         "free units spread over the cheapest lines",
+			promos:       []models.Promotion_db{buy2get1},
+			items:        []entities.CartItem{cartLine(1, 1000, 5), cartLine(2, 300, 1)},
+			wantDiscount: 1300,
+			wantLines:    []models.Money{1000, 300},
+		},
+		{
+			name:         "bundle discounts complete sets only",
+			promos:       []models.Promotion_db{bundle},
+			items:        []entities.CartItem{cartLine(1, 1000, 3), cartLine(3, 500, 2)},
+			wantDiscount: 300,
+			wantLines:    []models.Money{200, 100},
+		},
+		{
+			name:         "bundle without every product",
+			promos:       []models.Promotion_db{bundle},
+			items:        []entities.CartItem{cartLine(1, 1000, 3)},
+			wantDiscount: 0,
+			wantLines:    []models.Money{0},
+		},
+		{
+			name:         "highest reached cart tier wins",
+			promos:       []models.Promotion_db{tier5, tier10},
+			items:        []entities.CartItem{cartLine(1, 3000, 2), cartLine(3, 999, 1)},
+			wantDiscount: 350,
+			wantLines:    []models.Money{300, 50},
+		},
+		{
+			name:         "cart tier after the other discounts",
+			promos:       []models.Promotion_db{buy2get1, tier10},
+			items:        []entities.CartItem{cartLine(1, 4000, 4)},
+			wantDiscount: 5200,
+			wantLines:    []models.Money{5200},
+		},
+		{
+			name:         "cart tier not reached after the other discounts",
+			promos:       []models.Promotion_db{buy2get1, tier10},
+			items:        []entities.CartItem{cartLine(1, 3000, 3), cartLine(3, 1000, 1)},
+			wantDiscount: 3000,
+			wantLines:    []models.Money{3000, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prs := NewPromotionService(&fakePromotionRepo{promos: tt.promos}, &fakeProductRepo{categories: categories})
+			discount, err := prs.ApplyPromotions(tt.items)
+			if err != nil {
+				t.Fatalf("ApplyPromotions: %v", err)
+			}
+			if discount != tt.wantDiscount {
+				t.Errorf("discount = %v, want %v", discount, tt.wantDiscount)
+			}
+			for i, v := range tt.items {
+				if v.Discount != tt.wantLines[i] {
+					t.Errorf("line %d discount = %v, want %v", i, v.Discount, tt.wantLines[i])
+				}
+				if v.Discount > v.SumPrice {
+					t.Errorf("line %d discount %v is more than the line %v", i, v.Discount, v.SumPrice)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"log"
+	"strings"
+	"time"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+type ReturnService struct {
+	sr  repository.SessionRepository
+	or  repository.OrderRepository
+	rr  repository.ReturnRepository
+	pms PaymentService
+}
+
+func NewReturnService(sessionRepo repository.SessionRepository, orderRepo repository.OrderRepository, returnRepo repository.ReturnRepository, paymentService PaymentService) ReturnService {
+	return ReturnService{
+		sr:  sessionRepo,
+		or:  orderRepo,
+		rr:  returnRepo,
+		pms: paymentService,
+	}
+}
+
+// returnable order statuses, the order has to be confirmed: the stock is taken on the confirmation,
+// a paid order that was not confirmed is cancelled instead
+var returnableStatuses = []string{"confirmed", "partially_shipped", "shipped"}
+
+// CreateReturn requests the return of order lines of the current user's order
+func (rs *ReturnService) CreateReturn(sessionId string, orderId int, ret models.Return_db) (newReturnId int, err error) {
+	userId, _, _, e := rs.sr.GetUserSessionInfo(sessionId)
+	if e != nil {
+		log.Printf("CreateReturn: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	order, e := rs.or.GetOrderById(orderId)
+	if e != nil {
+		err = e
+		return
+	}
+	if order.UserData.Id != userId {
+		err = models.ErrNotFoundError
+		return
+	}
+	if !statusIn(order.Status, returnableStatuses) {
+		log.Printf("order %v can not be returned, status is %v", orderId, order.Status)
+		err = models.ErrNotAllowed
+		return
+	}
+	if order.RefundedAmount >= order.TotalPrice {
+		log.Printf("order %v can not be returned, it is refunded", orderId)
+		err = models.ErrNotAllowed
+		return
+	}
+	if len(ret.Items) == 0 {
+		log.Printf("CreateReturn: no items to return")
+		err = models.ErrBadRequest
+		return
+	}
+
+	returned, e := rs.rr.GetReturnedQuantities(orderId)
+	if e != nil {
+		err = e
+		return
+	}
+	for i, v := range ret.Items {
+		found := false
+		for _, item := range order.Products {
+			if item.ItemId != v.OrderItemId {
+				continue
+			}
+			found = true
+			if v.Quantity <= 0 || returned[item.ItemId]+v.Quantity > item.Quantity {
+				log.Printf("CreateReturn: quantity of the order item %v is invalid", item.ItemId)
+				err = models.ErrBadRequest
+				return
+			}
+			returned[item.ItemId] = returned[item.ItemId] + v.Quantity
+			ret.Items[i].ProductId = item.Id
+		}
+		if !found {
+			log.Printf("CreateReturn: order item %v is not in the order", v.OrderItemId)
+			err = models.ErrBadRequest
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	ret.OrderId = orderId
+	ret.UserId = userId
+	ret.Status = models.ReturnRequested
+	ret.Reason = strings.TrimSpace(ret.Reason)
+	ret.Comment = ""
+	ret.CreatedAt = now
+	ret.UpdatedAt = now
+	newReturnId, err = rs.rr.CreateReturn(ret)
+	return
+}
+
+func (rs *ReturnService) GetUserReturns(sessionId string) (rets []models.Return_db, err error) {
+	userId, _, _, e := rs.sr.GetUserSessionInfo(sessionId)
+	if e != nil {
+		log.Printf("GetUserReturns: %v", e)
+		err = models.ErrServerError
+		return
+	}
+	rets, err = rs.rr.SearchReturns(&userId, nil, nil)
+	return
+}
+
+func (rs *ReturnService) SearchReturns(orderId *int, status *string) (rets []models.Return_db, err error) {
+	rets, err = rs.rr.SearchReturns(nil, orderId, status)
+	return
+}
+
+func (rs *ReturnService) GetReturn(returnId int) (ret models.Return_db, err error) {
+	ret, ex, e := rs.rr.GetReturn(returnId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex {
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+// SetReturnStatus approves or rejects a requested return
+func (rs *ReturnService) SetReturnStatus(returnId int, req models.ReturnStatusRequest) (err error) {
+	if req.Status != models.ReturnApproved && req.Status != models.ReturnRejected {
+		log.Printf("SetReturnStatus: status %v is invalid", req.Status)
+		err = models.ErrBadRequest
+		return
+	}
+	err = rs.rr.SetReturnStatus(returnId, []string{models.ReturnRequested}, req.Status, strings.TrimSpace(req.Comment))
+	return
+}
+
+// ReceiveReturn records the items received back into stock, without items everything in the return is received
+func (rs *ReturnService) ReceiveReturn(returnId int, items []models.ReturnItem_db) (err error) {
+	ret, e := rs.GetReturn(returnId)
+	if e != nil {
+		err = e
+		return
+	}
+	if ret.Status != models.ReturnApproved {
+		log.Printf("return %v can not be received, status is %v", returnId, ret.Status)
+		err = models.ErrNotAllowed
+		return
+	}
+	for i := range ret.Items {
+		ret.Items[i].ReceivedQuantity = ret.Items[i].Quantity
+		if len(items) == 0 {
+			continue
+		}
+		ret.Items[i].ReceivedQuantity = 0
+		for _, v := range items {
+			if v.OrderItemId == ret.Items[i].OrderItemId {
+				ret.Items[i].ReceivedQuantity = v.ReceivedQuantity
+			}
+		}
+		if ret.Items[i].ReceivedQuantity < 0 || ret.Items[i].ReceivedQuantity > ret.Items[i].Quantity {
+			log.Printf("ReceiveReturn: received quantity of the order item %v is invalid", ret.Items[i].OrderItemId)
+			err = models.ErrBadRequest
+			return
+		}
+	}
+	err = rs.rr.ReceiveReturn(returnId, ret.Items)
+	return
+}
+
+// Refund refunds a return of the order or, without return_id, an arbitrary amount of the order
+func (rs *ReturnService) Refund(orderId int, req models.RefundRequest) (refund models.Refund_db, err error) {
+	if req.Amount < 0 {
+		log.Printf("Refund: amount can not be negative")
+		err = models.ErrBadRequest
+		return
+	}
+	if req.ReturnId == 0 {
+		refund, err = rs.pms.RefundOrder(orderId, req.Amount, nil)
+		return
+	}
+
+	ret, e := rs.GetReturn(req.ReturnId)
+	if e != nil {
+		err = e
+		return
+	}
+	if ret.OrderId != orderId {
+		err = models.ErrNotFoundError
+		return
+	}
+	// the goods are put back into stock before the refund, a refunded return can not be received
+	if ret.Status != models.ReturnReceived {
+		log.Printf("return %v can not be refunded, status is %v", ret.Id, ret.Status)
+		err = models.ErrNotAllowed
+		return
+	}
+	amount := req.Amount
+	if amount == 0 {
+		amount, err = rs.returnValue(ret)
+		if err != nil {
+			return
+		}
+		// zero amount would refund the whole order
+		if amount == 0 {
+			log.Printf("return %v can not be refunded, nothing was received", ret.Id)
+			err = models.ErrNotAllowed
+			return
+		}
+	}
+	refund, err = rs.pms.RefundOrder(orderId, amount, &ret.Id)
+	return
+}
+
+// returnValue is the share of the received quantity in the paid line totals, with discounts and taxes
+func (rs *ReturnService) returnValue(ret models.Return_db) (value models.Money, err error) {
+	items, e := rs.or.GetOrderItems(ret.OrderId)
+	if e != nil {
+		err = e
+		return
+	}
+	for _, v := range ret.Items {
+		for _, item := range items {
+			if item.ItemId == v.OrderItemId {
+				value = value + item.TotalPrice.MulRatio(int64(v.ReceivedQuantity), int64(item.Quantity))
+			}
+		}
+	}
+	return
+}
+
+func statusIn(status string, statuses []string) bool {
+	for _, v := range statuses {
+		if v == status {
+			return true
+		}
+	}
+	return false
+}
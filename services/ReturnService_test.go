@@ -0,0 +1,355 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"toyStore/entities"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+type fakeSessionRepo struct {
+	repository.SessionRepository
+	userId int
+}
+
+func (f *fakeSessionRepo) GetUserSessionInfo(sessionId string) (userId int, role string, exists bool, err error) {
+	return f.userId, "user", sessionId != "", nil
+}
+
+type fakeOrderRepo struct {
+	repository.OrderRepository
+	order entities.Order
+}
+
+func (f *fakeOrderRepo) GetOrderById(orderId int) (order entities.Order, err error) {
+	if orderId != f.order.OrderId {
+		err = models.ErrNotFoundError
+		return
+	}
+	return f.order, nil
+}
+
+func (f *fakeOrderRepo) GetOrderItems(orderId int) (prods []entities.ProductOrderFormat, err error) {
+	return f.order.Products, nil
+}
+
+// fakeReturnRepo keeps one return and the stock, with the same status guards as the db
+type fakeReturnRepo struct {
+	repository.ReturnRepository
+	ret   models.Return_db
+	stock map[int]int
+}
+
+func (f *fakeReturnRepo) CreateReturn(ret models.Return_db) (newReturnId int, err error) {
+	ret.Id = 1
+	f.ret = ret
+	return ret.Id, nil
+}
+
+func (f *fakeReturnRepo) GetReturn(returnId int) (ret models.Return_db, exists bool, err error) {
+	return f.ret, returnId == f.ret.Id, nil
+}
+
+func (f *fakeReturnRepo) GetReturnedQuantities(orderId int) (quantities map[int]int, err error) {
+	return map[int]int{}, nil
+}
+
+func (f *fakeReturnRepo) SetReturnStatus(returnId int, from []string, status string, comment string) (err error) {
+	if !statusIn(f.ret.Status, from) {
+		return models.ErrNotAllowed
+	}
+	f.ret.Status = status
+	return
+}
+
+func (f *fakeReturnRepo) ReceiveReturn(returnId int, items []models.ReturnItem_db) (err error) {
+	if f.ret.Status != models.ReturnApproved {
+		return models.ErrNotAllowed
+	}
+	f.ret.Status = models.ReturnReceived
+	for _, v := range items {
+		f.stock[v.ProductId] = f.stock[v.ProductId] + v.ReceivedQuantity
+	}
+	return
+}
+
+type fakePaymentRepo struct {
+	repository.PaymentRepository
+	payment models.Payment_db
+	refunds []models.Refund_db
+	rr      *fakeReturnRepo
+}
+
+func (f *fakePaymentRepo) GetSucceededPayment(orderId int) (payment models.Payment_db, exists bool, err error) {
+	return f.payment, f.payment.Id != 0, nil
+}
+
+func (f *fakePaymentRepo) GetOrderRefunds(orderId int) (refunds []models.Refund_db, err error) {
+	return f.refunds, nil
+}
+
+func (f *fakePaymentRepo) CreateRefund(refund models.Refund_db) (newRefundId int, err error) {
+	if refund.ReturnId != nil {
+		if f.rr.ret.Status != models.ReturnReceived {
+			return 0, models.ErrNotAllowed
+		}
+		f.rr.ret.Status = models.ReturnRefunding
+	}
+	refund.Status = models.RefundPending
+	f.refunds = append(f.refunds, refund)
+	return len(f.refunds), nil
+}
+
+func (f *fakePaymentRepo) CompleteRefund(refundId int, providerRefundId string) (err error) {
+	f.refunds[refundId-1].Status = models.RefundSucceeded
+	if f.rr.ret.Status == models.ReturnRefunding {
+		f.rr.ret.Status = models.ReturnRefunded
+	}
+	return
+}
+
+func (f *fakePaymentRepo) FailRefund(refundId int) (err error) {
+	f.refunds[refundId-1].Status = models.RefundFailed
+	if f.rr.ret.Status == models.ReturnRefunding {
+		f.rr.ret.Status = models.ReturnReceived
+	}
+	return
+}
+
+// countingProvider counts the refunds made, a failing one refuses them
+type countingProvider struct {
+	*FakePaymentProvider
+	refunds int
+	fail    bool
+}
+
+func (cp *countingProvider) Refund(payment models.Payment_db, amount models.Money) (providerRefundId string, err error) {
+	if cp.fail {
+		return "", errors.New("provider is down")
+	}
+	cp.refunds++
+	return cp.FakePaymentProvider.Refund(payment, amount)
+}
+
+const testCustomerId = 7
+
+func newTestReturnService(status string, ret models.Return_db) (ReturnService, *fakeReturnRepo, *fakePaymentRepo) {
+	order := entities.Order{
+		OrderId:      5,
+		Status:       status,
+		TotalPrice:   5000,
+		ExchangeRate: models.RateScale,
+		UserData:     models.UserData{Id: testCustomerId},
+		Products: []entities.ProductOrderFormat{
+			{ItemId: 11, Id: 101, Quantity: 3, TotalPrice: 3000},
+			{ItemId: 12, Id: 102, Quantity: 1, TotalPrice: 2000},
+		},
+	}
+	or := &fakeOrderRepo{order: order}
+	rr := &fakeReturnRepo{ret: ret, stock: map[int]int{}}
+	pr := &fakePaymentRepo{payment: models.Payment_db{Id: 1, OrderId: 5, Provider: "fake", Amount: 5000, Currency: "RUB"}, rr: rr}
+	sr := &fakeSessionRepo{userId: testCustomerId}
+	pms := NewPaymentService(sr, or, pr, NewFakePaymentProvider("test"))
+	return NewReturnService(sr, or, rr, pms), rr, pr
+}
+
+func TestCreateReturnOrderStatus(t *testing.T) {
+	tests := []struct {
+		status   string
+		refunded models.Money
+		wantErr  error
+	}{
+		{"created", 0, models.ErrNotAllowed},
+		{"paid", 0, models.ErrNotAllowed},
+		{"confirmed", 0, nil},
+		{"shipped", 0, nil},
+		{"shipped", 1000, nil},
+		{"shipped", 5000, models.ErrNotAllowed},
+		{"cancelled", 0, models.ErrNotAllowed},
+	}
+	for _, tt := range tests {
+		rs, rr, _ := newTestReturnService(tt.status, models.Return_db{})
+		rs.or.(*fakeOrderRepo).order.RefundedAmount = tt.refunded
+		_, err := rs.CreateReturn("session", 5, models.Return_db{Items: []models.ReturnItem_db{{OrderItemId: 11, Quantity: 1}}})
+		if err != tt.wantErr {
+			t.Errorf("CreateReturn of a %v order refunded by %v: error = %v, want %v", tt.status, tt.refunded, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (rr.ret.Status != models.ReturnRequested || rr.ret.Items[0].ProductId != 101) {
+			t.Errorf("CreateReturn of a %v order: return = %+v", tt.status, rr.ret)
+		}
+	}
+}
+
+func TestCreateReturnQuantity(t *testing.T) {
+	tests := []struct {
+		name    string
+		items   []models.ReturnItem_db
+		wantErr error
+	}{
+		{"whole line", []models.ReturnItem_db{{OrderItemId: 11, Quantity: 3}}, nil},
+		{"more than ordered", []models.ReturnItem_db{{OrderItemId: 11, Quantity: 4}}, models.ErrBadRequest},
+		{"zero quantity", []models.ReturnItem_db{{OrderItemId: 11, Quantity: 0}}, models.ErrBadRequest},
+		{"same line twice over the quantity", []models.ReturnItem_db{{OrderItemId: 11, Quantity: 2}, {OrderItemId: 11, Quantity: 2}}, models.ErrBadRequest},
+		{"line of another order", []models.ReturnItem_db{{OrderItemId: 99, Quantity: 1}}, models.ErrBadRequest},
+		{"no items", nil, models.ErrBadRequest},
+	}
+	for _, tt := range tests {
+		rs, _, _ := newTestReturnService("confirmed", models.Return_db{})
+		_, err := rs.CreateReturn("session", 5, models.Return_db{Items: tt.items})
+		if err != tt.wantErr {
+			t.Errorf("%v: error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestReturnTransitions(t *testing.T) {
+	const (
+		approve = "approve"
+		reject  = "reject"
+		receive = "receive"
+		refund  = "refund"
+	)
+	tests := []struct {
+		from       string
+		action     string
+		wantErr    error
+		wantStatus string
+		wantStock  int
+	}{
+		{models.ReturnRequested, approve, nil, models.ReturnApproved, 0},
+		{models.ReturnRequested, reject, nil, models.ReturnRejected, 0},
+		{models.ReturnRequested, receive, models.ErrNotAllowed, models.ReturnRequested, 0},
+		{models.ReturnRequested, refund, models.ErrNotAllowed, models.ReturnRequested, 0},
+		{models.ReturnApproved, approve, models.ErrNotAllowed, models.ReturnApproved, 0},
+		{models.ReturnApproved, receive, nil, models.ReturnReceived, 2},
+		{models.ReturnApproved, refund, models.ErrNotAllowed, models.ReturnApproved, 0},
+		{models.ReturnRejected, receive, models.ErrNotAllowed, models.ReturnRejected, 0},
+		{models.ReturnRejected, refund, models.ErrNotAllowed, models.ReturnRejected, 0},
+		{models.ReturnReceived, receive, models.ErrNotAllowed, models.ReturnReceived, 0},
+		{models.ReturnReceived, refund, nil, models.ReturnRefunded, 0},
+		{models.ReturnRefunded, receive, models.ErrNotAllowed, models.ReturnRefunded, 0},
+		{models.ReturnRefunded, refund, models.ErrNotAllowed, models.ReturnRefunded, 0},
+	}
+	for _, tt := range tests {
+		ret := models.Return_db{Id: 1, OrderId: 5, Status: tt.from, Items: []models.ReturnItem_db{{Id: 1, OrderItemId: 11, ProductId: 101, Quantity: 2, ReceivedQuantity: 2}}}
+		rs, rr, _ := newTestReturnService("confirmed", ret)
+		var err error
+		switch tt.action {
+		case approve:
+			err = rs.SetReturnStatus(1, models.ReturnStatusRequest{Status: models.ReturnApproved})
+		case reject:
+			err = rs.SetReturnStatus(1, models.ReturnStatusRequest{Status: models.ReturnRejected})
+		case receive:
+			err = rs.ReceiveReturn(1, nil)
+		case refund:
+			_, err = rs.Refund(5, models.RefundRequest{ReturnId: 1})
+		}
+		if err != tt.wantErr {
+			t.Errorf("%v a %v return: error = %v, want %v", tt.action, tt.from, err, tt.wantErr)
+		}
+		if rr.ret.Status != tt.wantStatus {
+			t.Errorf("%v a %v return: status = %v, want %v", tt.action, tt.from, rr.ret.Status, tt.wantStatus)
+		}
+		if rr.stock[101] != tt.wantStock {
+			t.Errorf("%v a %v return: stock = %v, want %v", tt.action, tt.from, rr.stock[101], tt.wantStock)
+		}
+	}
+}
+
+func TestReceiveReturnQuantities(t *testing.T) {
+	tests := []struct {
+		name      string
+		items     []models.ReturnItem_db
+		wantErr   error
+		wantStock int
+	}{
+		{"everything without items", nil, nil, 2},
+		{"part of the line", []models.ReturnItem_db{{OrderItemId: 11, ReceivedQuantity: 1}}, nil, 1},
+		{"nothing of the line", []models.ReturnItem_db{{OrderItemId: 12, ReceivedQuantity: 1}}, nil, 0},
+		{"more than returned", []models.ReturnItem_db{{OrderItemId: 11, ReceivedQuantity: 3}}, models.ErrBadRequest, 0},
+		{"negative", []models.ReturnItem_db{{OrderItemId: 11, ReceivedQuantity: -1}}, models.ErrBadRequest, 0},
+	}
+	for _, tt := range tests {
+		ret := models.Return_db{Id: 1, OrderId: 5, Status: models.ReturnApproved, Items: []models.ReturnItem_db{{Id: 1, OrderItemId: 11, ProductId: 101, Quantity: 2}}}
+		rs, rr, _ := newTestReturnService("confirmed", ret)
+		err := rs.ReceiveReturn(1, tt.items)
+		if err != tt.wantErr {
+			t.Errorf("%v: error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		if rr.stock[101] != tt.wantStock {
+			t.Errorf("%v: stock = %v, want %v", tt.name, rr.stock[101], tt.wantStock)
+		}
+	}
+}
+
+func TestRefundAmount(t *testing.T) {
+	tests := []struct {
+		name       string
+		items      []models.ReturnItem_db
+		amount     models.Money
+		refunded   models.Money
+		wantErr    error
+		wantAmount models.Money
+	}{
+		{"share of the line", []models.ReturnItem_db{{OrderItemId: 11, Quantity: 1, ReceivedQuantity: 1}}, 0, 0, nil, 1000},
+		{"two lines", []models.ReturnItem_db{{OrderItemId: 11, Quantity: 3, ReceivedQuantity: 3}, {OrderItemId: 12, Quantity: 1, ReceivedQuantity: 1}}, 0, 0, nil, 5000},
+		{"part of the items received", []models.ReturnItem_db{{OrderItemId: 11, Quantity: 3, ReceivedQuantity: 1}}, 0, 0, nil, 1000},
+		{"nothing received", []models.ReturnItem_db{{OrderItemId: 11, Quantity: 3, ReceivedQuantity: 0}}, 0, 0, models.ErrNotAllowed, 0},
+		{"amount of the manager", []models.ReturnItem_db{{OrderItemId: 11, Quantity: 1, ReceivedQuantity: 1}}, 500, 0, nil, 500},
+		{"more than left", []models.ReturnItem_db{{OrderItemId: 12, Quantity: 1, ReceivedQuantity: 1}}, 0, 4000, models.ErrNotAllowed, 0},
+		{"negative amount", []models.ReturnItem_db{{OrderItemId: 11, Quantity: 1, ReceivedQuantity: 1}}, -1, 0, models.ErrBadRequest, 0},
+	}
+	for _, tt := range tests {
+		ret := models.Return_db{Id: 1, OrderId: 5, Status: models.ReturnReceived, Items: tt.items}
+		rs, _, pr := newTestReturnService("confirmed", ret)
+		order := rs.or.(*fakeOrderRepo)
+		order.order.RefundedAmount = tt.refunded
+		refund, err := rs.Refund(5, models.RefundRequest{ReturnId: 1, Amount: tt.amount})
+		if err != tt.wantErr {
+			t.Errorf("%v: error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (refund.Amount != tt.wantAmount || len(pr.refunds) != 1) {
+			t.Errorf("%v: refund = %v, want %v", tt.name, refund.Amount, tt.wantAmount)
+		}
+	}
+}
+
+func TestRefundReturnOnce(t *testing.T) {
+	tests := []struct {
+		name        string
+		fail        bool
+		wantErr     error
+		wantStatus  string
+		wantRefunds int
+	}{
+		{"provider refunds", false, nil, models.ReturnRefunded, 1},
+		{"provider fails", true, models.ErrServerError, models.ReturnReceived, 0},
+	}
+	for _, tt := range tests {
+		ret := models.Return_db{Id: 1, OrderId: 5, Status: models.ReturnReceived, Items: []models.ReturnItem_db{{OrderItemId: 11, Quantity: 1, ReceivedQuantity: 1}}}
+		rs, rr, pr := newTestReturnService("shipped", ret)
+		cp := &countingProvider{FakePaymentProvider: NewFakePaymentProvider("test"), fail: tt.fail}
+		pms := NewPaymentService(rs.sr, rs.or, pr, cp)
+		returnId := 1
+		_, err := pms.RefundOrder(5, 1000, &returnId)
+		if err != tt.wantErr {
+			t.Errorf("%v: error = %v, want %v", tt.name, err, tt.wantErr)
+		}
+		// a second request that passed the checks at the same time loses the claim of the return
+		if !tt.fail {
+			_, err = pms.RefundOrder(5, 1000, &returnId)
+			if err != models.ErrNotAllowed {
+				t.Errorf("%v: second refund error = %v, want %v", tt.name, err, models.ErrNotAllowed)
+			}
+		}
+		if rr.ret.Status != tt.wantStatus {
+			t.Errorf("%v: return status = %v, want %v", tt.name, rr.ret.Status, tt.wantStatus)
+		}
+		if cp.refunds != tt.wantRefunds {
+			t.Errorf("%v: provider refunds = %v, want %v", tt.name, cp.refunds, tt.wantRefunds)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+type RoleService struct {
+	rr repository.RoleRepository
+}
+
+func NewRoleService(roleRepo repository.RoleRepository) RoleService {
+	return RoleService{
+		rr: roleRepo,
+	}
+}
+
+// built-in roles: new users get "user", "manager" keeps every permission so nobody is locked out
+const (
+	roleUser    = "user"
+	roleManager = "manager"
+)
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{1,31}$`)
+
+func (rls *RoleService) GetRoles() (roles []models.Role_db, err error) {
+	roles, err = rls.rr.GetRoles()
+	return
+}
+
+func (rls *RoleService) GetPermissions() []string {
+	return models.Permissions
+}
+
+func (rls *RoleService) CreateRole(role models.Role_db) (err error) {
+	err = validateRole(&role)
+	if err != nil {
+		return
+	}
+	err = rls.rr.CreateRole(role)
+	return
+}
+
+func (rls *RoleService) UpdateRole(role models.Role_db) (err error) {
+	err = validateRole(&role)
+	if err != nil {
+		return
+	}
+	if role.Name == roleManager {
+		log.Printf("role %v can not be changed", role.Name)
+		err = models.ErrNotAllowed
+		return
+	}
+	err = rls.rr.UpdateRole(role)
+	return
+}
+
+func (rls *RoleService) DeleteRole(name string) (err error) {
+	if name == roleUser || name == roleManager {
+		log.Printf("role %v can not be deleted", name)
+		err = models.ErrNotAllowed
+		return
+	}
+	err = rls.rr.DeleteRole(name)
+	return
+}
+
+func validateRole(role *models.Role_db) (err error) {
+	role.Description = strings.TrimSpace(role.Description)
+	if !roleNamePattern.MatchString(role.Name) {
+		log.Printf("role name %q is invalid", role.Name)
+		err = models.ErrBadRequest
+		return
+	}
+	unique := []string{}
+	for _, v := range role.Permissions {
+		if !statusIn(v, models.Permissions) {
+			log.Printf("permission %q is unknown", v)
+			err = models.ErrBadRequest
+			return
+		}
+		if !statusIn(v, unique) {
+			unique = append(unique, v)
+		}
+	}
+	role.Permissions = unique
+	return
+}
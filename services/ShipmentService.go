@@ -0,0 +1,155 @@
+package services
+
+import (
+	"log"
+	"strings"
+	"time"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+type ShipmentService struct {
+	or  repository.OrderRepository
+	shr repository.ShipmentRepository
+}
+
+func NewShipmentService(orderRepo repository.OrderRepository, shipmentRepo repository.ShipmentRepository) ShipmentService {
+	return ShipmentService{
+		or:  orderRepo,
+		shr: shipmentRepo,
+	}
+}
+
+func (shs *ShipmentService) GetCarriers() (carriers []models.Carrier_db, err error) {
+	carriers, err = shs.shr.GetCarriers(false)
+	return
+}
+
+func (shs *ShipmentService) CreateCarrier(carrier models.Carrier_db) (newCarrierId int, err error) {
+	err = validateCarrier(&carrier)
+	if err != nil {
+		return
+	}
+	newCarrierId, err = shs.shr.CreateCarrier(carrier)
+	return
+}
+
+func (shs *ShipmentService) UpdateCarrier(carrier models.Carrier_db) (err error) {
+	err = validateCarrier(&carrier)
+	if err != nil {
+		return
+	}
+	err = shs.shr.UpdateCarrier(carrier)
+	return
+}
+
+// CreateShipment ships the given lines of a confirmed order. Without items all lines
+// that are not shipped yet are shipped.
+func (shs *ShipmentService) CreateShipment(orderId int, shipment models.Shipment_db) (newShipmentId int, err error) {
+	shipment.TrackingNumber = strings.TrimSpace(shipment.TrackingNumber)
+	if !validTrackingNumber(shipment.TrackingNumber) {
+		log.Printf("CreateShipment: invalid tracking number %q", shipment.TrackingNumber)
+		err = models.ErrBadRequest
+		return
+	}
+	carrier, ex, e := shs.shr.GetCarrier(shipment.CarrierId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex || !carrier.Active {
+		log.Printf("Carrier %v is unavailable", shipment.CarrierId)
+		err = models.ErrBadRequest
+		return
+	}
+	order, e := shs.or.GetOrderById(orderId)
+	if e != nil {
+		err = e
+		return
+	}
+	if order.Status != "confirmed" && order.Status != "partially_shipped" {
+		log.Printf("order %v can not be shipped, status is %v", orderId, order.Status)
+		err = models.ErrNotAllowed
+		return
+	}
+	shipped, e := shs.shr.GetShippedQuantities(orderId)
+	if e != nil {
+		err = e
+		return
+	}
+
+	if len(shipment.Items) == 0 {
+		for _, item := range order.Products {
+			if left := item.Quantity - shipped[item.ItemId]; left > 0 {
+				shipment.Items = append(shipment.Items, models.ShipmentItem_db{OrderItemId: item.ItemId, Quantity: left})
+			}
+		}
+		if len(shipment.Items) == 0 {
+			log.Printf("CreateShipment: order %v has nothing to ship", orderId)
+			err = models.ErrNotAllowed
+			return
+		}
+	}
+	for i, v := range shipment.Items {
+		found := false
+		for _, item := range order.Products {
+			if item.ItemId != v.OrderItemId {
+				continue
+			}
+			found = true
+			if v.Quantity <= 0 || shipped[item.ItemId]+v.Quantity > item.Quantity {
+				log.Printf("CreateShipment: quantity of the order item %v is invalid", item.ItemId)
+				err = models.ErrBadRequest
+				return
+			}
+			shipped[item.ItemId] = shipped[item.ItemId] + v.Quantity
+			shipment.Items[i].ProductId = item.Id
+		}
+		if !found {
+			log.Printf("CreateShipment: order item %v is not in the order", v.OrderItemId)
+			err = models.ErrBadRequest
+			return
+		}
+	}
+
+	shipment.OrderId = orderId
+	shipment.ShippedAt = time.Now().UTC()
+	newShipmentId, err = shs.shr.CreateShipment(shipment)
+	return
+}
+
+func (shs *ShipmentService) GetOrderShipments(orderId int) (shipments []models.Shipment_db, err error) {
+	shipments, err = shs.shr.GetOrderShipments(orderId)
+	return
+}
+
+// validTrackingNumber allows only latin letters, digits and dashes, so the number is safe in any url template
+func validTrackingNumber(number string) bool {
+	if len(number) == 0 || len(number) > 64 {
+		return false
+	}
+	for _, c := range number {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+func validateCarrier(carrier *models.Carrier_db) (err error) {
+	carrier.Name = strings.TrimSpace(carrier.Name)
+	carrier.TrackingUrlTemplate = strings.TrimSpace(carrier.TrackingUrlTemplate)
+	if carrier.Name == "" {
+		err = models.ErrBadRequest
+		return
+	}
+	// a carrier without tracking is allowed, a template must be an url with the number in it
+	if carrier.TrackingUrlTemplate != "" {
+		if !strings.HasPrefix(carrier.TrackingUrlTemplate, "https://") && !strings.HasPrefix(carrier.TrackingUrlTemplate, "http://") ||
+			!strings.Contains(carrier.TrackingUrlTemplate, "{tracking}") {
+			log.Printf("Tracking url template %q is invalid", carrier.TrackingUrlTemplate)
+			err = models.ErrBadRequest
+		}
+	}
+	return
+}
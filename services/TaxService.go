@@ -0,0 +1,122 @@
+package services
+
+import (
+	"log"
+	"strings"
+	"toyStore/entities"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+type TaxService struct {
+	tr            repository.TaxRepository
+	defaultRegion string
+}
+
+func NewTaxService(taxRepo repository.TaxRepository, defaultRegion string) TaxService {
+	return TaxService{
+		tr:            taxRepo,
+		defaultRegion: strings.ToUpper(defaultRegion),
+	}
+}
+
+// Region normalizes the region code, empty region means the default one
+func (ts *TaxService) Region(region string) string {
+	if region == "" {
+		return ts.defaultRegion
+	}
+	return strings.ToUpper(region)
+}
+
+func (ts *TaxService) GetTaxClasses() (classes []entities.TaxClass, err error) {
+	classes, err = ts.tr.GetTaxClasses()
+	return
+}
+
+func (ts *TaxService) CreateTaxClass(class models.TaxClass_db) (newClassId int, err error) {
+	if class.Name == "" {
+		log.Printf("tax class name can not be empty")
+		err = models.ErrBadRequest
+		return
+	}
+	newClassId, err = ts.tr.CreateTaxClass(class)
+	return
+}
+
+func (ts *TaxService) SetTaxRate(rate models.TaxRate_db) (err error) {
+	rate.Region = strings.ToUpper(rate.Region)
+	if rate.Region == "" || rate.Rate < 0 || rate.Rate > 10000 {
+		log.Printf("SetTaxRate: region or rate is invalid")
+		err = models.ErrBadRequest
+		return
+	}
+	err = ts.checkTaxClass(rate.TaxClassId)
+	if err != nil {
+		return
+	}
+	err = ts.tr.SetTaxRate(rate)
+	return
+}
+
+func (ts *TaxService) SetProductTaxClass(prodId int, classId int) (err error) {
+	err = ts.checkTaxClass(classId)
+	if err != nil {
+		return
+	}
+	err = ts.tr.SetProductTaxClass(prodId, classId)
+	return
+}
+
+func (ts *TaxService) SetCategoryTaxClass(catId int, classId int) (err error) {
+	err = ts.checkTaxClass(classId)
+	if err != nil {
+		return
+	}
+	err = ts.tr.SetCategoryTaxClass(catId, classId)
+	return
+}
+
+// checkTaxClass allows 0, which removes the tax class
+func (ts *TaxService) checkTaxClass(classId int) (err error) {
+	if classId == 0 {
+		return
+	}
+	ex, e := ts.tr.TaxClassExist(classId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex {
+		log.Printf("Tax class does not exist")
+		err = models.ErrNotAllowed
+	}
+	return
+}
+
+// ApplyTaxes calculates the tax of every line after discounts. Inclusive taxes are
+// already part of the price, exclusive ones are returned in addToTotal as well.
+func (ts *TaxService) ApplyTaxes(items []entities.CartItem, region string) (tax models.Money, addToTotal models.Money, err error) {
+	region = ts.Region(region)
+	for i := range items {
+		rate, ex, e := ts.tr.GetProductTaxRate(items[i].Id, region)
+		if e != nil {
+			err = e
+			return
+		}
+		items[i].Tax = 0
+		items[i].TaxIncluded = false
+		if !ex {
+			continue
+		}
+		net := items[i].SumPrice - items[i].Discount
+		if rate.Inclusive {
+			items[i].Tax = net.IncludedTax(rate.Rate)
+			items[i].TaxIncluded = true
+		} else {
+			items[i].Tax = net.TaxOf(rate.Rate)
+			addToTotal = addToTotal + items[i].Tax
+		}
+		tax = tax + items[i].Tax
+	}
+	return
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"testing"
+	"toyStore/entities"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+// fakeTaxRepo keeps the rates by product id, the region has to match
+type fakeTaxRepo struct {
+	repository.TaxRepository
+	rates map[int]models.TaxRate_db
+}
+
+func (f *fakeTaxRepo) GetProductTaxRate(prodId int, region string) (rate models.TaxRate_db, exists bool, err error) {
+	rate, exists = f.rates[prodId]
+	if exists && rate.Region != region {
+		return models.TaxRate_db{}, false, nil
+	}
+	return
+}
+
+func TestApplyTaxes(t *testing.T) {
+	repo := &fakeTaxRepo{rates: map[int]models.TaxRate_db{
+		1: {Region: "RU", Rate: 2000, Inclusive: true},
+		2: {Region: "RU", Rate: 1000},
+		3: {Region: "KZ", Rate: 1200},
+	}}
+	ts := NewTaxService(repo, "ru")
+
+	tests := []struct {
+		name        string
+		items       []entities.CartItem
+		region      string
+		wantTax     models.Money
+		wantAdd     models.Money
+		wantLineTax []models.Money
+	}{
+		{
+			name:        "inclusive tax is part of the price",
+			items:       []entities.CartItem{{Id: 1, SumPrice: 12000}},
+			wantTax:     2000,
+			wantAdd:     0,
+			wantLineTax: []models.Money{2000},
+		},
+		{
+			name:        "exclusive tax is added to the total",
+			items:       []entities.CartItem{{Id: 2, SumPrice: 10000}},
+			wantTax:     1000,
+			wantAdd:     1000,
+			wantLineTax: []models.Money{1000},
+		},
+		{
+			name:        "tax is calculated after the discount",
+			items:       []entities.CartItem{{Id: 2, SumPrice: 10000, Discount: 2500}},
+			wantTax:     750,
+			wantAdd:     750,
+			wantLineTax: []models.Money{750},
+		},
+		{
+			name:        "no rate in the region",
+			items:       []entities.CartItem{{Id: 3, SumPrice: 10000}},
+			wantTax:     0,
+			wantAdd:     0,
+			wantLineTax: []models.Money{0},
+		},
+		{
+			name:        "rate of the requested region",
+			items:       []entities.CartItem{{Id: 3, SumPrice: 10000}},
+			region:      "kz",
+			wantTax:     1200,
+			wantAdd:     1200,
+			wantLineTax: []models.Money{1200},
+		},
+		{
+			name: "mixed lines, every line is rounded",
+			items: []entities.CartItem{
+				{Id: 1, SumPrice: 999},
+				{Id: 2, SumPrice: 999},
+				{Id: 4, SumPrice: 500},
+			},
+			wantTax:     267,
+			wantAdd:     100,
+			wantLineTax: []models.Money{167, 100, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tax, add, err := ts.ApplyTaxes(tt.items, tt.region)
+			if err != nil {
+				t.Fatalf("ApplyTaxes: %v", err)
+			}
+			if tax != tt.wantTax || add != tt.wantAdd {
+				t.Errorf("ApplyTaxes = %v, %v, want %v, %v", tax, add, tt.wantTax, tt.wantAdd)
+			}
+			for i, v := range tt.items {
+				if v.Tax != tt.wantLineTax[i] {
+					t.Errorf("line %d tax = %v, want %v", i, v.Tax, tt.wantLineTax[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+const (
+	totpPeriod         = 30
+	totpDigits         = 6
+	recoveryCodeCount  = 10
+	signinTotpToken    = "signin-totp"
+	signinChallengeTtl = 5 * time.Minute
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TotpService is the 2FA by time-based one-time codes (RFC 6238, SHA1, 6 digits, 30 seconds),
+// the codes of the previous and the next step are accepted for clock drift
+type TotpService struct {
+	tr   repository.TotpRepository
+	ur   repository.UserRepository
+	sr   repository.SessionRepository
+	tokR repository.TokenRepository
+	// issuer is shown in the authenticator app
+	issuer string
+	// the users of these roles need 2FA to use their permissions
+	requiredRoles []string
+}
+
+func NewTotpService(totpRepo repository.TotpRepository, userRepo repository.UserRepository, sessionRepo repository.SessionRepository, tokenRepo repository.TokenRepository, issuer string, requiredRoles []string) TotpService {
+	return TotpService{
+		tr:            totpRepo,
+		ur:            userRepo,
+		sr:            sessionRepo,
+		tokR:          tokenRepo,
+		issuer:        issuer,
+		requiredRoles: requiredRoles,
+	}
+}
+
+// RoleRequiresTotp tells if the policy needs 2FA for the role
+func (tos *TotpService) RoleRequiresTotp(role string) bool {
+	return slices.Contains(tos.requiredRoles, role)
+}
+
+// Setup makes a new secret for the current user. It is not used until Enable confirms a code of it.
+func (tos *TotpService) Setup(sessionId string) (setup models.TotpSetup, err error) {
+	uModel, err := tos.currentUser(sessionId)
+	if err != nil {
+		return
+	}
+	if uModel.TotpEnabled {
+		log.Printf("Setup: 2FA of the user %v is already enabled", uModel.Id)
+		err = models.ErrNotAllowed
+		return
+	}
+	secret := make([]byte, 20)
+	_, err = rand.Read(secret)
+	if err != nil {
+		log.Printf("Setup: %v", err)
+		err = models.ErrServerError
+		return
+	}
+	setup.Secret = totpEncoding.EncodeToString(secret)
+	err = tos.tr.SetTotpSecret(uModel.Id, setup.Secret)
+	if err != nil {
+		return
+	}
+	params := url.Values{}
+	params.Set("secret", setup.Secret)
+	params.Set("issuer", tos.issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(tos.issuer + ":" + uModel.Nickname)
+	setup.Uri = "otpauth://totp/" + label + "?" + params.Encode()
+	return
+}
+
+// Enable turns 2FA on by a code of the new secret and returns the recovery codes, they are shown only once.
+// The current session counts as signed in with 2FA.
+func (tos *TotpService) Enable(sessionId string, code string) (codes models.RecoveryCodes, err error) {
+	uModel, err := tos.currentUser(sessionId)
+	if err != nil {
+		return
+	}
+	if uModel.TotpEnabled || uModel.TotpSecret == "" {
+		log.Printf("Enable: 2FA of the user %v is enabled or not set up", uModel.Id)
+		err = models.ErrNotAllowed
+		return
+	}
+	ok, err := tos.checkCode(uModel, code)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = models.ErrBadRequest
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return
+	}
+	err = tos.tr.EnableTotp(uModel.Id, hashes)
+	if err != nil {
+		return
+	}
+	err = tos.sr.SetSessionMfa(sessionId)
+	return
+}
+
+// Disable turns 2FA off with the password and a code, not allowed when the role requires 2FA
+func (tos *TotpService) Disable(sessionId string, req models.TotpCodeRequest) (err error) {
+	uModel, err := tos.currentUser(sessionId)
+	if err != nil {
+		return
+	}
+	if !uModel.TotpEnabled || tos.RoleRequiresTotp(uModel.Role) {
+		log.Printf("Disable: 2FA of the user %v can not be disabled", uModel.Id)
+		err = models.ErrNotAllowed
+		return
+	}
+	if !tos.ur.VerifyPassword(uModel.Password, req.Password) {
+		err = models.ErrBadRequest
+		return
+	}
+	ok, err := tos.checkCode(uModel, req.Code)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = models.ErrBadRequest
+		return
+	}
+	err = tos.tr.DisableTotp(uModel.Id)
+	return
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes, the old ones stop working
+func (tos *TotpService) RegenerateRecoveryCodes(sessionId string, code string) (codes models.RecoveryCodes, err error) {
+	uModel, err := tos.currentUser(sessionId)
+	if err != nil {
+		return
+	}
+	if !uModel.TotpEnabled {
+		err = models.ErrNotAllowed
+		return
+	}
+	ok, err := tos.checkCode(uModel, code)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = models.ErrBadRequest
+		return
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return
+	}
+	err = tos.tr.ReplaceRecoveryCodes(uModel.Id, hashes)
+	return
+}
+
+// Reset turns 2FA off for a user who lost the device, the user sets it up again
+func (tos *TotpService) Reset(userId int) (err error) {
+	_, ex, e := tos.ur.GetUserById(userId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex {
+		err = models.ErrNotFoundError
+		return
+	}
+	err = tos.tr.DisableTotp(userId)
+	return
+}
+
+// StartChallenge returns a token for the second sign-in step, the password is already checked
+func (tos *TotpService) StartChallenge(userId int) (mfaToken string, err error) {
+	mfaToken, err = newToken()
+	if err != nil {
+		return
+	}
+	err = tos.tokR.SaveToken(signinTotpToken, hashToken(mfaToken), userId, signinChallengeTtl)
+	return
+}
+
+// ChallengeUser returns the user of the sign-in token, the token stays for another attempt
+func (tos *TotpService) ChallengeUser(mfaToken string) (userId int, exists bool, err error) {
+	userId, exists, err = tos.tokR.GetToken(signinTotpToken, hashToken(mfaToken))
+	return
+}
+
+// FinishChallenge deletes the sign-in token, false if it was already used
+func (tos *TotpService) FinishChallenge(mfaToken string) (ok bool, err error) {
+	_, ok, err = tos.tokR.TakeToken(signinTotpToken, hashToken(mfaToken))
+	return
+}
+
+// VerifySecondFactor checks a code of the app or, without it, a recovery code
+func (tos *TotpService) VerifySecondFactor(uModel models.User_db, code string, recoveryCode string) (ok bool, err error) {
+	if code != "" {
+		ok, err = tos.checkCode(uModel, code)
+		return
+	}
+	recoveryCode = normalizeRecoveryCode(recoveryCode)
+	if recoveryCode == "" {
+		return
+	}
+	ok, err = tos.tr.UseRecoveryCode(uModel.Id, hashToken(recoveryCode))
+	return
+}
+
+// checkCode accepts every code once, the step of an accepted code is saved
+func (tos *TotpService) checkCode(uModel models.User_db, code string) (ok bool, err error) {
+	code = strings.ReplaceAll(code, " ", "")
+	secret, e := totpEncoding.DecodeString(uModel.TotpSecret)
+	if e != nil || len(code) != totpDigits {
+		log.Printf("checkCode: the code or the secret of the user %v is invalid", uModel.Id)
+		return
+	}
+	step := time.Now().Unix() / totpPeriod
+	for _, s := range []int64{step - 1, step, step + 1} {
+		if hmac.Equal([]byte(totpCode(secret, s)), []byte(code)) {
+			ok, err = tos.tr.UseTotpStep(uModel.Id, s)
+			return
+		}
+	}
+	log.Printf("checkCode: wrong code of the user %v", uModel.Id)
+	return
+}
+
+func (tos *TotpService) currentUser(sessionId string) (uModel models.User_db, err error) {
+	userId, _, exists, e := tos.sr.GetUserSessionInfo(sessionId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !exists {
+		err = models.ErrUnautorized
+		return
+	}
+	uModel, exists, err = tos.ur.GetUserById(userId)
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+// totpCode is the HOTP value (RFC 4226) of the time step
+func totpCode(secret []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	h := hmac.New(sha1.New, secret)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// newRecoveryCodes returns the codes like "k3j5a-9xq2m" and their hashes to store
+func newRecoveryCodes() (codes models.RecoveryCodes, hashes []string, err error) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 7)
+		_, err = rand.Read(b)
+		if err != nil {
+			log.Printf("newRecoveryCodes: %v", err)
+			err = models.ErrServerError
+			return
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+		codes.Codes = append(codes.Codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, hashToken(code))
+	}
+	return
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
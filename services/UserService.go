@@ -2,33 +2,66 @@ package services
 
 import (
 	"log"
+	"net/mail"
+	"regexp"
+	"strings"
 	"time"
+	"toyStore/entities"
 	"toyStore/models"
 	"toyStore/repository"
+	"unicode/utf8"
 )
 
 type UserService struct {
-	ur repository.UserRepository
-	sr repository.SessionRepository
+	ur  repository.UserRepository
+	sr  repository.SessionRepository
+	rr  repository.RoleRepository
+	evs EmailVerificationService
+	las LoginAttemptService
+	tos TotpService
 }
 
-func NewUserService(uRepo repository.UserRepository, sRepo repository.SessionRepository) UserService {
+func NewUserService(uRepo repository.UserRepository, sRepo repository.SessionRepository, rRepo repository.RoleRepository, emailVerification EmailVerificationService, loginAttempts LoginAttemptService, totp TotpService) UserService {
 	return UserService{
-		ur: uRepo,
-		sr: sRepo,
+		ur:  uRepo,
+		sr:  sRepo,
+		rr:  rRepo,
+		evs: emailVerification,
+		las: loginAttempts,
+		tos: totp,
 	}
 }
 
+const maxDisplayNameLength = 64
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9]{7,15}$`)
+
+// dummyPasswordHash is compared for unknown users, so they take as long to answer as a wrong password
+const dummyPasswordHash = "$2a$08$EWB8G4RvLMZ0Xtm/qZ3iTuFC/r5M2oWUF7Ax.tOrpqrq9ml.GVuUe"
+
 func (us *UserService) SignupRequest(creds models.Credentials) (uModel models.User_db, err error) {
 	var hashedPassword string
 	uModel.Nickname = creds.Username
 	uModel.Password = creds.Password
+	// the email is required, the orders can not be placed until it is verified
+	uModel.Email, err = normalizeEmail(creds.Email)
+	if err != nil {
+		return
+	}
+	err = us.checkEmailFree(uModel.Email, 0)
+	if err != nil {
+		return
+	}
 	if creds.Role == "" {
-		creds.Role = "user"
+		creds.Role = roleUser
 	}
 	uModel.Role = creds.Role
 
 	var ex bool
+	err = us.checkRole(uModel.Role)
+	if err != nil {
+		return
+	}
 	_, ex, err = us.ur.GetUserByName(uModel.Nickname)
 	if err != nil {
 		return
@@ -47,51 +80,157 @@ func (us *UserService) SignupRequest(creds models.Credentials) (uModel models.Us
 	if err != nil {
 		return
 	}
+	// the user is created anyway, the letter can be requested again
+	if e := us.evs.SendVerification(uModel); e != nil {
+		log.Printf("SignupRequest: verification letter is not sent: %v", e)
+	}
 	return
 }
 
-func (us *UserService) SigninRequest(name, password string) (uModel models.User_db, sessionId string, err error) {
-	uModel.Nickname = name
-	uModel.Password = password
+// SigninRequest answers ErrUnautorized both for an unknown user and a wrong password.
+// Failed attempts are counted per username and per ip, see LoginAttemptService.
+// With 2FA there is no session yet, the mfaToken is exchanged for it by SigninTotp.
+func (us *UserService) SigninRequest(name, password string, meta models.SessionMeta) (uModel models.User_db, sessionId string, mfaToken string, err error) {
+	err = us.las.Check(name, meta.Ip)
+	if err != nil {
+		return
+	}
 	var ex bool
-	uModel, ex, err = us.ur.GetUserByName(uModel.Nickname)
+	uModel, ex, err = us.ur.GetUserByName(name)
 	if err != nil {
 		return
 	}
-	if !ex {
-		log.Printf("user not found")
+	hashedPassword := dummyPasswordHash
+	if ex {
+		hashedPassword = uModel.Password
+	}
+	if !us.ur.VerifyPassword(hashedPassword, password) || !ex {
+		log.Printf("wrong username or password")
+		if e := us.las.Fail(name, meta.Ip); e != nil {
+			log.Printf("SigninRequest: %v", e)
+		}
+		err = models.ErrUnautorized
+		return
+	}
+	if uModel.Blocked {
+		log.Printf("user %v is blocked", uModel.Id)
 		err = models.ErrNotAllowed
 		return
 	}
-	if !us.ur.VerifyPassword(uModel.Password, password) {
-		log.Printf("wrong password")
+	// the failures are reset only after the second factor, or the password would allow endless code guesses
+	if uModel.TotpEnabled {
+		mfaToken, err = us.tos.StartChallenge(uModel.Id)
+		return
+	}
+	err = us.las.Succeed(name)
+	if err != nil {
+		return
+	}
+	sessionId, err = us.sr.CreateSession(uModel.Id, uModel.Role, sessionMeta(meta))
+	return
+}
+
+// SigninTotp is the second sign-in step, wrong codes are counted as failed sign-ins of the account
+func (us *UserService) SigninTotp(req models.TotpSigninRequest, meta models.SessionMeta) (sessionId string, err error) {
+	userId, ex, e := us.tos.ChallengeUser(req.MfaToken)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex {
+		log.Printf("SigninTotp: the sign-in token is invalid or expired")
+		err = models.ErrUnautorized
+		return
+	}
+	uModel, ex, e := us.ur.GetUserById(userId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex || uModel.Blocked || !uModel.TotpEnabled {
+		err = models.ErrUnautorized
+		return
+	}
+	err = us.las.Check(uModel.Nickname, meta.Ip)
+	if err != nil {
+		return
+	}
+	ok, err := us.tos.VerifySecondFactor(uModel, req.Code, req.RecoveryCode)
+	if err != nil {
+		return
+	}
+	if ok {
+		ok, err = us.tos.FinishChallenge(req.MfaToken)
+		if err != nil {
+			return
+		}
+	}
+	if !ok {
+		if e := us.las.Fail(uModel.Nickname, meta.Ip); e != nil {
+			log.Printf("SigninTotp: %v", e)
+		}
 		err = models.ErrUnautorized
 		return
 	}
-	sessionId, err = us.sr.CreateSession(uModel.Id, uModel.Role)
+	err = us.las.Succeed(uModel.Nickname)
+	if err != nil {
+		return
+	}
+	sessionId, err = us.sr.CreateSession(uModel.Id, uModel.Role, sessionMeta(meta))
+	if err != nil {
+		return
+	}
+	err = us.sr.SetSessionMfa(sessionId)
 	return
 }
 
 func (us *UserService) RefreshRequest(sessionId string) (err error) {
-	err = us.sr.RefreshSession(sessionId, 30*time.Minute)
+	// the sessions of tokens live as long as the refresh token, they are extended by /users/token/refresh
+	if strings.HasPrefix(sessionId, models.TokenSessionPrefix) {
+		err = models.ErrNotAllowed
+		return
+	}
+	err = us.sr.RefreshSession(sessionId, models.SessionTtl)
 	return
 }
 
-func (us *UserService) CreateUserRequest(creds models.Credentials) (err error) {
+// CreateUserRequest creates a user of any role the staff user is allowed to grant
+func (us *UserService) CreateUserRequest(actorId int, creds models.Credentials) (err error) {
+	if creds.Role == "" {
+		creds.Role = roleUser
+	}
+	err = us.checkRoleGrant(actorId, creds.Role)
+	if err != nil {
+		return
+	}
 	_, err = us.SignupRequest(creds)
 	return
 }
 
-func (us *UserService) CheckAccess(sessionId string) (access bool, err error) {
-	_, role, exists, e := us.sr.GetUserSessionInfo(sessionId)
-	if e != nil {
-		err = e
+// CheckPermission checks that the role of the session has the permission, exists is false without a session.
+// When the role requires 2FA the session has to be signed in with it.
+func (us *UserService) CheckPermission(sessionId string, permission string) (exists bool, access bool, err error) {
+	_, role, exists, err := us.sr.GetUserSessionInfo(sessionId)
+	if err != nil || !exists {
 		return
 	}
-	if !exists || role != "manager" {
+	access, err = us.rr.HasPermission(role, permission)
+	if err != nil || !access || !us.tos.RoleRequiresTotp(role) {
 		return
 	}
-	access = true
+	access, err = us.sr.GetSessionMfa(sessionId)
+	if err == nil && !access {
+		log.Printf("session of the role %v is not signed in with 2FA", role)
+	}
+	return
+}
+
+// SessionUserId returns the user of the session, ErrUnautorized without a session
+func (us *UserService) SessionUserId(sessionId string) (userId int, err error) {
+	userId, _, exists, err := us.sr.GetUserSessionInfo(sessionId)
+	if err == nil && !exists {
+		err = models.ErrUnautorized
+	}
 	return
 }
 
@@ -144,6 +283,396 @@ func (us *UserService) ChangePasswordRequest(sessionId string, oldPass string, n
 		return
 	}
 
-	err = us.sr.DeleteSession(sessionId)
+	// a stolen session must not outlive the old password, the current one is signed out too
+	err = us.sr.RevokeUserSessions(userId)
+	return
+}
+
+// GetSessions lists the sessions of the current user, the current one is marked
+func (us *UserService) GetSessions(sessionId string) (sessions []models.Session_db, err error) {
+	userId, _, _, e := us.sr.GetUserSessionInfo(sessionId)
+	if e != nil {
+		err = e
+		return
+	}
+	sessions, err = us.sr.GetUserSessions(userId, sessionId)
+	return
+}
+
+// DeleteSession signs out one of the sessions of the current user
+func (us *UserService) DeleteSession(sessionId string, publicId string) (err error) {
+	userId, _, _, e := us.sr.GetUserSessionInfo(sessionId)
+	if e != nil {
+		err = e
+		return
+	}
+	err = us.sr.DeleteUserSession(userId, publicId)
+	return
+}
+
+const (
+	defaultUsersPerPage = 20
+	maxUsersPerPage     = 100
+)
+
+func (us *UserService) SearchUsers(data models.UserSearchData) (list entities.UserList, err error) {
+	if data.Page <= 0 {
+		data.Page = 1
+	}
+	if data.PerPage <= 0 {
+		data.PerPage = defaultUsersPerPage
+	}
+	if data.PerPage > maxUsersPerPage {
+		data.PerPage = maxUsersPerPage
+	}
+	data.Query = strings.TrimSpace(data.Query)
+	list.Users, list.Total, err = us.ur.SearchUsers(data)
+	list.Page = data.Page
+	list.PerPage = data.PerPage
+	return
+}
+
+func (us *UserService) GetUser(userId int) (user models.UserInfo, err error) {
+	uModel, ex, e := us.ur.GetUserById(userId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex {
+		err = models.ErrNotFoundError
+		return
+	}
+	user = uModel.Info()
+	return
+}
+
+// SetUserRole changes the role of another user. The role is kept in the sessions,
+// so the sessions are revoked and the user signs in again with the new role.
+// Both the current and the new role of the user have to be ones the staff user can grant.
+func (us *UserService) SetUserRole(actorId int, userId int, role string) (err error) {
+	if actorId == userId {
+		log.Printf("user %v can not change their own account", userId)
+		err = models.ErrNotAllowed
+		return
+	}
+	uModel, ex, err := us.ur.GetUserById(userId)
+	if err != nil {
+		return
+	}
+	if !ex {
+		err = models.ErrNotFoundError
+		return
+	}
+	err = us.checkRoleGrant(actorId, role)
+	if err != nil {
+		return
+	}
+	err = us.checkRoleGrant(actorId, uModel.Role)
+	if err != nil {
+		return
+	}
+	err = us.ur.SetUserRole(userId, role)
+	if err != nil {
+		return
+	}
+	err = us.sr.RevokeUserSessions(userId)
+	return
+}
+
+// SetUserBlocked blocks or unblocks another user, a blocked user is signed out everywhere
+func (us *UserService) SetUserBlocked(sessionId string, userId int, blocked bool) (err error) {
+	err = us.checkNotSelf(sessionId, userId)
+	if err != nil {
+		return
+	}
+	err = us.ur.SetUserBlocked(userId, blocked)
+	if err != nil || !blocked {
+		return
+	}
+	err = us.sr.RevokeUserSessions(userId)
+	return
+}
+
+// DeleteUser anonymizes the user instead of deleting the row, the orders stay for accounting
+func (us *UserService) DeleteUser(sessionId string, userId int) (err error) {
+	err = us.checkNotSelf(sessionId, userId)
+	if err != nil {
+		return
+	}
+	err = us.ur.AnonymizeUser(userId)
+	if err != nil {
+		return
+	}
+	err = us.sr.RevokeUserSessions(userId)
+	return
+}
+
+// GetLockedAccounts returns the accounts locked after failed sign-ins with the ids of existing users
+func (us *UserService) GetLockedAccounts() (locks []models.LoginLock_db, err error) {
+	locks, err = us.las.GetLockedAccounts()
+	if err != nil {
+		return
+	}
+	for i := range locks {
+		uModel, ex, e := us.ur.GetUserByName(locks[i].Username)
+		if e != nil {
+			err = e
+			return
+		}
+		if ex {
+			locks[i].UserId = uModel.Id
+		}
+	}
+	return
+}
+
+// UnlockUser lifts the sign-in lock of the user before it expires
+func (us *UserService) UnlockUser(userId int) (err error) {
+	uModel, ex, e := us.ur.GetUserById(userId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex {
+		err = models.ErrNotFoundError
+		return
+	}
+	err = us.las.Unlock(uModel.Nickname)
+	return
+}
+
+// ResetUserTotp turns off 2FA of another user who lost the device and signs the user out
+func (us *UserService) ResetUserTotp(sessionId string, userId int) (err error) {
+	err = us.checkNotSelf(sessionId, userId)
+	if err != nil {
+		return
+	}
+	err = us.tos.Reset(userId)
+	if err != nil {
+		return
+	}
+	err = us.sr.RevokeUserSessions(userId)
+	return
+}
+
+// checkNotSelf does not let a manager lock themselves out
+func (us *UserService) checkNotSelf(sessionId string, userId int) (err error) {
+	currentId, _, _, e := us.sr.GetUserSessionInfo(sessionId)
+	if e != nil {
+		err = e
+		return
+	}
+	if currentId == userId {
+		log.Printf("user %v can not change their own account", userId)
+		err = models.ErrNotAllowed
+	}
+	return
+}
+
+// checkRoleGrant checks that the staff user may give the role: with roles:manage any role,
+// otherwise only a role without permissions the staff user's own role lacks
+func (us *UserService) checkRoleGrant(actorId int, role string) (err error) {
+	target, ex, err := us.rr.GetRole(role)
+	if err != nil {
+		return
+	}
+	if !ex {
+		log.Printf("role %v does not exist", role)
+		err = models.ErrBadRequest
+		return
+	}
+	actor, ex, err := us.ur.GetUserById(actorId)
+	if err != nil {
+		return
+	}
+	if !ex {
+		err = models.ErrUnautorized
+		return
+	}
+	own, _, err := us.rr.GetRole(actor.Role)
+	if err != nil {
+		return
+	}
+	if statusIn(models.PermRolesManage, own.Permissions) {
+		return
+	}
+	for _, v := range target.Permissions {
+		if !statusIn(v, own.Permissions) {
+			log.Printf("role %v can not grant the role %v with the permission %v", actor.Role, role, v)
+			err = models.ErrNotAllowed
+			return
+		}
+	}
+	return
+}
+
+func (us *UserService) checkRole(role string) (err error) {
+	_, ex, e := us.rr.GetRole(role)
+	if e != nil {
+		err = e
+		return
+	}
+	if !ex {
+		log.Printf("role %v does not exist", role)
+		err = models.ErrBadRequest
+	}
+	return
+}
+
+// GetProfile returns the current user without the password
+func (us *UserService) GetProfile(sessionId string) (user models.UserInfo, err error) {
+	uModel, err := us.currentUser(sessionId)
+	if err != nil {
+		return
+	}
+	user = uModel.Info()
+	return
+}
+
+// UpdateProfile changes the sent fields of the current user. A new email has to be verified again,
+// the verification letter is sent to it.
+func (us *UserService) UpdateProfile(sessionId string, req models.ProfileUpdateRequest) (user models.UserInfo, err error) {
+	uModel, err := us.currentUser(sessionId)
+	if err != nil {
+		return
+	}
+	emailChanged := false
+	if req.Email != nil {
+		var email string
+		email, err = normalizeEmail(*req.Email)
+		if err != nil {
+			return
+		}
+		if email != uModel.Email {
+			err = us.checkEmailFree(email, uModel.Id)
+			if err != nil {
+				return
+			}
+			uModel.Email = email
+			uModel.EmailVerifiedAt = nil
+			emailChanged = true
+		}
+	}
+	if req.DisplayName != nil {
+		uModel.DisplayName = strings.TrimSpace(*req.DisplayName)
+		if utf8.RuneCountInString(uModel.DisplayName) > maxDisplayNameLength {
+			log.Printf("UpdateProfile: display name is too long")
+			err = models.ErrBadRequest
+			return
+		}
+	}
+	if req.Phone != nil {
+		uModel.Phone = strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(*req.Phone)
+		if uModel.Phone != "" && !phonePattern.MatchString(uModel.Phone) {
+			log.Printf("UpdateProfile: phone %q is invalid", *req.Phone)
+			err = models.ErrBadRequest
+			return
+		}
+	}
+	if req.BirthDate != nil {
+		uModel.BirthDate = nil
+		if *req.BirthDate != "" {
+			birthDate, e := time.Parse(models.BirthDateLayout, *req.BirthDate)
+			if e != nil || birthDate.Year() < 1900 || birthDate.After(time.Now()) {
+				log.Printf("UpdateProfile: birth date %q is invalid", *req.BirthDate)
+				err = models.ErrBadRequest
+				return
+			}
+			uModel.BirthDate = &birthDate
+		}
+	}
+	err = us.ur.UpdateProfile(uModel)
+	if err != nil {
+		return
+	}
+	if emailChanged {
+		if e := us.evs.SendVerification(uModel); e != nil {
+			log.Printf("UpdateProfile: verification letter is not sent: %v", e)
+		}
+	}
+	user = uModel.Info()
+	return
+}
+
+// SendEmailVerification sends the verification letter of the current user again
+func (us *UserService) SendEmailVerification(sessionId string) (err error) {
+	uModel, err := us.currentUser(sessionId)
+	if err != nil {
+		return
+	}
+	err = us.evs.SendVerification(uModel)
+	return
+}
+
+func (us *UserService) VerifyEmail(token string) (err error) {
+	err = us.evs.VerifyEmail(token)
+	return
+}
+
+func (us *UserService) currentUser(sessionId string) (uModel models.User_db, err error) {
+	userId, _, exists, e := us.sr.GetUserSessionInfo(sessionId)
+	if e != nil {
+		err = e
+		return
+	}
+	if !exists {
+		err = models.ErrUnautorized
+		return
+	}
+	uModel, exists, err = us.ur.GetUserById(userId)
+	if err != nil {
+		return
+	}
+	if !exists {
+		err = models.ErrNotFoundError
+	}
+	return
+}
+
+// checkEmailFree checks that the email does not belong to another user
+func (us *UserService) checkEmailFree(email string, userId int) (err error) {
+	owner, ex, e := us.ur.GetUserByEmail(email)
+	if e != nil {
+		err = e
+		return
+	}
+	if ex && owner.Id != userId {
+		log.Printf("email is already used")
+		err = models.ErrNotAllowed
+	}
+	return
+}
+
+const maxUserAgentLength = 512
+
+// sessionMeta cuts the user agent and names the kind of the device by it
+func sessionMeta(meta models.SessionMeta) models.SessionMeta {
+	if len(meta.UserAgent) > maxUserAgentLength {
+		meta.UserAgent = strings.ToValidUTF8(meta.UserAgent[:maxUserAgentLength], "")
+	}
+	ua := strings.ToLower(meta.UserAgent)
+	switch {
+	case ua == "":
+		meta.Device = "unknown"
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet"):
+		meta.Device = "tablet"
+	case strings.Contains(ua, "mobile") || strings.Contains(ua, "iphone") || strings.Contains(ua, "android"):
+		meta.Device = "mobile"
+	default:
+		meta.Device = "desktop"
+	}
+	return meta
+}
+
+// normalizeEmail checks that the text is a bare address and lowercases it
+func normalizeEmail(email string) (normalized string, err error) {
+	email = strings.TrimSpace(email)
+	addr, e := mail.ParseAddress(email)
+	if e != nil || addr.Address != email || len(email) > 254 {
+		log.Printf("email %q is invalid", email)
+		err = models.ErrBadRequest
+		return
+	}
+	normalized = strings.ToLower(email)
 	return
 }
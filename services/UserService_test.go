@@ -0,0 +1,93 @@
+package services
+
+import (
+	"testing"
+	"toyStore/models"
+	"toyStore/repository"
+)
+
+type fakeUserRepo struct {
+	repository.UserRepository
+	users map[int]models.User_db
+}
+
+func (f *fakeUserRepo) GetUserById(id int) (models.User_db, bool, error) {
+	uModel, ex := f.users[id]
+	return uModel, ex, nil
+}
+
+func (f *fakeUserRepo) SetUserRole(userId int, role string) (err error) {
+	uModel := f.users[userId]
+	uModel.Role = role
+	f.users[userId] = uModel
+	return
+}
+
+type fakeRoleRepo struct {
+	repository.RoleRepository
+	roles map[string]models.Role_db
+}
+
+func (f *fakeRoleRepo) GetRole(name string) (role models.Role_db, exists bool, err error) {
+	role, exists = f.roles[name]
+	return
+}
+
+type fakeRevokeSessionRepo struct {
+	repository.SessionRepository
+}
+
+func (f *fakeRevokeSessionRepo) RevokeUserSessions(userId int) (err error) {
+	return
+}
+
+func TestSetUserRoleGrant(t *testing.T) {
+	roles := map[string]models.Role_db{
+		"user":    {Name: "user"},
+		"support": {Name: "support", Permissions: []string{models.PermOrdersRead, models.PermUsersManage}},
+		"catalog": {Name: "catalog", Permissions: []string{models.PermCatalogWrite}},
+		"admin":   {Name: "admin", Permissions: []string{models.PermUsersManage, models.PermRolesManage}},
+		"manager": {Name: "manager", Permissions: models.Permissions},
+	}
+	tests := []struct {
+		name       string
+		actorRole  string
+		targetRole string
+		role       string
+		wantErr    error
+	}{
+		{"support gives a role within its permissions", "support", "user", "support", nil},
+		{"support takes its role back", "support", "support", "user", nil},
+		{"support gives a role with other permissions", "support", "user", "catalog", models.ErrNotAllowed},
+		{"support makes a manager", "support", "user", "manager", models.ErrNotAllowed},
+		{"support demotes a manager", "support", "manager", "user", models.ErrNotAllowed},
+		{"roles:manage gives any role", "admin", "user", "manager", nil},
+		{"unknown role", "admin", "user", "owner", models.ErrBadRequest},
+	}
+	for _, tt := range tests {
+		ur := &fakeUserRepo{users: map[int]models.User_db{
+			1: {Id: 1, Role: tt.actorRole},
+			2: {Id: 2, Role: tt.targetRole},
+		}}
+		us := NewUserService(ur, &fakeRevokeSessionRepo{}, &fakeRoleRepo{roles: roles}, EmailVerificationService{}, LoginAttemptService{}, TotpService{})
+		err := us.SetUserRole(1, 2, tt.role)
+		if err != tt.wantErr {
+			t.Errorf("%v: error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		want := tt.targetRole
+		if err == nil {
+			want = tt.role
+		}
+		if ur.users[2].Role != want {
+			t.Errorf("%v: role = %v, want %v", tt.name, ur.users[2].Role, want)
+		}
+	}
+
+	ur := &fakeUserRepo{users: map[int]models.User_db{1: {Id: 1, Role: "support"}}}
+	us := NewUserService(ur, &fakeRevokeSessionRepo{}, &fakeRoleRepo{roles: roles}, EmailVerificationService{}, LoginAttemptService{}, TotpService{})
+	err := us.CreateUserRequest(1, models.Credentials{Username: "boss", Password: "password1", Email: "boss@example.com", Role: "manager"})
+	if err != models.ErrNotAllowed {
+		t.Errorf("create a manager by support: error = %v, want %v", err, models.ErrNotAllowed)
+	}
+}
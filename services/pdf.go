@@ -0,0 +1,129 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// pdfDocument is a minimal PDF writer for text documents. It uses the standard Helvetica fonts,
+// which every PDF viewer has, so nothing is embedded. The fonts cover only WinAnsi characters,
+// Cyrillic is transliterated and other characters are replaced with "?".
+type pdfDocument struct {
+	pages  []*bytes.Buffer
+	page   *bytes.Buffer
+	y      float64
+	width  float64
+	height float64
+	margin float64
+}
+
+func newPDFDocument() *pdfDocument {
+	d := &pdfDocument{
+		width:  595, // A4 in points
+		height: 842,
+		margin: 50,
+	}
+	d.newPage()
+	return d
+}
+
+func (d *pdfDocument) newPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+	d.y = d.height - d.margin
+}
+
+// ensure starts a new page if there is less than h points left
+func (d *pdfDocument) ensure(h float64) {
+	if d.y-h < d.margin {
+		d.newPage()
+	}
+}
+
+// text writes the string at x on the current line
+func (d *pdfDocument) text(x float64, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, d.y, pdfString(s))
+}
+
+// textRight writes the string so that it ends at x, the width is estimated for Helvetica digits
+func (d *pdfDocument) textRight(x float64, size float64, bold bool, s string) {
+	d.text(x-float64(len(s))*size*0.556, size, bold, s)
+}
+
+func (d *pdfDocument) hline() {
+	fmt.Fprintf(d.page, "0.5 w %.2f %.2f m %.2f %.2f l S\n", d.margin, d.y, d.width-d.margin, d.y)
+}
+
+func (d *pdfDocument) down(h float64) {
+	d.y = d.y - h
+}
+
+func (d *pdfDocument) bytes() []byte {
+	var out bytes.Buffer
+	offsets := []int{}
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+	kids := []string{}
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 5+2*i))
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, p := range d.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			d.width, d.height, 6+2*i))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.Len(), p.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, v := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", v)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+var cyrillicTranslit = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh", 'з': "z", 'и': "i", 'й': "y",
+	'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f",
+	'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// pdfString escapes the string for a PDF literal and converts it to WinAnsi (Latin-1 part)
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if t, ok := cyrillicTranslit[unicode.ToLower(r)]; ok {
+			if unicode.IsUpper(r) && t != "" {
+				t = strings.ToUpper(t[:1]) + t[1:]
+			}
+			b.WriteString(t)
+			continue
+		}
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case r >= 160 && r <= 255:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}